@@ -2,16 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/gorilla/mux"
 	"github.com/manuelpepe/tincho/pkg/bots"
+	"github.com/manuelpepe/tincho/pkg/config"
 	"github.com/manuelpepe/tincho/pkg/front"
 	"github.com/manuelpepe/tincho/pkg/metrics"
 	"github.com/manuelpepe/tincho/pkg/middleware"
@@ -23,14 +26,33 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})).With(slog.String("app", "tincho"))
-
-	cfg, err := parseEnv()
+	conf, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatal(fmt.Errorf("error parsing env: %w", err))
+		log.Fatal(fmt.Errorf("error loading config: %w", err))
+	}
+	if err := conf.Validate(); err != nil {
+		log.Fatal(fmt.Errorf("invalid config:\n%w", err))
+	}
+
+	logger := conf.Logger(os.Stdout).With(slog.String("app", "tincho"))
+	logger.Info("Loaded config", "config", conf.Redacted())
+	if conf.SessionSecret == "" {
+		logger.Warn("TINCHO_SESSION_SECRET not set, using a random secret, players won't be able to reconnect after a restart")
 	}
 
+	cfg, err := serviceConfig(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
 	service := tincho.NewService(ctx, cfg)
+	restored, err := service.Restore(logger)
+	if err != nil {
+		logger.Error("error restoring rooms", "err", err)
+	}
+	logger.Info(fmt.Sprintf("Restored %d rooms", restored))
 	handlers, err := newHandlers(logger, &service)
 	if err != nil {
 		log.Fatal(fmt.Errorf("error creating handlers: %w", err))
@@ -41,14 +63,60 @@ func main() {
 	r.Use(metrics.MetricsMiddleware)
 
 	r.Handle("/metrics", handlers.prom)
-	r.HandleFunc("/new", handlers.tincho.NewRoom)
+	r.HandleFunc("/new", service.RateLimited(tincho.LimitNewRoom, handlers.tincho.NewRoom))
 	r.HandleFunc("/list", handlers.tincho.ListRooms)
-	r.HandleFunc("/join", handlers.tincho.JoinRoom)
-	r.HandleFunc("/add-bot", handlers.bots.AddBot)
+	r.HandleFunc("/archive", handlers.tincho.ListArchivedGames)
+	r.HandleFunc("/archive/{id}", handlers.tincho.GetArchivedGame)
+	r.HandleFunc("/replay", handlers.tincho.Replay)
+	r.HandleFunc("/session", handlers.tincho.Session)
+	r.HandleFunc("/invite", handlers.tincho.CreateInvite)
+	r.HandleFunc("/invite/{invite}", handlers.tincho.GetInvite)
+	r.HandleFunc("/join", service.RateLimited(tincho.LimitJoin, handlers.tincho.JoinRoom))
+	r.HandleFunc("/spectate", service.RateLimited(tincho.LimitJoin, handlers.tincho.Spectate))
+	r.HandleFunc("/add-bot", service.RateLimited(tincho.LimitAddBot, handlers.bots.AddBot))
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(service.AdminOnly)
+	admin.HandleFunc("/rooms", handlers.tincho.AdminListRooms).Methods(http.MethodGet)
+	admin.HandleFunc("/rooms/{room}", handlers.tincho.AdminGetRoom).Methods(http.MethodGet)
+	admin.HandleFunc("/rooms/{room}/state", handlers.tincho.AdminRoomState).Methods(http.MethodGet)
+	admin.HandleFunc("/rooms/{room}/close", handlers.tincho.AdminCloseRoom).Methods(http.MethodPost)
+	admin.HandleFunc("/rooms/{room}/kick", handlers.tincho.AdminKickPlayer).Methods(http.MethodPost)
+	admin.HandleFunc("/rooms/{room}/remove-bot", handlers.tincho.AdminRemoveBot).Methods(http.MethodPost)
+	admin.HandleFunc("/rooms/{room}/message", handlers.tincho.AdminSendMessage).Methods(http.MethodPost)
+	admin.HandleFunc("/message", handlers.tincho.AdminSendMessage).Methods(http.MethodPost)
+
 	r.Handle("/{file:.*}", handlers.front)
 
-	slog.Info("Listening on port 5555")
-	log.Fatal(http.ListenAndServe(":5555", r))
+	server := &http.Server{Addr: conf.Listen, Handler: r}
+	go func() {
+		var err error
+		if conf.TLSCert != "" {
+			logger.Info(fmt.Sprintf("Listening on %s with TLS", conf.Listen))
+			err = server.ListenAndServeTLS(conf.TLSCert, conf.TLSKey)
+		} else {
+			logger.Info(fmt.Sprintf("Listening on %s", conf.Listen))
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	stop, stopSignals := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	<-stop.Done()
+
+	logger.Info("Shutting down")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), conf.ShutdownTimeout.Duration())
+	defer cancelShutdown()
+	if err := service.Shutdown(shutdownCtx); err != nil {
+		logger.Error("error stopping rooms", "err", err)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("error stopping http server", "err", err)
+	}
+	logger.Info("Shutdown complete")
 }
 
 type handlers struct {
@@ -71,20 +139,30 @@ func newHandlers(logger *slog.Logger, service *tincho.Service) (handlers, error)
 	}, nil
 }
 
-func parseEnv() (tincho.ServiceConfig, error) {
-	maxRooms, err := strconv.Atoi(os.Getenv("TINCHO_MAX_ROOMS"))
+// serviceConfig creates the store and archive and returns the config of the service.
+func serviceConfig(conf config.Config) (tincho.ServiceConfig, error) {
+	store, err := tincho.NewFileStore(conf.StoreDir)
 	if err != nil {
-		return tincho.ServiceConfig{}, fmt.Errorf("error parsing TINCHO_MAX_ROOMS: %w", err)
+		return tincho.ServiceConfig{}, fmt.Errorf("error creating store: %w", err)
 	}
-
-	roomTimeout, err := strconv.Atoi(os.Getenv("TINCHO_ROOM_TIMEOUT"))
+	archive, err := tincho.NewFileArchive(conf.ArchiveDir)
 	if err != nil {
-		return tincho.ServiceConfig{}, fmt.Errorf("error parsing TINCHO_ROOM_TIMEOUT: %w", err)
+		return tincho.ServiceConfig{}, fmt.Errorf("error creating archive: %w", err)
 	}
-
 	return tincho.ServiceConfig{
-		MaxRooms:    maxRooms,
-		RoomTimeout: time.Duration(roomTimeout) * time.Minute,
+		MaxRooms:          conf.MaxRooms,
+		MaxRoomsPerClient: conf.MaxRoomsPerIP,
+		RoomTimeout:       conf.RoomTimeout.Duration(),
+		IdleTimeout:       conf.IdleTimeout.Duration(),
+		MaxPlayers:        conf.MaxPlayers,
+		DefaultTurnTimer:  conf.TurnTimer.Duration(),
+		Takeover:          bots.Takeover,
+		Store:             store,
+		Archive:           archive,
+		RestartEstimate:   conf.RestartEstimate.Duration(),
+		SessionSecrets:    conf.SessionSecrets(),
+		AllowedOrigins:    conf.AllowedOrigins,
+		Abuse:             conf.Abuse(),
+		AdminToken:        conf.AdminToken,
 	}, nil
-
 }
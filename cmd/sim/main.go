@@ -13,6 +13,7 @@ import (
 
 	"github.com/manuelpepe/tincho/pkg/bots"
 	"github.com/manuelpepe/tincho/pkg/sim"
+	"github.com/manuelpepe/tincho/pkg/tincho"
 )
 
 func easy() bots.Strategy {
@@ -48,14 +49,28 @@ func run(name string, iters int, showLogs bool, strats ...func() bots.Strategy)
 	return nil
 }
 
+func summarizeArchive(dir string) error {
+	archive, err := tincho.NewFileArchive(dir)
+	if err != nil {
+		return err
+	}
+	sum, err := sim.SummarizeArchive(archive, tincho.ArchiveFilter{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("=== ARCHIVE: %s\n%+v\n", dir, sum.AsText())
+	return nil
+}
+
 func main() {
 	var showLogs, all, eve, evm, evh, mvm, mvh, hvh, evmvh bool
-	var pp string
+	var pp, archiveDir string
 	var iters int
 
 	flag.BoolVar(&showLogs, "logs", false, "Show logs")
 	flag.StringVar(&pp, "pp", "", "Run pprof")
 	flag.IntVar(&iters, "iters", 10000, "Number of iterations")
+	flag.StringVar(&archiveDir, "archive", "", "Summarize the games in an archive directory instead of simulating")
 
 	flag.BoolVar(&all, "all", false, "Run all")
 	flag.BoolVar(&eve, "ee", false, "Run Easy vs Easy")
@@ -68,6 +83,13 @@ func main() {
 
 	flag.Parse()
 
+	if archiveDir != "" {
+		if err := summarizeArchive(archiveDir); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
 	allFlags := []bool{eve, evm, evh, mvm, mvh, hvh, evmvh}
 	if all && slices.Contains(allFlags, true) {
 		fmt.Println("Cannot use -all with other sim specific flags")
@@ -26,12 +26,21 @@ type Strategy interface {
 
 type Bot struct {
 	ctx      context.Context
+	cancel   context.CancelFunc
 	conn     *tincho.Connection
 	strategy Strategy
 	logger   *slog.Logger
 }
 
 func NewBot(logger *slog.Logger, ctx context.Context, conn *tincho.Connection, difficulty string) (Bot, error) {
+	strategy, err := NewStrategy(difficulty)
+	if err != nil {
+		return Bot{}, err
+	}
+	return NewBotFromStrategy(logger, ctx, conn, strategy), nil
+}
+
+func NewStrategy(difficulty string) (Strategy, error) {
 	var strategy Strategy
 	switch difficulty {
 	case "easy":
@@ -42,20 +51,19 @@ func NewBot(logger *slog.Logger, ctx context.Context, conn *tincho.Connection, d
 		strategy = NewHardStrategy()
 	// case "expert":
 	default:
-		return Bot{}, fmt.Errorf("invalid difficulty: %s", difficulty)
+		return nil, fmt.Errorf("invalid difficulty: %s", difficulty)
 	}
-	return Bot{
-		ctx:      ctx,
-		conn:     conn,
-		strategy: strategy,
-		logger:   logger,
-	}, nil
-
+	return strategy, nil
 }
 
+// NewBotFromStrategy attaches the bot to the connection, so it can be stopped with
+// Connection.Disconnect even before Start is called.
 func NewBotFromStrategy(logger *slog.Logger, ctx context.Context, conn *tincho.Connection, strategy Strategy) Bot {
+	ctx, cancel := context.WithCancel(ctx)
+	conn.Attach(cancel)
 	return Bot{
 		ctx:      ctx,
+		cancel:   cancel,
 		conn:     conn,
 		strategy: strategy,
 		logger:   logger,
@@ -71,16 +79,26 @@ func (b *Bot) Strategy() Strategy {
 }
 
 func (b *Bot) Start() error {
+	defer b.cancel()
 	b.logger.Info(fmt.Sprintf("Bot %s started", b.conn.ID))
 	for {
 		select {
 		case update := <-b.conn.Updates:
+			if closed, ok := update.(tincho.Update[tincho.UpdateRoomClosedData]); ok {
+				b.logger.Info(fmt.Sprintf("Bot %s finished, room closed", b.conn.ID), "reason", closed.Data.Reason)
+				return nil
+			}
 			action, err := b.RespondToUpdate(b.conn, update)
 			if err != nil {
 				return fmt.Errorf("error responding to update: %w", err)
 			}
 			if action != nil && action.GetType() != "" {
-				b.conn.QueueAction(action)
+				// don't block on a seat the bot was taken out of
+				action.SetPlayerID(b.conn.ID)
+				select {
+				case b.conn.Actions <- action:
+				case <-b.ctx.Done():
+				}
 			}
 		case <-b.ctx.Done():
 			b.logger.Info(fmt.Sprintf("Bot %s finished", b.conn.ID))
@@ -90,7 +108,7 @@ func (b *Bot) Start() error {
 }
 
 func (b *Bot) RespondToUpdate(conn *tincho.Connection, update tincho.TypedUpdate) (tincho.TypedAction, error) {
-	p := tincho.NewMarshalledPlayer(conn.Player)
+	p := conn.Self()
 	switch update.GetType() {
 	case tincho.UpdateTypeGameStart:
 		up, ok := update.(tincho.Update[tincho.UpdateStartNextRoundData])
@@ -1,6 +1,7 @@
 package bots
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -18,6 +19,11 @@ func NewHandlers(logger *slog.Logger, service *tincho.Service) *Handlers {
 }
 
 func (h *Handlers) AddBot(w http.ResponseWriter, r *http.Request) {
+	if h.service.Draining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(tincho.ErrServiceShuttingDown.Error()))
+		return
+	}
 	roomID := r.URL.Query().Get("room")
 	if roomID == "" {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -37,7 +43,8 @@ func (h *Handlers) AddBot(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("error getting room index"))
 		return
 	}
-	conn := tincho.NewConnection(RandomBotName())
+	conn := tincho.NewBotConnection(RandomBotName())
+	conn.BotDifficulty = difficulty
 	newLogger := h.logger.With("player", conn.ID)
 	bot, err := NewBot(newLogger, room.Context, conn, difficulty)
 	if err != nil {
@@ -48,13 +55,20 @@ func (h *Handlers) AddBot(w http.ResponseWriter, r *http.Request) {
 	}
 	go func() {
 		if err := bot.Start(); err != nil {
-			h.logger.Error("Error with bot: %s", err)
+			h.logger.Error("Error with bot", "err", err)
 		}
 		// TODO: If bot fails, broadcasts are stuck because noone is reading from the updates channel.
 		// probably should tear down room and remove players or fallback to some known behaviour with an
 		// error sent to all players.
 	}()
-	if err := h.service.JoinRoom(roomID, conn, h.service.GetRoomPassword(roomID)); err != nil {
+	if err := h.service.JoinRoomWithoutPassword(roomID, conn); errors.Is(err, tincho.ErrTooManyBots) {
+		// stops the bot, it won't get any updates
+		conn.Disconnect()
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(err.Error()))
+		return
+	} else if err != nil {
+		conn.Disconnect()
 		h.logger.Error("Error joining room", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("error joining room"))
@@ -92,6 +92,17 @@ func (s *HardStrategy) getSwap() (game.PlayerID, int, game.PlayerID, int) {
 	return p1, ix1, p2, ix2
 }
 
+func (s *HardStrategy) Resume(player tincho.MarshalledPlayer, state tincho.TakeoverState) {
+	s.firstTurn = false
+	s.lastDiscarded = state.LastDiscarded
+	s.hand = KnownHand(slices.Clone(state.Hand))
+	s.setPlayers(player, state.Players)
+	s.cards = make(map[game.PlayerID]int)
+	for _, p := range state.Players {
+		s.cards[p.ID] = p.CardsInHand
+	}
+}
+
 func (s *HardStrategy) GameStart(player tincho.MarshalledPlayer, data tincho.UpdateStartNextRoundData) (tincho.TypedAction, error) {
 	return &tincho.Action[tincho.ActionWithoutData]{Type: tincho.ActionFirstPeek}, nil
 }
@@ -29,6 +29,11 @@ func (s *MediumStrategy) ResetHand(self tincho.MarshalledPlayer, players []tinch
 	}
 }
 
+func (s *MediumStrategy) Resume(player tincho.MarshalledPlayer, state tincho.TakeoverState) {
+	s.firstTurn = false
+	s.hand = KnownHand(slices.Clone(state.Hand))
+}
+
 func (s *MediumStrategy) GameStart(player tincho.MarshalledPlayer, data tincho.UpdateStartNextRoundData) (tincho.TypedAction, error) {
 	return s.StartNextRound(player, data)
 }
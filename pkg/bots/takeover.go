@@ -0,0 +1,41 @@
+package bots
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/manuelpepe/tincho/pkg/tincho"
+)
+
+// Resumer is implemented by strategies that can pick up a game in progress.
+// Strategies that don't implement it start a takeover knowing nothing.
+type Resumer interface {
+	Resume(player tincho.MarshalledPlayer, state tincho.TakeoverState)
+}
+
+// Takeover implements tincho.TakeoverFunc, playing the seat of a disconnected player
+// with what they knew until the player reconnects.
+func Takeover(ctx context.Context, logger *slog.Logger, conn *tincho.Connection, difficulty string, state tincho.TakeoverState) error {
+	strategy, err := NewStrategy(difficulty)
+	if err != nil {
+		return err
+	}
+	if resumer, ok := strategy.(Resumer); ok {
+		resumer.Resume(tincho.NewMarshalledPlayer(conn.Player), state)
+	}
+	bot := NewBotFromStrategy(logger, ctx, conn, strategy)
+	stopped := make(chan struct{})
+	// the seat is only handed to a player or another bot once this one stopped using the connection
+	conn.Attach(func() {
+		bot.cancel()
+		<-stopped
+	})
+	go func() {
+		defer close(stopped)
+		if err := bot.Start(); err != nil {
+			logger.Error(fmt.Sprintf("Error with takeover bot: %s", err), "err", err)
+		}
+	}()
+	return nil
+}
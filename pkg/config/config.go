@@ -0,0 +1,452 @@
+// Package config loads the configuration of the server. Every option has a default, which can be
+// overridden by a JSON config file, then by environment variables and then by flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/tincho"
+)
+
+type Config struct {
+	Listen string `json:"listen"`
+	// serve https if both are set
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+
+	// debug, info, warn or error
+	LogLevel string `json:"log_level"`
+	// json or text
+	LogFormat string `json:"log_format"`
+
+	MaxRooms      int `json:"max_rooms"`
+	MaxRoomsPerIP int `json:"max_rooms_per_ip"`
+	// max players a room can be created with
+	MaxPlayers  int      `json:"max_players"`
+	RoomTimeout Duration `json:"room_timeout"`
+	// time without players joining or playing before a room is closed, 0 disables it
+	IdleTimeout Duration `json:"idle_timeout"`
+	// turn timer of rooms created without one, 0 disables it
+	TurnTimer Duration `json:"turn_timer"`
+
+	AllowedOrigins []string `json:"allowed_origins"`
+
+	LimitNewRoom   RateLimit `json:"limit_new_room"`
+	LimitJoin      RateLimit `json:"limit_join"`
+	LimitAddBot    RateLimit `json:"limit_add_bot"`
+	LimitActions   RateLimit `json:"limit_actions"`
+	MaxBotsPerRoom int       `json:"max_bots_per_room"`
+	BanAfter       int       `json:"ban_after"`
+	BanWindow      Duration  `json:"ban_window"`
+	BanDuration    Duration  `json:"ban_duration"`
+
+	StoreDir   string `json:"store_dir"`
+	ArchiveDir string `json:"archive_dir"`
+
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	RestartEstimate Duration `json:"restart_estimate"`
+
+	SessionSecret          string   `json:"session_secret"`
+	PreviousSessionSecrets []string `json:"previous_session_secrets"`
+	// bearer token of the admin API, disabled if empty
+	AdminToken string `json:"admin_token"`
+}
+
+func Default() Config {
+	return Config{
+		Listen:          ":5555",
+		LogLevel:        "info",
+		LogFormat:       "json",
+		MaxRooms:        10,
+		MaxPlayers:      tincho.MAX_PLAYERS,
+		RoomTimeout:     Duration(60 * time.Minute),
+		IdleTimeout:     Duration(15 * time.Minute),
+		LimitNewRoom:    RateLimit{Burst: 5, Interval: time.Minute},
+		LimitJoin:       RateLimit{Burst: 20, Interval: 3 * time.Second},
+		LimitAddBot:     RateLimit{Burst: 10, Interval: 6 * time.Second},
+		LimitActions:    RateLimit{Burst: 20, Interval: 500 * time.Millisecond},
+		MaxBotsPerRoom:  6,
+		BanAfter:        20,
+		BanWindow:       Duration(time.Minute),
+		BanDuration:     Duration(15 * time.Minute),
+		StoreDir:        "data/rooms",
+		ArchiveDir:      "data/archive",
+		ShutdownTimeout: Duration(10 * time.Second),
+		RestartEstimate: Duration(time.Minute),
+	}
+}
+
+// Load builds the config from the defaults, the file given with the -config flag or TINCHO_CONFIG,
+// the environment and the flags in args, in that order.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	cfg := Default()
+	path := getenv("TINCHO_CONFIG")
+	if flagPath, ok := findFlag(args, "config"); ok {
+		path = flagPath
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.loadEnv(getenv); err != nil {
+		return Config{}, err
+	}
+	fs := cfg.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening config file: %w", err)
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// options returns every option that can be set from the environment and flags,
+// with the environment variable, the flag and its usage.
+func (c *Config) options() []option {
+	return []option{
+		{"TINCHO_LISTEN", "listen", "address to listen on", (*stringValue)(&c.Listen)},
+		{"TINCHO_TLS_CERT", "tls-cert", "TLS certificate file, serves https if set with -tls-key", (*stringValue)(&c.TLSCert)},
+		{"TINCHO_TLS_KEY", "tls-key", "TLS key file", (*stringValue)(&c.TLSKey)},
+		{"TINCHO_LOG_LEVEL", "log-level", "debug, info, warn or error", (*stringValue)(&c.LogLevel)},
+		{"TINCHO_LOG_FORMAT", "log-format", "json or text", (*stringValue)(&c.LogFormat)},
+		{"TINCHO_MAX_ROOMS", "max-rooms", "rooms open at the same time", (*intValue)(&c.MaxRooms)},
+		{"TINCHO_MAX_ROOMS_PER_IP", "max-rooms-per-ip", "rooms each IP can have open, 0 for no limit", (*intValue)(&c.MaxRoomsPerIP)},
+		{"TINCHO_MAX_PLAYERS", "max-players", "max players a room can be created with", (*intValue)(&c.MaxPlayers)},
+		{"TINCHO_ROOM_TIMEOUT", "room-timeout", "time before rooms are closed, in minutes or as a duration", &minutes{&c.RoomTimeout}},
+		{"TINCHO_IDLE_TIMEOUT", "idle-timeout", "time without players joining or playing before rooms are closed, in seconds or as a duration, 0 disables it", &seconds{&c.IdleTimeout}},
+		{"TINCHO_TURN_TIMER", "turn-timer", "turn timer of rooms created without one, in seconds or as a duration", &seconds{&c.TurnTimer}},
+		{"TINCHO_ALLOWED_ORIGINS", "allowed-origins", "comma separated origins allowed to open websockets, * for any", (*listValue)(&c.AllowedOrigins)},
+		{"TINCHO_LIMIT_NEW_ROOM", "limit-new-room", "rooms each IP can create, as burst/interval", &c.LimitNewRoom},
+		{"TINCHO_LIMIT_JOIN", "limit-join", "joins of each IP, as burst/interval", &c.LimitJoin},
+		{"TINCHO_LIMIT_ADD_BOT", "limit-add-bot", "bots each IP can add, as burst/interval", &c.LimitAddBot},
+		{"TINCHO_LIMIT_ACTIONS", "limit-actions", "actions of each connection, as burst/interval", &c.LimitActions},
+		{"TINCHO_MAX_BOTS_PER_ROOM", "max-bots-per-room", "bots each room can have, 0 for no limit", (*intValue)(&c.MaxBotsPerRoom)},
+		{"TINCHO_BAN_AFTER", "ban-after", "times a client can go over the limits before being banned, 0 disables bans", (*intValue)(&c.BanAfter)},
+		{"TINCHO_BAN_WINDOW", "ban-window", "time in which going over the limits counts for a ban, in seconds or as a duration", &seconds{&c.BanWindow}},
+		{"TINCHO_BAN_DURATION", "ban-duration", "time clients are banned for, in seconds or as a duration", &seconds{&c.BanDuration}},
+		{"TINCHO_STORE_DIR", "store-dir", "directory rooms are saved to", (*stringValue)(&c.StoreDir)},
+		{"TINCHO_ARCHIVE_DIR", "archive-dir", "directory finished games are archived to", (*stringValue)(&c.ArchiveDir)},
+		{"TINCHO_SHUTDOWN_TIMEOUT", "shutdown-timeout", "time given to the server to shut down, in seconds or as a duration", &seconds{&c.ShutdownTimeout}},
+		{"TINCHO_RESTART_ESTIMATE", "restart-estimate", "time the server is expected to be down when restarting, in seconds or as a duration", &seconds{&c.RestartEstimate}},
+		// secrets are only read from the file and the environment, flags are visible to every user
+		{"TINCHO_SESSION_SECRET", "", "", (*stringValue)(&c.SessionSecret)},
+		{"TINCHO_PREVIOUS_SESSION_SECRETS", "", "", (*listValue)(&c.PreviousSessionSecrets)},
+		{"TINCHO_ADMIN_TOKEN", "", "", (*stringValue)(&c.AdminToken)},
+	}
+}
+
+type option struct {
+	env   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+func (c *Config) loadEnv(getenv func(string) string) error {
+	errs := make([]error, 0)
+	for _, opt := range c.options() {
+		if value := getenv(opt.env); value != "" {
+			if err := opt.value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("error parsing %s: %w", opt.env, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.String("config", "", "JSON config file")
+	for _, opt := range c.options() {
+		if opt.flag == "" {
+			continue
+		}
+		fs.Var(opt.value, opt.flag, fmt.Sprintf("%s (env %s)", opt.usage, opt.env))
+	}
+	return fs
+}
+
+// findFlag returns the value of a flag before the rest of the flags are parsed.
+func findFlag(args []string, name string) (string, bool) {
+	for ix, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimLeft(arg, "-")
+		if arg == name && ix+1 < len(args) {
+			return args[ix+1], true
+		}
+		if value, ok := strings.CutPrefix(arg, name+"="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Validate returns every problem found in the config.
+func (c Config) Validate() error {
+	errs := make([]error, 0)
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Listen != "", "listen address can't be empty")
+	check((c.TLSCert == "") == (c.TLSKey == ""), "tls cert and key should be set together")
+	for _, file := range []string{c.TLSCert, c.TLSKey} {
+		if file != "" {
+			_, err := os.Stat(file)
+			check(err == nil, "can't read TLS file: %v", err)
+		}
+	}
+	_, err := c.Level()
+	check(err == nil, "%v", err)
+	check(c.LogFormat == "json" || c.LogFormat == "text", "log format should be json or text, got %q", c.LogFormat)
+	check(c.MaxRooms > 0, "max rooms should be greater than 0")
+	check(c.MaxRoomsPerIP >= 0, "max rooms per IP can't be negative")
+	check(c.MaxPlayers >= 2 && c.MaxPlayers <= tincho.MAX_PLAYERS, "max players should be between 2 and %d", tincho.MAX_PLAYERS)
+	check(c.RoomTimeout > 0, "room timeout should be greater than 0")
+	check(c.IdleTimeout >= 0, "idle timeout can't be negative")
+	check(c.TurnTimer >= 0 && c.TurnTimer.Duration() <= tincho.MAX_TURN_TIMER*time.Second, "turn timer should be between 0 and %d seconds", tincho.MAX_TURN_TIMER)
+	for _, limit := range []RateLimit{c.LimitNewRoom, c.LimitJoin, c.LimitAddBot, c.LimitActions} {
+		check(limit.Burst >= 0 && limit.Interval >= 0, "rate limits can't be negative")
+	}
+	check(c.MaxBotsPerRoom >= 0, "max bots per room can't be negative")
+	check(c.BanAfter >= 0, "ban after can't be negative")
+	check(c.BanAfter == 0 || (c.BanWindow > 0 && c.BanDuration > 0), "ban window and duration should be greater than 0 if bans are enabled")
+	check(c.StoreDir != "", "store dir can't be empty")
+	check(c.ArchiveDir != "", "archive dir can't be empty")
+	check(c.ShutdownTimeout >= 0, "shutdown timeout can't be negative")
+	check(c.RestartEstimate >= 0, "restart estimate can't be negative")
+	return errors.Join(errs...)
+}
+
+func (c Config) Level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", c.LogLevel)
+	}
+	return level, nil
+}
+
+// Logger returns a logger writing to w with the configured level and format.
+func (c Config) Logger(w io.Writer) *slog.Logger {
+	level, _ := c.Level()
+	opts := &slog.HandlerOptions{Level: level}
+	if c.LogFormat == "text" {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// Redacted returns a copy of the config without secrets, to be printed.
+func (c Config) Redacted() Config {
+	if c.SessionSecret != "" {
+		c.SessionSecret = "<redacted>"
+	}
+	if c.AdminToken != "" {
+		c.AdminToken = "<redacted>"
+	}
+	if len(c.PreviousSessionSecrets) > 0 {
+		c.PreviousSessionSecrets = []string{fmt.Sprintf("<%d redacted>", len(c.PreviousSessionSecrets))}
+	}
+	return c
+}
+
+// SessionSecrets returns the secret used to sign sessions followed by the previous ones.
+func (c Config) SessionSecrets() [][]byte {
+	secrets := [][]byte{[]byte(c.SessionSecret)}
+	for _, secret := range c.PreviousSessionSecrets {
+		secrets = append(secrets, []byte(secret))
+	}
+	return secrets
+}
+
+// Abuse returns the rate limits and bans for the service.
+func (c Config) Abuse() tincho.AbuseConfig {
+	return tincho.AbuseConfig{
+		NewRoom:        tincho.RateLimit(c.LimitNewRoom),
+		Join:           tincho.RateLimit(c.LimitJoin),
+		AddBot:         tincho.RateLimit(c.LimitAddBot),
+		Actions:        tincho.RateLimit(c.LimitActions),
+		MaxBotsPerRoom: c.MaxBotsPerRoom,
+		BanAfter:       c.BanAfter,
+		BanWindow:      c.BanWindow.Duration(),
+		BanDuration:    c.BanDuration.Duration(),
+	}
+}
+
+// Duration is a time.Duration written as a string like "1m30s" in config files.
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("durations should be strings like \"1m30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// seconds sets a Duration from a duration or a plain number of seconds, like the environment
+// variables used before the config file existed.
+type seconds struct{ d *Duration }
+
+func (s *seconds) Set(value string) error {
+	return setDuration(s.d, value, time.Second)
+}
+
+func (s *seconds) String() string {
+	if s.d == nil {
+		return ""
+	}
+	return s.d.String()
+}
+
+// minutes is like seconds, for options that used to be set in minutes.
+type minutes struct{ d *Duration }
+
+func (m *minutes) Set(value string) error {
+	return setDuration(m.d, value, time.Minute)
+}
+
+func (m *minutes) String() string {
+	if m.d == nil {
+		return ""
+	}
+	return m.d.String()
+}
+
+func setDuration(d *Duration, value string, unit time.Duration) error {
+	if n, err := strconv.Atoi(value); err == nil {
+		*d = Duration(time.Duration(n) * unit)
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// RateLimit is a tincho.RateLimit written as "burst/interval", like "5/1m".
+type RateLimit tincho.RateLimit
+
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", l.Burst, l.Interval)
+}
+
+func (l *RateLimit) Set(value string) error {
+	burst, interval, found := strings.Cut(value, "/")
+	if !found {
+		return fmt.Errorf("rate limits should be burst/interval, like 5/1m, got %q", value)
+	}
+	n, err := strconv.Atoi(burst)
+	if err != nil {
+		return fmt.Errorf("invalid burst %q", burst)
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return fmt.Errorf("invalid interval %q", interval)
+	}
+	*l = RateLimit{Burst: n, Interval: d}
+	return nil
+}
+
+func (l RateLimit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+func (l *RateLimit) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("rate limits should be strings like \"5/1m\": %w", err)
+	}
+	return l.Set(value)
+}
+
+type stringValue string
+
+func (s *stringValue) Set(value string) error {
+	*s = stringValue(value)
+	return nil
+}
+
+func (s *stringValue) String() string {
+	if s == nil {
+		return ""
+	}
+	return string(*s)
+}
+
+type intValue int
+
+func (i *intValue) Set(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", value)
+	}
+	*i = intValue(n)
+	return nil
+}
+
+func (i *intValue) String() string {
+	if i == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*i))
+}
+
+// listValue is set from a comma separated list.
+type listValue []string
+
+func (l *listValue) Set(value string) error {
+	*l = strings.Split(value, ",")
+	return nil
+}
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
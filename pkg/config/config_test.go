@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"listen": ":8080", "max_rooms": 20, "max_players": 6, "room_timeout": "2h", "limit_join": "5/1s"}`), 0o644)
+	assert.NoError(t, err)
+
+	cfg, err := Load([]string{"-max-players", "4", "-turn-timer", "45s"}, env(map[string]string{
+		"TINCHO_CONFIG":       path,
+		"TINCHO_MAX_ROOMS":    "30",
+		"TINCHO_MAX_PLAYERS":  "8",
+		"TINCHO_ROOM_TIMEOUT": "90",
+	}))
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.Validate())
+
+	// file over defaults
+	assert.Equal(t, ":8080", cfg.Listen)
+	assert.Equal(t, RateLimit{Burst: 5, Interval: time.Second}, cfg.LimitJoin)
+	// env over file, with the room timeout in minutes like before
+	assert.Equal(t, 30, cfg.MaxRooms)
+	assert.Equal(t, 90*time.Minute, cfg.RoomTimeout.Duration())
+	// flags over env
+	assert.Equal(t, 4, cfg.MaxPlayers)
+	assert.Equal(t, 45*time.Second, cfg.TurnTimer.Duration())
+	// untouched defaults
+	assert.Equal(t, "info", cfg.LogLevel)
+	assert.Equal(t, 6, cfg.MaxBotsPerRoom)
+
+	// the flag takes the config file over the env
+	cfg, err = Load([]string{"-config=" + path}, env(map[string]string{"TINCHO_CONFIG": "missing.json"}))
+	assert.NoError(t, err)
+	assert.Equal(t, ":8080", cfg.Listen)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load(nil, env(map[string]string{"TINCHO_MAX_ROOMS": "ten", "TINCHO_LIMIT_JOIN": "5"}))
+	assert.ErrorContains(t, err, "TINCHO_MAX_ROOMS")
+	assert.ErrorContains(t, err, "TINCHO_LIMIT_JOIN")
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"max_room": 20}`), 0o644))
+	_, err = Load([]string{"-config", path}, env(nil))
+	assert.ErrorContains(t, err, "unknown field")
+
+	cfg := Default()
+	cfg.MaxRooms = 0
+	cfg.MaxPlayers = 1
+	cfg.TLSCert = "cert.pem"
+	cfg.LogLevel = "loud"
+	err = cfg.Validate()
+	for _, msg := range []string{"max rooms", "max players", "tls cert and key", "log level"} {
+		assert.ErrorContains(t, err, msg)
+	}
+	assert.NoError(t, Default().Validate())
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.SessionSecret = "secret"
+	cfg.PreviousSessionSecrets = []string{"old", "older"}
+	cfg.AdminToken = "token"
+	redacted := cfg.Redacted()
+	assert.NotEqual(t, "token", redacted.AdminToken)
+	assert.NotContains(t, strings.Join(append(redacted.PreviousSessionSecrets, redacted.SessionSecret), ","), "old")
+	assert.NotEqual(t, "secret", redacted.SessionSecret)
+	assert.Equal(t, "secret", cfg.SessionSecret)
+	assert.Len(t, cfg.SessionSecrets(), 3)
+}
@@ -25,6 +25,9 @@ window.onload = function () {
     /** @type {string | null} */
     var THIS_ROOM = null;
 
+    /** sequence number of the last update recieved, sent when reconnecting to get the missed ones */
+    var LAST_SEQ = 0;
+
     /** @type {SwapBuffer | null} */
     var SWAP_BUFFER = null;
 
@@ -39,6 +42,7 @@ window.onload = function () {
     const createMenuPassword = /** @type {HTMLInputElement} */ (document.getElementById("password"));
     const createMenuUseExtendedDeck = /** @type {HTMLInputElement} */ (document.getElementById("use-extended-deck"));
     const createMenuUseChaosDeck = /** @type {HTMLInputElement} */ (document.getElementById("use-chaos-deck"));
+    const createMenuHardcore = /** @type {HTMLInputElement} */ (document.getElementById("hardcore"));
 
     const menuContainer = document.getElementById("menu-container");
     const mainMenu = document.getElementById("main-menu");
@@ -52,6 +56,14 @@ window.onload = function () {
 
     const selectBotDiff = /** @type {HTMLSelectElement} */ (document.getElementById("bot-diff-select"));
     const buttonAddBot = document.getElementById("btn-add-bot");
+    const buttonInvite = document.getElementById("btn-invite");
+    const inviteInfo = document.getElementById("invite-info");
+
+    // length of the invite codes, room IDs are shorter
+    const INVITE_CODE_LENGTH = 6;
+
+    /** @type {string} invite token from the link the page was opened with */
+    var PENDING_INVITE = "";
 
     const buttonStart = document.getElementById("btn-start");
     const buttonFirstPeek = document.getElementById("btn-first-peek");
@@ -654,8 +666,38 @@ window.onload = function () {
 
     /** @param {UpdateRejoinStateData} data */
     async function handleRejoinState(data) {
-        setStartGameScreen();
         setPlayers(data.players);
+        setCardsInDeck(data.cardsInDeck);
+        if (data.phase == "lobby") {
+            return;
+        }
+        if (data.phase == "ended") {
+            showEndGame(data.rounds);
+            return;
+        }
+        setStartGameScreen();
+        if (data.phase == "first_peek") {
+            const me = data.players.find(p => p.id == THIS_PLAYER);
+            // players that haven't peeked yet may have left on the cut screen
+            if (me && me.pending_first_peek && data.lastCut) {
+                const cut = data.lastCut;
+                await showCut(cut.players, cut.player, cut.withCount, cut.declared, cut.hands);
+            }
+            if (!me || !me.pending_first_peek) {
+                setPlayerPeekedScreen();
+            }
+            for (const p of data.players) {
+                if (!p.pending_first_peek) {
+                    markReady(p.id);
+                }
+            }
+            if (data.lastDiscarded) {
+                setLastDiscarded(data.lastDiscarded)
+            }
+            setCardsInDrawPile(data.cardsInDrawPile);
+            showKnownCards(data.knownCards);
+            return;
+        }
         if (data.lastDiscarded) {
             setLastDiscarded(data.lastDiscarded)
         }
@@ -669,13 +711,28 @@ window.onload = function () {
         }
         setCardsInDeck(data.cardsInDeck);
         setCardsInDrawPile(data.cardsInDrawPile);
+        showKnownCards(data.knownCards);
+    }
 
+    /** @param {KnownCard[]} known */
+    function showKnownCards(known) {
+        /** @type {Object<string, KnownCard[]>} */
+        const byPlayer = {};
+        for (const k of known) {
+            (byPlayer[k.player] ??= []).push(k);
+        }
+        for (const [player, cards] of Object.entries(byPlayer)) {
+            showCards(player, cards.map(k => k.card), cards.map(k => k.position));
+        }
     }
 
     /** @param {MessageEvent<any>} event} */
     function processWSMessage(event) {
         const data = JSON.parse(event.data)
         const msgData = data.data;
+        if (data.seq) {
+            LAST_SEQ = data.seq;
+        }
         console.log("Received message:", data)
         switch (data.type) {
             case "game_config":
@@ -719,22 +776,57 @@ window.onload = function () {
                 break;
             case "rejoin_state":
                 queueActions(async () => await handleRejoinState(msgData));
+                break;
+            case "room_closing":
+                handleRoomClosing(msgData);
+                break;
+            case "room_closed":
+                queueActions(async () => handleRoomClosed(msgData));
+                break;
             default:
                 console.error("Unknown message type", data.type, msgData)
                 break;
         }
     }
 
-    function rejoinLastRoomIfAny() {
-        let sess_cookie = document.cookie.split(';').find(c => c.trim().startsWith('session_token='));
-        if (!sess_cookie) {
+    /** @type {Object<string, string>} */
+    const CLOSE_REASONS = {
+        game_finished: "the game finished",
+        timeout: "it was open for too long",
+        idle: "nobody played for a while",
+        admin: "it was closed by an admin",
+        everyone_left: "everyone left",
+    };
+
+    /** @param {{reason: string, closesAt: string}} data */
+    function handleRoomClosing(data) {
+        const minutes = Math.max(1, Math.round((new Date(data.closesAt).getTime() - Date.now()) / 60000));
+        setError("The room closes in " + minutes + " minutes, finish the round!");
+    }
+
+    /** @param {{reason: string, message?: string, standings: {id: string, points: number}[]}} data */
+    function handleRoomClosed(data) {
+        let message = "The room closed because " + (data.message || CLOSE_REASONS[data.reason] || data.reason) + ".";
+        if (data.standings.length > 0) {
+            message += " Standings: " + data.standings.map(p => p.id + " (" + p.points + ")").join(", ");
+        }
+        // names and the message of the admin are not escaped, so they can't go through setError
+        errorContainer.textContent = message;
+        show(errorContainer);
+    }
+
+    async function rejoinLastRoomIfAny() {
+        // the session cookie can't be read from here, the server tells which room it belongs to
+        const response = await fetch(location.protocol + "//" + location.host + "/session");
+        if (!response.ok) {
             return;
         }
-        let [playerID, roomID, _] = sess_cookie.split('=')[1].split('::');
-        if (!playerID || !roomID) {
+        /** @type {{player: string, room: string}} */
+        const session = await response.json();
+        if (!session.player || !session.room) {
             return;
         }
-        connectToRoom(playerID, roomID, "");
+        connectToRoom(session.player, session.room, "");
     }
 
     /** @param {string | null} message */
@@ -756,19 +848,31 @@ window.onload = function () {
      * @param {string} username 
      * @param {string} roomid 
      * @param {string} password 
+     * @param {string} invite
      * */
-    function connectToRoom(username, roomid, password) {
+    function connectToRoom(username, roomid, password, invite = "") {
         if (!roomid || !username) {
             return false;
         }
         let wsProtocol = location.protocol === "http:" ? "ws" : "wss";
-        conn = new WebSocket(wsProtocol + "://" + location.host + "/join?room=" + roomid + "&player=" + username + "&password=" + password);
+        let url = wsProtocol + "://" + location.host + "/join?room=" + roomid + "&player=" + username + "&password=" + password;
+        if (invite) {
+            url += "&invite=" + encodeURIComponent(invite);
+        }
+        if (roomid === THIS_ROOM && username === THIS_PLAYER && LAST_SEQ > 0) {
+            url += "&last_seq=" + LAST_SEQ;
+        } else {
+            LAST_SEQ = 0;
+        }
+        conn = new WebSocket(url);
         conn.onerror = () => setError("Error connecting to room");
         conn.onclose = () => {
             console.log("connection closed");
             show(menuContainer, "flex");
             hide(buttonStart);
             hide(buttonAddBot);
+            hide(buttonInvite);
+            hide(inviteInfo);
             hide(selectBotDiff);
             setTitle("Tincholi");
         }
@@ -778,6 +882,7 @@ window.onload = function () {
             hide(menuContainer)
             show(buttonStart);
             show(buttonAddBot);
+            show(buttonInvite);
             show(selectBotDiff);
             setTitle("ROOM CODE: " + roomid)
             console.log("connected to room " + roomid);
@@ -815,14 +920,67 @@ window.onload = function () {
                 "deck": {
                     "extended": createMenuUseExtendedDeck.checked,
                     "chaos": createMenuUseChaosDeck.checked,
-                }
+                },
+                "hardcore": createMenuHardcore.checked,
             }),
         })
             .then(response => response.text())
             .then(roomid => connectToRoom(createMenuUsername.value, roomid, password));
     };
 
-    buttonJoinRooom.onclick = () => connectToRoom(joinMenuUsername.value, joinMenuRoomID.value, joinMenuPassword.value);
+    /**
+     * Joins the room of an invite token or code, without the password.
+     * @param {string} username
+     * @param {string} invite
+     */
+    async function joinWithInvite(username, invite) {
+        const response = await fetch(location.protocol + "//" + location.host + "/invite/" + encodeURIComponent(invite));
+        if (!response.ok) {
+            setError("Invalid or expired invite");
+            return;
+        }
+        /** @type {{room: string}} */
+        const data = await response.json();
+        connectToRoom(username, data.room, "", invite);
+    }
+
+    buttonJoinRooom.onclick = () => {
+        const roomOrCode = joinMenuRoomID.value.trim();
+        if (PENDING_INVITE) {
+            joinWithInvite(joinMenuUsername.value, PENDING_INVITE);
+        } else if (roomOrCode.length === INVITE_CODE_LENGTH) {
+            joinWithInvite(joinMenuUsername.value, roomOrCode);
+        } else {
+            connectToRoom(joinMenuUsername.value, roomOrCode, joinMenuPassword.value);
+        }
+    };
+
+    buttonInvite.onclick = async () => {
+        const response = await fetch(location.protocol + "//" + location.host + "/invite", { method: "POST" });
+        if (!response.ok) {
+            setError("Only the room leader can invite players");
+            return false;
+        }
+        /** @type {{token: string, code: string, expires_at: string}} */
+        const invite = await response.json();
+        const link = location.origin + "/?invite=" + encodeURIComponent(invite.token);
+        const expires = new Date(invite.expires_at).toLocaleString();
+        inviteInfo.innerText = "Invite code: " + invite.code + " (valid until " + expires + ")\n" + link;
+        show(inviteInfo);
+        return false;
+    };
+
+    function openInviteLinkIfAny() {
+        const invite = new URLSearchParams(location.search).get("invite");
+        if (!invite) {
+            return;
+        }
+        PENDING_INVITE = invite;
+        hide(mainMenu);
+        hide(joinMenuRoomID);
+        hide(joinMenuPassword);
+        show(menuJoin, "flex");
+    }
 
     buttonAddBot.onclick = () => {
         if (!THIS_ROOM) {
@@ -978,5 +1136,6 @@ window.onload = function () {
     }
 
     startProcessingActions();
+    openInviteLinkIfAny();
     rejoinLastRoomIfAny();
 };
\ No newline at end of file
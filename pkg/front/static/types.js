@@ -3,7 +3,7 @@
 /** @typedef {{player: string, cardPosition: number}} SwapBuffer */
 /** @typedef {{cutter: string, withCount: boolean, declared: number, scores: Object.<string, number>, hands: Object.<string, Card[]>}} Round */
 
-/** @typedef {{cardsInDeck: number}} UpdateGameConfig */
+/** @typedef {{cardsInDeck: number, hardcore: boolean}} UpdateGameConfig */
 /** @typedef {{players: Player[]}} UpdatePlayersChangedData */
 /** @typedef {{players: Player[], topDiscard: Card}} UpdateStartNextRoundData */
 /** @typedef {{player: string, cards: Card[]}} UpdatePlayerFirstPeekedData */
@@ -16,4 +16,5 @@
 /** @typedef {{withCount: boolean, declared: number, player: string, players: Player[], hands: Card[][]}} UpdateCutData */
 /** @typedef {{message: string}} UpdateErrorData */
 /** @typedef {{rounds: Round[]}} UpdateEndGameData */
-/** @typedef {{players: Player[], currentTurn: string, cardInHand: boolean, cardInHandValue: Card|null, cardInHandSource: string|null, lastDiscarded: Card | null, cardsInDeck: number, cardsInDrawPile: number}} UpdateRejoinStateData */
+/** @typedef {{player: string, position: number, card: Card}} KnownCard */
+/** @typedef {{phase: string, players: Player[], config: UpdateGameConfig, currentTurn: string, cardInHand: boolean, cardInHandValue: Card|null, cardInHandSource: string|null, lastDiscarded: Card | null, cardsInDeck: number, cardsInDrawPile: number, knownCards: KnownCard[], rounds: Round[], lastCut: UpdateCutData | null, countdown: number | null, rematchVotes: string[]}} UpdateRejoinStateData */
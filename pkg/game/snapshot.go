@@ -0,0 +1,74 @@
+package game
+
+import "slices"
+
+// Snapshot is the full state of a game, used to persist it and restore it later.
+type Snapshot struct {
+	Rules          Rules      `json:"rules"`
+	Players        []*Player  `json:"players"`
+	Playing        bool       `json:"playing"`
+	CurrentTurn    int        `json:"current_turn"`
+	DrawPile       Deck       `json:"draw_pile"`
+	DiscardPile    Deck       `json:"discard_pile"`
+	BaseDeck       Deck       `json:"base_deck"`
+	TotalTurns     int        `json:"total_turns"`
+	TotalRounds    int        `json:"total_rounds"`
+	RoundHistory   []Round    `json:"round_history"`
+	PendingStorage Card       `json:"pending_storage"`
+	LastDrawSource DrawSource `json:"last_draw_source"`
+}
+
+// Snapshot returns a copy of the game state that doesn't share memory with the game.
+func (t *Tincho) Snapshot() Snapshot {
+	players := make([]*Player, 0, len(t.players))
+	for _, p := range t.players {
+		players = append(players, &Player{
+			ID:               p.ID,
+			Points:           p.Points,
+			PendingFirstPeek: p.PendingFirstPeek,
+			Hand:             slices.Clone(p.Hand),
+		})
+	}
+	return Snapshot{
+		Rules:          t.rules,
+		Players:        players,
+		Playing:        t.playing,
+		CurrentTurn:    t.currentTurn,
+		DrawPile:       slices.Clone(t.drawPile),
+		DiscardPile:    slices.Clone(t.discardPile),
+		BaseDeck:       slices.Clone(t.cpyDeck),
+		TotalTurns:     t.totalTurns,
+		TotalRounds:    t.totalRounds,
+		RoundHistory:   slices.Clone(t.roundHistory),
+		PendingStorage: t.pendingStorage,
+		LastDrawSource: t.lastDrawSource,
+	}
+}
+
+// NewTinchoFromSnapshot restores a game. The players in the snapshot are used as they are.
+func NewTinchoFromSnapshot(s Snapshot) *Tincho {
+	t := &Tincho{
+		rules:          s.Rules,
+		players:        s.Players,
+		playing:        s.Playing,
+		currentTurn:    s.CurrentTurn,
+		drawPile:       s.DrawPile,
+		discardPile:    s.DiscardPile,
+		cpyDeck:        s.BaseDeck,
+		totalTurns:     s.TotalTurns,
+		totalRounds:    s.TotalRounds,
+		roundHistory:   s.RoundHistory,
+		pendingStorage: s.PendingStorage,
+		lastDrawSource: s.LastDrawSource,
+	}
+	if t.players == nil {
+		t.players = make([]*Player, 0)
+	}
+	if t.discardPile == nil {
+		t.discardPile = make(Deck, 0)
+	}
+	if t.roundHistory == nil {
+		t.roundHistory = make([]Round, 0)
+	}
+	return t
+}
@@ -2,6 +2,7 @@ package game
 
 import (
 	"errors"
+	"fmt"
 	"slices"
 )
 
@@ -19,6 +20,8 @@ var ErrPlayerNotPendingFirstPeek = errors.New("player not pending first peek")
 var ErrPlayerAlreadyInRoom = errors.New("player already in room")
 var ErrGameAlreadyStarted = errors.New("game already started")
 var ErrNoWinner = errors.New("no winner")
+var ErrPlayerNotFound = errors.New("player not found")
+var ErrNotEnoughPlayers = errors.New("not enough players")
 
 type Round struct {
 	Cutter PlayerID `json:"cutter"`
@@ -30,7 +33,25 @@ type Round struct {
 	Hands  map[PlayerID]Hand `json:"hands"`
 }
 
+// Rules are the game options that can be changed before a game starts.
+type Rules struct {
+	// the game ends when a player goes over this amount of points
+	MaxPoints int `json:"max_points"`
+}
+
+func DefaultRules() Rules {
+	return Rules{MaxPoints: 100}
+}
+
+func (r Rules) Validate() error {
+	if r.MaxPoints < 10 || r.MaxPoints > 1000 {
+		return fmt.Errorf("max points should be between 10 and 1000, got %d", r.MaxPoints)
+	}
+	return nil
+}
+
 type Tincho struct {
+	rules       Rules
 	players     []*Player
 	playing     bool
 	currentTurn int
@@ -50,6 +71,7 @@ type Tincho struct {
 
 func NewTinchoWithDeck(deck Deck) *Tincho {
 	return &Tincho{
+		rules:        DefaultRules(),
 		players:      make([]*Player, 0),
 		playing:      false,
 		drawPile:     deck,
@@ -82,6 +104,11 @@ func (t *Tincho) TotalRounds() int {
 	return t.totalRounds
 }
 
+// RoundHistory returns a copy of the scores of the rounds played so far.
+func (t *Tincho) RoundHistory() []Round {
+	return slices.Clone(t.roundHistory)
+}
+
 func (t *Tincho) LastDiscarded() Card {
 	if len(t.discardPile) == 0 {
 		return Card{}
@@ -148,11 +175,85 @@ func (t *Tincho) AddPlayer(p *Player) error {
 	return nil
 }
 
+// RemovePlayer takes a player out of the game. If the game is in progress, the player's hand is removed
+// from play, the card they were holding (if any) goes to the discard pile and the turn moves to the next player.
+// A game in progress can't be left with less than two players.
+// The first return value reports whether the removed player was the one playing the current turn.
+func (t *Tincho) RemovePlayer(playerID PlayerID) (bool, error) {
+	ix := slices.IndexFunc(t.players, func(p *Player) bool { return p.ID == playerID })
+	if ix == -1 {
+		return false, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+	if !t.playing {
+		t.players = slices.Delete(t.players, ix, ix+1)
+		return false, nil
+	}
+	if len(t.players) <= 2 {
+		return false, ErrNotEnoughPlayers
+	}
+	wasTurn := ix == t.currentTurn
+	if wasTurn && t.pendingStorage != (Card{}) {
+		t.discardPending()
+	}
+	t.players = slices.Delete(t.players, ix, ix+1)
+	if ix < t.currentTurn {
+		t.currentTurn--
+	}
+	t.currentTurn = t.currentTurn % len(t.players)
+	return wasTurn, nil
+}
+
 func (t *Tincho) IsWinConditionMet() bool {
 	for _, p := range t.players {
-		if p.Points > 100 {
+		if p.Points > t.rules.MaxPoints {
 			return true
 		}
 	}
 	return false
 }
+
+// Reset takes the game back to before it started, keeping the same players and deck.
+// The deck is shuffled so the next game is dealt differently.
+func (t *Tincho) Reset() {
+	t.playing = false
+	t.currentTurn = 0
+	t.totalTurns = 0
+	t.totalRounds = 0
+	t.roundHistory = make([]Round, 0)
+	t.pendingStorage = Card{}
+	t.lastDrawSource = ""
+	t.cpyDeck.Shuffle()
+	t.drawPile = slices.Clone(t.cpyDeck)
+	t.discardPile = make(Deck, 0)
+	for _, p := range t.players {
+		p.Points = 0
+		p.PendingFirstPeek = false
+		p.Hand = make(Hand, 0)
+	}
+}
+
+func (t *Tincho) Rules() Rules {
+	return t.rules
+}
+
+// SetRules changes the rules for the next game.
+func (t *Tincho) SetRules(rules Rules) error {
+	if t.playing {
+		return ErrGameAlreadyStarted
+	}
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	t.rules = rules
+	return nil
+}
+
+// SetDeck changes the deck used for the next game.
+func (t *Tincho) SetDeck(deck Deck) error {
+	if t.playing {
+		return ErrGameAlreadyStarted
+	}
+	t.cpyDeck = slices.Clone(deck)
+	t.drawPile = deck
+	return nil
+}
@@ -0,0 +1,125 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestGame(t *testing.T, players ...PlayerID) *Tincho {
+	g := NewTinchoWithDeck(NewDeck())
+	for _, p := range players {
+		assert.NoError(t, g.AddPlayer(NewPlayer(p)))
+	}
+	return g
+}
+
+func TestRemovePlayerBeforeStart(t *testing.T) {
+	g := newTestGame(t, "p1", "p2")
+	wasTurn, err := g.RemovePlayer("p1")
+	assert.NoError(t, err)
+	assert.False(t, wasTurn)
+	assert.Len(t, g.GetPlayers(), 1)
+	_, err = g.RemovePlayer("p1")
+	assert.ErrorIs(t, err, ErrPlayerNotFound)
+}
+
+func TestRemovePlayerDuringGame(t *testing.T) {
+	g := newTestGame(t, "p1", "p2", "p3")
+	_, err := g.StartGame()
+	assert.NoError(t, err)
+	for _, p := range []PlayerID{"p1", "p2", "p3"} {
+		_, err := g.GetFirstPeek(p)
+		assert.NoError(t, err)
+	}
+	drawn, err := g.Draw(DrawSourcePile)
+	assert.NoError(t, err)
+
+	// removing the player in turn discards the pending card and passes the turn
+	wasTurn, err := g.RemovePlayer("p1")
+	assert.NoError(t, err)
+	assert.True(t, wasTurn)
+	assert.Equal(t, PlayerID("p2"), g.PlayerToPlay().ID)
+	assert.Equal(t, drawn, g.LastDiscarded())
+	assert.Equal(t, Card{}, g.GetPendingStorage())
+
+	// a game can't be left with a single player
+	_, err = g.RemovePlayer("p3")
+	assert.ErrorIs(t, err, ErrNotEnoughPlayers)
+}
+
+func TestRemovePlayerBeforeCurrentTurn(t *testing.T) {
+	g := newTestGame(t, "p1", "p2", "p3")
+	_, err := g.StartGame()
+	assert.NoError(t, err)
+	g.currentTurn = 2
+	wasTurn, err := g.RemovePlayer("p1")
+	assert.NoError(t, err)
+	assert.False(t, wasTurn)
+	assert.Equal(t, PlayerID("p3"), g.PlayerToPlay().ID)
+}
+
+func TestReset(t *testing.T) {
+	g := newTestGame(t, "p1", "p2")
+	_, err := g.StartGame()
+	assert.NoError(t, err)
+	for _, p := range []PlayerID{"p1", "p2"} {
+		_, err := g.GetFirstPeek(p)
+		assert.NoError(t, err)
+	}
+	_, _, err = g.Cut(false, 0)
+	assert.NoError(t, err)
+
+	g.Reset()
+	assert.False(t, g.Playing())
+	assert.Equal(t, 0, g.TotalRounds())
+	assert.Equal(t, g.CountBaseDeck(), g.CountDrawPile())
+	assert.Equal(t, 0, g.CountDiscardPile())
+	for _, p := range g.GetPlayers() {
+		assert.Equal(t, 0, p.Points)
+		assert.Empty(t, p.Hand)
+	}
+
+	// same seats can play again
+	_, err = g.StartGame()
+	assert.NoError(t, err)
+	assert.Len(t, g.GetPlayers()[1].Hand, STARTING_HAND_SIZE)
+}
+
+func TestRulesMaxPoints(t *testing.T) {
+	g := newTestGame(t, "p1", "p2")
+	assert.Error(t, g.SetRules(Rules{MaxPoints: 0}))
+	assert.NoError(t, g.SetRules(Rules{MaxPoints: 10}))
+	g.GetPlayers()[0].Points = 11
+	assert.True(t, g.IsWinConditionMet())
+
+	_, err := g.StartGame()
+	assert.NoError(t, err)
+	assert.ErrorIs(t, g.SetRules(DefaultRules()), ErrGameAlreadyStarted)
+	assert.ErrorIs(t, g.SetDeck(NewDeck()), ErrGameAlreadyStarted)
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	g := newTestGame(t, "p1", "p2")
+	_, err := g.StartGame()
+	assert.NoError(t, err)
+	for _, p := range []PlayerID{"p1", "p2"} {
+		_, err := g.GetFirstPeek(p)
+		assert.NoError(t, err)
+	}
+	_, err = g.Draw(DrawSourcePile)
+	assert.NoError(t, err)
+
+	raw, err := json.Marshal(g.Snapshot())
+	assert.NoError(t, err)
+	var snapshot Snapshot
+	assert.NoError(t, json.Unmarshal(raw, &snapshot))
+	restored := NewTinchoFromSnapshot(snapshot)
+	assert.Equal(t, g.Snapshot(), restored.Snapshot())
+
+	// the restored game keeps playing from the same point
+	_, _, err = restored.Discard(-1)
+	assert.NoError(t, err)
+	assert.Equal(t, PlayerID("p2"), restored.PlayerToPlay().ID)
+}
@@ -65,6 +65,13 @@ var (
 		[]string{"reconnection"},
 	)
 
+	spectatorsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tincho_spectators_total",
+			Help: "Tracks the number of spectator connections.",
+		},
+	)
+
 	websocketMessages = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "tincho_websocket_messages_total",
@@ -73,6 +80,29 @@ var (
 		[]string{"direction"},
 	)
 
+	websocketViolations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tincho_websocket_violations_total",
+			Help: "Tracks the number of websockets rejected or closed for breaking the limits.",
+		},
+		[]string{"reason"},
+	)
+
+	rateLimited = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tincho_rate_limited_total",
+			Help: "Tracks the number of requests and actions rejected for going over the rate limits.",
+		},
+		[]string{"route"},
+	)
+
+	clientsBanned = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tincho_clients_banned_total",
+			Help: "Tracks the number of clients temporarily banned for going over the rate limits.",
+		},
+	)
+
 	websocketIncomingSize = promauto.NewHistogram(
 		prometheus.HistogramOpts{
 			Name:    "tincho_websocket_incoming_size_bytes",
@@ -119,6 +149,10 @@ func IncConnectionsTotal(reconnection bool) {
 	connectionsTotal.WithLabelValues(strconv.FormatBool(reconnection)).Inc()
 }
 
+func IncSpectatorsTotal() {
+	spectatorsTotal.Inc()
+}
+
 func IncWebsocketIncoming() {
 	websocketMessages.WithLabelValues("incoming").Inc()
 }
@@ -130,3 +164,15 @@ func IncWebsocketOutgoing() {
 func ObserveWebsocketIncomingSize(size float64) {
 	websocketIncomingSize.Observe(size)
 }
+
+func IncWebsocketViolation(reason string) {
+	websocketViolations.WithLabelValues(reason).Inc()
+}
+
+func IncRateLimited(route string) {
+	rateLimited.WithLabelValues(route).Inc()
+}
+
+func IncClientsBanned() {
+	clientsBanned.Inc()
+}
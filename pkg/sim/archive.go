@@ -0,0 +1,55 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/manuelpepe/tincho/pkg/tincho"
+)
+
+var ErrNoArchivedGames = errors.New("no archived games matched the filter")
+
+// ResultFromRecord builds the result of an archived game, using the seat of the winner as its index.
+func ResultFromRecord(record tincho.GameRecord) (Result, error) {
+	winner := slices.IndexFunc(record.Seats, func(s tincho.ArchivedSeat) bool { return s.Player == record.Winner })
+	if winner == -1 {
+		return Result{}, fmt.Errorf("winner %s not seated in game %s", record.Winner, record.ID)
+	}
+	return Result{
+		Winner:      winner,
+		TotalRounds: record.TotalRounds,
+		TotalTurns:  record.TotalTurns,
+	}, nil
+}
+
+// SummarizeArchive summarizes the archived games matching the filter the same way simulations are
+// summarized, with one entry per seat.
+func SummarizeArchive(archive tincho.Archive, filter tincho.ArchiveFilter) (Summary, error) {
+	games, err := archive.List(filter)
+	if err != nil {
+		return Summary{}, fmt.Errorf("error listing archive: %w", err)
+	}
+	if len(games) == 0 {
+		return Summary{}, ErrNoArchivedGames
+	}
+	seats := 0
+	for _, g := range games {
+		seats = max(seats, len(g.Seats))
+	}
+	summary := newSummary(seats)
+	for _, g := range games {
+		record, err := archive.Get(g.ID)
+		if err != nil {
+			return Summary{}, fmt.Errorf("error reading game %s: %w", g.ID, err)
+		}
+		result, err := ResultFromRecord(record)
+		if err != nil {
+			return Summary{}, err
+		}
+		if err := summary.record(result); err != nil {
+			return Summary{}, err
+		}
+	}
+	return summary, nil
+}
@@ -46,6 +46,21 @@ type Summary struct {
 	Turns      MinMaxMeanSum
 }
 
+func newSummary(strats int) Summary {
+	summary := Summary{
+		Strats: make([]StratSummary, 0, strats),
+		Rounds: MinMaxMeanSum{Min: 9999},
+		Turns:  MinMaxMeanSum{Min: 9999},
+	}
+	for i := 0; i < strats; i++ {
+		summary.Strats = append(summary.Strats, StratSummary{
+			Rounds: MinMaxMeanSum{Min: 9999},
+			Turns:  MinMaxMeanSum{Min: 9999},
+		})
+	}
+	return summary
+}
+
 func (s *Summary) record(result Result) error {
 	if s == nil {
 		return errors.New("nil summary")
@@ -100,7 +115,9 @@ func Play(ctx context.Context, logger *slog.Logger, strats ...bots.Strategy) (Re
 
 	roomID := generateRandomString(6)
 	logger = logger.With("room", roomID)
-	room := tincho.NewRoomWithDeck(logger, ctx, cancel, roomID, deck, len(strats))
+	settings := tincho.DefaultRoomSettings()
+	settings.CloseOnEnd = true
+	room := tincho.NewRoomWithSettings(logger, ctx, cancel, roomID, deck, len(strats), settings)
 	go room.Start()
 
 	type b struct {
@@ -111,7 +128,7 @@ func Play(ctx context.Context, logger *slog.Logger, strats ...bots.Strategy) (Re
 	players := make(map[game.PlayerID]b)
 	for ix, strat := range strats {
 		name := game.PlayerID(fmt.Sprintf("strat-%d", ix))
-		bot := bots.NewBotFromStrategy(logger, ctx, tincho.NewConnection(name), strat)
+		bot := bots.NewBotFromStrategy(logger, ctx, tincho.NewBotConnection(name), strat)
 		room.AddConnection(bot.Connection())
 		go func() {
 			if err := bot.Start(); err != nil {
@@ -195,18 +212,7 @@ func Compete(ctx context.Context, logger *slog.Logger, rounds int, strats ...Str
 	var finalResChan = make(chan Summary)
 	var finalErrChan = make(chan error)
 	go func() {
-		summary := Summary{
-			Strats: make([]StratSummary, 0, len(strats)),
-			Rounds: MinMaxMeanSum{Min: 9999},
-			Turns:  MinMaxMeanSum{Min: 9999},
-		}
-
-		for i := 0; i < len(strats); i++ {
-			summary.Strats = append(summary.Strats, StratSummary{
-				Rounds: MinMaxMeanSum{Min: 9999},
-				Turns:  MinMaxMeanSum{Min: 9999},
-			})
-		}
+		summary := newSummary(len(strats))
 
 		for i := 0; i < rounds; i++ {
 			select {
@@ -7,10 +7,13 @@ import (
 	"log/slog"
 	"os"
 	"testing"
+	"time"
 
 	"go.uber.org/goleak"
 
 	"github.com/manuelpepe/tincho/pkg/bots"
+	"github.com/manuelpepe/tincho/pkg/game"
+	"github.com/manuelpepe/tincho/pkg/tincho"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -101,3 +104,29 @@ func TestEvMvH(t *testing.T) {
 	defer goleak.VerifyNone(t)
 	assert.NoError(t, run(1000, false, easy, medium, hard))
 }
+
+func TestSummarizeArchive(t *testing.T) {
+	archive, err := tincho.NewFileArchive(t.TempDir())
+	assert.NoError(t, err)
+	seats := []tincho.ArchivedSeat{{Player: "a"}, {Player: "b", Bot: true, BotDifficulty: "hard"}}
+	for i, winner := range []game.PlayerID{"a", "b", "b"} {
+		assert.NoError(t, archive.Save(tincho.GameRecord{
+			ID:          fmt.Sprintf("GAME-%d", i),
+			EndedAt:     time.Now(),
+			Seats:       seats,
+			Winner:      winner,
+			TotalRounds: 2 + i,
+			TotalTurns:  10,
+		}))
+	}
+
+	sum, err := SummarizeArchive(archive, tincho.ArchiveFilter{BotDifficulty: "hard"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, sum.TotalGames)
+	assert.Equal(t, 1, sum.Strats[0].Wins)
+	assert.Equal(t, 2, sum.Strats[1].Wins)
+	assert.Equal(t, MinMaxMeanSum{Min: 2, Max: 4, Mean: 3, Sum: 9}, sum.Rounds)
+
+	_, err = SummarizeArchive(archive, tincho.ArchiveFilter{BotDifficulty: "easy"})
+	assert.ErrorIs(t, err, ErrNoArchivedGames)
+}
@@ -19,6 +19,16 @@ const (
 	ActionSwapCards      ActionType = "effect_swap_card"
 	ActionDiscard        ActionType = "discard"
 	ActionCut            ActionType = "cut"
+
+	ActionToggleSpectators ActionType = "toggle_spectators"
+	ActionChat             ActionType = "chat"
+	ActionKick             ActionType = "kick"
+	ActionTransferLeader   ActionType = "transfer_leader"
+	ActionLeave            ActionType = "leave"
+	ActionReady            ActionType = "ready"
+	ActionRematch          ActionType = "rematch"
+	ActionSetSeries        ActionType = "set_series"
+	ActionUpdateSettings   ActionType = "update_settings"
 )
 
 type ActionData interface {
@@ -28,6 +38,14 @@ type ActionData interface {
 		ActionSwapCardsData |
 		ActionDiscardData |
 		ActionCutData |
+		ActionToggleSpectatorsData |
+		ActionChatData |
+		ActionKickData |
+		ActionTransferLeaderData |
+		ActionReadyData |
+		ActionRematchData |
+		ActionSetSeriesData |
+		ActionUpdateSettingsData |
 		ActionWithoutData
 }
 
@@ -63,7 +81,15 @@ func (a *Action[T]) GetPlayerID() game.PlayerID {
 	return a.PlayerID
 }
 
+// NewActionFromRawMessage decodes an action sent by a client. Messages bigger than MAX_MESSAGE_SIZE
+// or nested deeper than MAX_JSON_DEPTH are rejected before decoding them.
 func NewActionFromRawMessage(message []byte) (TypedAction, error) {
+	if len(message) > MAX_MESSAGE_SIZE {
+		return nil, ErrMessageTooLarge
+	}
+	if err := checkJSONDepth(message); err != nil {
+		return nil, err
+	}
 	var actionType struct {
 		Type string `json:"type"`
 	}
@@ -76,6 +102,8 @@ func NewActionFromRawMessage(message []byte) (TypedAction, error) {
 		action = &Action[ActionWithoutData]{Type: ActionStart}
 	case string(ActionFirstPeek):
 		action = &Action[ActionWithoutData]{Type: ActionFirstPeek}
+	case string(ActionLeave):
+		action = &Action[ActionWithoutData]{Type: ActionLeave}
 	case string(ActionDraw):
 		var act Action[ActionDrawData]
 		if err := json.Unmarshal(message, &act); err != nil {
@@ -112,6 +140,54 @@ func NewActionFromRawMessage(message []byte) (TypedAction, error) {
 			return nil, err
 		}
 		action = &act
+	case string(ActionToggleSpectators):
+		var act Action[ActionToggleSpectatorsData]
+		if err := json.Unmarshal(message, &act); err != nil {
+			return nil, err
+		}
+		action = &act
+	case string(ActionChat):
+		var act Action[ActionChatData]
+		if err := json.Unmarshal(message, &act); err != nil {
+			return nil, err
+		}
+		action = &act
+	case string(ActionKick):
+		var act Action[ActionKickData]
+		if err := json.Unmarshal(message, &act); err != nil {
+			return nil, err
+		}
+		action = &act
+	case string(ActionTransferLeader):
+		var act Action[ActionTransferLeaderData]
+		if err := json.Unmarshal(message, &act); err != nil {
+			return nil, err
+		}
+		action = &act
+	case string(ActionReady):
+		var act Action[ActionReadyData]
+		if err := json.Unmarshal(message, &act); err != nil {
+			return nil, err
+		}
+		action = &act
+	case string(ActionRematch):
+		var act Action[ActionRematchData]
+		if err := json.Unmarshal(message, &act); err != nil {
+			return nil, err
+		}
+		action = &act
+	case string(ActionSetSeries):
+		var act Action[ActionSetSeriesData]
+		if err := json.Unmarshal(message, &act); err != nil {
+			return nil, err
+		}
+		action = &act
+	case string(ActionUpdateSettings):
+		var act Action[ActionUpdateSettingsData]
+		if err := json.Unmarshal(message, &act); err != nil {
+			return nil, err
+		}
+		action = &act
 	default:
 		return nil, fmt.Errorf("unknown action type: %s", actionType.Type)
 	}
@@ -152,22 +228,83 @@ type ActionCutData struct {
 	Declared  int  `json:"declared"`
 }
 
+type ActionToggleSpectatorsData struct {
+	Allow bool `json:"allow"`
+}
+
+// ActionChatData holds either a free text message or one of the ChatReactions.
+type ActionChatData struct {
+	Message  string `json:"message"`
+	Reaction string `json:"reaction"`
+}
+
+type ActionKickData struct {
+	Player game.PlayerID `json:"player"`
+	// banned players can't join the room again
+	Ban bool `json:"ban"`
+}
+
+type ActionTransferLeaderData struct {
+	Player game.PlayerID `json:"player"`
+}
+
+type ActionReadyData struct {
+	Ready bool `json:"ready"`
+}
+
+type ActionRematchData struct {
+	Vote bool `json:"vote"`
+}
+
+type ActionSetSeriesData struct {
+	// best-of-N games, 0 disables the series
+	Games int `json:"games"`
+}
+
+// ActionUpdateSettingsData changes only the settings that are present.
+type ActionUpdateSettingsData struct {
+	MaxPlayers  *int         `json:"max_players"`
+	DeckOptions *DeckOptions `json:"deck"`
+	// empty string removes the password
+	Password *string     `json:"password"`
+	Rules    *game.Rules `json:"rules"`
+	// in seconds, 0 disables the timer
+	TurnTimer *int  `json:"turn_timer"`
+	Hardcore  *bool `json:"hardcore"`
+}
+
 var ErrNotRoomLeader = errors.New("not room leader")
 
 func (r *Room) doStartGame(action Action[ActionWithoutData]) error {
-	if r.state.GetPlayers()[0].ID != action.PlayerID {
+	if !r.isLeader(action.PlayerID) {
 		return ErrNotRoomLeader
 	}
-	if err := r.broadcastGameConfig(r.state.CountBaseDeck()); err != nil {
-		return fmt.Errorf("broadcastGameConfig: %w", err)
-	}
+	return r.startGame()
+}
+
+func (r *Room) startGame() error {
+	r.cancelCountdown()
+	r.startRecording()
+	r.broadcastGameConfig()
 	topDiscard, err := r.state.StartGame()
 	if err != nil {
+		r.recording = nil
 		return fmt.Errorf("tsm.StartGame: %w", err)
 	}
+	r.recordDeal()
+	r.memory.reset()
+	r.lastCut = nil
+	r.lastRounds = nil
+	for playerID := range r.ready {
+		delete(r.ready, playerID)
+	}
 	if err := r.broadcastStartGame(topDiscard); err != nil {
 		return fmt.Errorf("broadcastStartGame: %w", err)
 	}
+	// players that disconnected in the lobby get their grace period from the start of the game
+	for playerID := range r.disconnected {
+		r.scheduleTakeover(playerID)
+	}
 	return nil
 }
 
@@ -176,6 +313,9 @@ func (r *Room) doPeekTwo(action Action[ActionWithoutData]) error {
 	if err != nil {
 		return fmt.Errorf("GetFirstPeek: %w", err)
 	}
+	for position, card := range peekedCards {
+		r.memory.see(action.PlayerID, action.PlayerID, position, card)
+	}
 	if err := r.broadcastPlayerFirstPeeked(action.PlayerID, peekedCards); err != nil {
 		return fmt.Errorf("broadcastPlayerPeeked: %w", err)
 	}
@@ -205,6 +345,8 @@ func (r *Room) doDiscard(action Action[ActionDiscardData]) error {
 	var err error
 
 	data := action.Data
+	drawn := r.state.GetPendingStorage()
+	drawnFromDiscard := r.state.LastDrawSource() == game.DrawSourceDiscard
 
 	if data.CardPosition2 == nil {
 		var value game.Card
@@ -214,6 +356,9 @@ func (r *Room) doDiscard(action Action[ActionDiscardData]) error {
 		}
 		positions = []int{data.CardPosition}
 		values = []game.Card{value}
+		if data.CardPosition != -1 {
+			r.rememberStored(action.PlayerID, data.CardPosition, drawn, drawnFromDiscard)
+		}
 	} else {
 		var disc []game.Card
 		var topOfDiscardPile game.Card
@@ -223,6 +368,13 @@ func (r *Room) doDiscard(action Action[ActionDiscardData]) error {
 		}
 
 		if errors.Is(err, game.ErrDiscardingNonEqualCards) {
+			// failed cards are shown to everyone and the drawn card goes to the end of the hand
+			players := r.state.GetPlayers()
+			r.memory.seeAll(players, action.PlayerID, data.CardPosition, disc[0])
+			r.memory.seeAll(players, action.PlayerID, *data.CardPosition2, disc[1])
+			if player, ok := r.state.GetPlayer(action.PlayerID); ok {
+				r.rememberStored(action.PlayerID, len(player.Hand)-1, drawn, drawnFromDiscard)
+			}
 			positions := []int{data.CardPosition, *data.CardPosition2}
 			err = r.broadcastFailedDoubleDiscard(action.PlayerID, positions, disc, topOfDiscardPile, cycledPiles)
 			if err != nil {
@@ -236,6 +388,8 @@ func (r *Room) doDiscard(action Action[ActionDiscardData]) error {
 
 		positions = []int{data.CardPosition, *data.CardPosition2}
 		values = disc
+		r.rememberStored(action.PlayerID, data.CardPosition, drawn, drawnFromDiscard)
+		r.memory.removePosition(action.PlayerID, *data.CardPosition2)
 	}
 
 	if err := r.broadcastDiscard(action.PlayerID, positions, values, cycledPiles); err != nil {
@@ -259,17 +413,28 @@ func (r *Room) doCut(action Action[ActionCutData]) error {
 	if err := r.broadcastCut(action.PlayerID, data.WithCount, data.Declared); err != nil {
 		return fmt.Errorf("broadcastCut: %w", err)
 	}
+	r.notifyRoundEnded(bool(finished))
 
 	if finished {
 		if err := r.broadcastEndGame(scores); err != nil {
 			return fmt.Errorf("broadcastEndGame: %w", err)
 		}
-		r.close()
+		r.notifyGameEnded()
+		r.archiveGame()
+		if r.settings.CloseOnEnd {
+			r.close(CloseReasonGameFinished, "")
+			return nil
+		}
+		if err := r.endGame(); err != nil {
+			return fmt.Errorf("endGame: %w", err)
+		}
 	} else {
 		topDiscard, err := r.state.StartNextRound()
 		if err != nil {
 			return fmt.Errorf("StartNextRound: %w", err)
 		}
+		r.memory.reset()
+		r.recordDeal()
 		if err := r.broadcastNextRound(topDiscard); err != nil {
 			return fmt.Errorf("broadcastNextRound: %w", err)
 		}
@@ -282,6 +447,7 @@ func (r *Room) doEffectPeekOwnCard(action Action[ActionPeekOwnCardData]) error {
 	if err != nil {
 		return err
 	}
+	r.memory.see(action.PlayerID, action.PlayerID, action.Data.CardPosition, card)
 	err = r.broadcastPeek(action.PlayerID, action.PlayerID, action.Data.CardPosition, card, discarded, cycledPiles)
 	if err != nil {
 		return fmt.Errorf("broadcastDiscard: %w", err)
@@ -294,6 +460,7 @@ func (r *Room) doEffectPeekCartaAjena(action Action[ActionPeekCartaAjenaData]) e
 	if err != nil {
 		return err
 	}
+	r.memory.see(action.PlayerID, action.Data.Player, action.Data.CardPosition, card)
 	err = r.broadcastPeek(action.PlayerID, action.Data.Player, action.Data.CardPosition, card, discarded, cycledPiles)
 	if err != nil {
 		return fmt.Errorf("broadcastDiscard: %w", err)
@@ -306,9 +473,100 @@ func (r *Room) doEffectSwapCards(action Action[ActionSwapCardsData]) error {
 	if err != nil {
 		return err
 	}
+	r.memory.swap(action.Data.Players[0], action.Data.CardPositions[0], action.Data.Players[1], action.Data.CardPositions[1])
 	err = r.broadcastSwapCards(action.PlayerID, action.Data.CardPositions, action.Data.Players, discarded, cycledPiles)
 	if err != nil {
 		return fmt.Errorf("broadcastSwapCards: %w", err)
 	}
 	return nil
 }
+
+func (r *Room) doToggleSpectators(action Action[ActionToggleSpectatorsData]) error {
+	if !r.isLeader(action.PlayerID) {
+		return ErrNotRoomLeader
+	}
+	r.allowSpectators = action.Data.Allow
+	if !r.allowSpectators && len(r.spectators) > 0 {
+		for _, spectator := range r.spectators {
+			spectator.SendUpdateOrDrop(Update[UpdateErrorData]{
+				Type: UpdateTypeError,
+				Data: UpdateErrorData{Message: ErrSpectatingDisabled.Error()},
+			})
+			spectator.Disconnect()
+		}
+		r.spectators = make([]*Connection, 0)
+	}
+	r.broadcastSpectatorsChanged()
+	return nil
+}
+
+func (r *Room) doKick(action Action[ActionKickData]) error {
+	if !r.isLeader(action.PlayerID) {
+		return ErrNotRoomLeader
+	}
+	if action.Data.Player == action.PlayerID {
+		return ErrKickSelf
+	}
+	return r.kickPlayer(action.Data.Player, action.Data.Ban)
+}
+
+// kickPlayer removes a player from the room, banning it from joining again if ban is set.
+func (r *Room) kickPlayer(playerID game.PlayerID, ban bool) error {
+	if !r.isPlayerInRoom(playerID) {
+		return fmt.Errorf("%w: %s", game.ErrPlayerNotFound, playerID)
+	}
+	conn, err := r.removePlayer(playerID)
+	if err != nil {
+		return err
+	}
+	if ban {
+		r.banned[playerID] = true
+	}
+	kicked := Update[UpdatePlayerKickedData]{
+		Type: UpdateTypePlayerKicked,
+		Data: UpdatePlayerKickedData{
+			Player: playerID,
+			Banned: ban,
+		},
+	}
+	r.BroadcastUpdate(kicked)
+	conn.SendUpdateOrDrop(kicked)
+	r.notifyPlayerLeft(playerID, true, ban)
+	conn.Disconnect()
+	r.closeIfEmpty()
+	return nil
+}
+
+func (r *Room) doTransferLeader(action Action[ActionTransferLeaderData]) error {
+	if !r.isLeader(action.PlayerID) {
+		return ErrNotRoomLeader
+	}
+	conn, exists := r.getConnection(action.Data.Player)
+	if !exists || conn.Bot {
+		return fmt.Errorf("%w: %s", ErrInvalidLeader, action.Data.Player)
+	}
+	r.setLeader(action.Data.Player)
+	return nil
+}
+
+func (r *Room) doLeave(action Action[ActionWithoutData]) error {
+	conn, err := r.removePlayer(action.PlayerID)
+	if err != nil {
+		return err
+	}
+	r.notifyPlayerLeft(action.PlayerID, false, false)
+	conn.Disconnect()
+	r.closeIfEmpty()
+	return nil
+}
+
+// rememberStored updates the card memory after a drawn card is stored in a player's hand.
+// Cards drawn from the discard pile were visible to everyone.
+func (r *Room) rememberStored(playerID game.PlayerID, position int, card game.Card, drawnFromDiscard bool) {
+	r.memory.forget(playerID, position)
+	if drawnFromDiscard {
+		r.memory.seeAll(r.state.GetPlayers(), playerID, position, card)
+	} else {
+		r.memory.see(playerID, playerID, position, card)
+	}
+}
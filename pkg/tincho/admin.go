@@ -0,0 +1,298 @@
+package tincho
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// max length in characters of a message sent by the operators
+const MAX_SYSTEM_MESSAGE_LENGTH = 500
+
+var ErrInvalidSystemMessage = fmt.Errorf("system message should have between 1 and %d characters", MAX_SYSTEM_MESSAGE_LENGTH)
+var ErrNotABot = errors.New("seat is not played by a bot")
+
+// AdminSeat is a seat of a room as seen by the operators.
+type AdminSeat struct {
+	Player        game.PlayerID `json:"player"`
+	Points        int           `json:"points"`
+	Cards         int           `json:"cards"`
+	Bot           bool          `json:"bot"`
+	BotDifficulty string        `json:"bot_difficulty,omitempty"`
+	// played by a bot while the player is disconnected
+	TakenOver      bool       `json:"taken_over"`
+	Connected      bool       `json:"connected"`
+	DisconnectedAt *time.Time `json:"disconnected_at,omitempty"`
+	Ready          bool       `json:"ready"`
+	Leader         bool       `json:"leader"`
+}
+
+// AdminRoomInfo has everything the operators need to know about a live room.
+type AdminRoomInfo struct {
+	RoomInfo
+	Phase RoomPhase `json:"phase"`
+	// player to play, empty outside of turns
+	Turn         game.PlayerID `json:"turn,omitempty"`
+	TotalTurns   int           `json:"total_turns"`
+	TotalRounds  int           `json:"total_rounds"`
+	Seats        []AdminSeat   `json:"seats"`
+	LastActivity time.Time     `json:"last_activity"`
+	IdleSeconds  int           `json:"idle_seconds"`
+}
+
+// adminInfo must be called from the room goroutine.
+func (r *Room) adminInfo() AdminRoomInfo {
+	info := AdminRoomInfo{
+		RoomInfo:     r.info(),
+		Phase:        r.phase(),
+		TotalTurns:   r.state.TotalTurns(),
+		TotalRounds:  r.state.TotalRounds(),
+		Seats:        make([]AdminSeat, 0, len(r.state.GetPlayers())),
+		LastActivity: r.lastActivity,
+		IdleSeconds:  int(time.Since(r.lastActivity).Seconds()),
+	}
+	if info.Phase == RoomPhaseTurns {
+		info.Turn = r.state.PlayerToPlay().ID
+	}
+	for _, p := range r.state.GetPlayers() {
+		seat := AdminSeat{
+			Player:    p.ID,
+			Points:    p.Points,
+			Cards:     len(p.Hand),
+			TakenOver: r.takenOver[p.ID],
+			Connected: r.isConnected(p.ID),
+			Ready:     r.isReady(p.ID),
+			Leader:    r.isLeader(p.ID),
+		}
+		if conn, ok := r.connections[p.ID]; ok && conn.Bot {
+			seat.Bot = true
+			seat.BotDifficulty = conn.BotDifficulty
+		}
+		if at, disconnected := r.disconnected[p.ID]; disconnected {
+			seat.DisconnectedAt = &at
+		}
+		info.Seats = append(info.Seats, seat)
+	}
+	return info
+}
+
+// AdminInfo returns the details of the room as of now.
+func (r *Room) AdminInfo() (AdminRoomInfo, error) {
+	var info AdminRoomInfo
+	err := r.query(func() { info = r.adminInfo() })
+	return info, err
+}
+
+// AdminState returns the full state of the room, including the hands of every player,
+// without the session tokens and password.
+func (r *Room) AdminState() (RoomSnapshot, error) {
+	var snapshot RoomSnapshot
+	err := r.query(func() { snapshot = r.snapshot() })
+	if err != nil {
+		return RoomSnapshot{}, err
+	}
+	snapshot.PasswordHash = ""
+	for ix := range snapshot.Seats {
+		snapshot.Seats[ix].SessionToken = ""
+	}
+	return snapshot, nil
+}
+
+// run runs fn in the room goroutine, which saves and publishes the room after it, and returns its error.
+// Returns ErrRoomClosed without running fn if the room stops first.
+func (r *Room) run(fn func() error) error {
+	res := make(chan error, 1)
+	select {
+	case r.eventsChan <- func() { res <- fn() }:
+	case <-r.Context.Done():
+		return ErrRoomClosed
+	}
+	return <-res
+}
+
+func (r *Room) sendSystemMessage(message string) {
+	r.BroadcastUpdate(Update[UpdateSystemMessageData]{
+		Type: UpdateTypeSystemMessage,
+		Data: UpdateSystemMessageData{Message: message, SentAt: time.Now().UnixMilli()},
+	})
+}
+
+// removeBot takes a bot out of its seat. Bots that joined as players lose the seat, while seats
+// taken over from a disconnected player get a fresh bot so the game can go on until the player comes back.
+func (r *Room) removeBot(playerID game.PlayerID) error {
+	conn, ok := r.getConnection(playerID)
+	if !ok {
+		return fmt.Errorf("%w: %s", game.ErrPlayerNotFound, playerID)
+	}
+	if conn.Bot {
+		return r.kickPlayer(playerID, false)
+	}
+	if !r.takenOver[playerID] {
+		return fmt.Errorf("%w: %s", ErrNotABot, playerID)
+	}
+	// waits for the bot to stop so it doesn't play along with its replacement
+	conn.Disconnect()
+	delete(r.takenOver, playerID)
+	if err := r.startTakeover(playerID); err != nil {
+		// the seat is given back to the player and taken over again after the grace period
+		r.takenOver[playerID] = true
+		r.endTakeover(playerID)
+		r.scheduleTakeover(playerID)
+		return fmt.Errorf("startTakeover: %w", err)
+	}
+	return nil
+}
+
+func validateSystemMessage(message string) (string, error) {
+	message = strings.TrimSpace(message)
+	if message == "" || utf8.RuneCountInString(message) > MAX_SYSTEM_MESSAGE_LENGTH {
+		return "", ErrInvalidSystemMessage
+	}
+	return message, nil
+}
+
+func (g *Service) openRoom(roomID string) (*Room, error) {
+	room, exists := g.GetRoom(roomID)
+	if !exists || room.HasClosed() {
+		return nil, fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+	}
+	return room, nil
+}
+
+// AdminRooms returns the details of every open room, oldest first.
+// Rooms that close while being listed are left out.
+func (g *Service) AdminRooms() []AdminRoomInfo {
+	rooms := make([]AdminRoomInfo, 0)
+	for _, room := range g.openRooms() {
+		if info, err := room.AdminInfo(); err == nil {
+			rooms = append(rooms, info)
+		}
+	}
+	slices.SortFunc(rooms, func(a, b AdminRoomInfo) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return rooms
+}
+
+func (g *Service) AdminRoom(roomID string) (AdminRoomInfo, error) {
+	room, err := g.openRoom(roomID)
+	if err != nil {
+		return AdminRoomInfo{}, err
+	}
+	return room.AdminInfo()
+}
+
+func (g *Service) AdminRoomState(roomID string) (RoomSnapshot, error) {
+	room, err := g.openRoom(roomID)
+	if err != nil {
+		return RoomSnapshot{}, err
+	}
+	return room.AdminState()
+}
+
+// AdminCloseRoom closes the room, sending the reason given by the admin to everyone in it.
+func (g *Service) AdminCloseRoom(roomID string, reason string) error {
+	reason, err := validateSystemMessage(reason)
+	if err != nil {
+		return err
+	}
+	room, err := g.openRoom(roomID)
+	if err != nil {
+		return err
+	}
+	return room.run(func() error {
+		room.close(CloseReasonAdmin, reason)
+		return nil
+	})
+}
+
+// AdminKick removes a player from the room, which can be the leader.
+func (g *Service) AdminKick(roomID string, playerID game.PlayerID, ban bool) error {
+	room, err := g.openRoom(roomID)
+	if err != nil {
+		return err
+	}
+	return room.run(func() error {
+		room.logger.Info(fmt.Sprintf("Player kicked by admin #%s: %s", room.ID, playerID), "ban", ban)
+		return room.kickPlayer(playerID, ban)
+	})
+}
+
+// AdminRemoveBot stops a bot that is stuck, replacing it if it was playing for a disconnected player.
+// See Room.removeBot.
+func (g *Service) AdminRemoveBot(roomID string, playerID game.PlayerID) error {
+	room, err := g.openRoom(roomID)
+	if err != nil {
+		return err
+	}
+	return room.run(func() error {
+		room.logger.Info(fmt.Sprintf("Bot removed by admin #%s: %s", room.ID, playerID))
+		return room.removeBot(playerID)
+	})
+}
+
+// Announce sends a system message to everyone in the room.
+func (g *Service) Announce(roomID string, message string) error {
+	message, err := validateSystemMessage(message)
+	if err != nil {
+		return err
+	}
+	room, err := g.openRoom(roomID)
+	if err != nil {
+		return err
+	}
+	return room.run(func() error {
+		room.sendSystemMessage(message)
+		return nil
+	})
+}
+
+// AnnounceAll sends a system message to every open room, returning how many rooms got it.
+func (g *Service) AnnounceAll(message string) (int, error) {
+	message, err := validateSystemMessage(message)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, room := range g.openRooms() {
+		err := room.run(func() error {
+			room.sendSystemMessage(message)
+			return nil
+		})
+		if err == nil {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// AdminOnly only lets through requests with the admin token as a bearer token in the
+// Authorization header. The admin API is not served at all if there is no token.
+func (g *Service) AdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.cfg.AdminToken == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("admin API disabled"))
+			return
+		}
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// hashed so the comparison doesn't leak the length of the token
+		expected := sha256.Sum256([]byte(g.cfg.AdminToken))
+		got := sha256.Sum256([]byte(token))
+		if !found || subtle.ConstantTimeCompare(expected[:], got[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
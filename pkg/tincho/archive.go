@@ -0,0 +1,297 @@
+package tincho
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+var ErrArchivedGameNotFound = errors.New("archived game not found")
+
+// Archive keeps a record of every finished game for later analysis.
+type Archive interface {
+	// Save stores a finished game.
+	Save(record GameRecord) error
+	// List returns the summaries of the games matching the filter, newest first.
+	List(filter ArchiveFilter) ([]GameSummary, error)
+	// Get returns the full record of a game.
+	Get(gameID string) (GameRecord, error)
+}
+
+type EventKind string
+
+const (
+	EventKindAction EventKind = "action"
+	EventKindUpdate EventKind = "update"
+)
+
+// ArchivedEvent is an action recieved or an update sent by the room while the game was played.
+type ArchivedEvent struct {
+	Time time.Time `json:"time"`
+	Kind EventKind `json:"kind"`
+	Type string    `json:"type"`
+	// player that sent the action, or the only player that recieved the update.
+	// empty for updates sent to everyone.
+	Player game.PlayerID `json:"player,omitempty"`
+	// player left out of a broadcasted update
+	Except game.PlayerID `json:"except,omitempty"`
+	// message as it was sent through the websocket
+	Message json.RawMessage `json:"message"`
+}
+
+type ArchivedSeat struct {
+	Player game.PlayerID `json:"player"`
+	Bot    bool          `json:"bot"`
+	// difficulty of the bot playing the seat, either from the start or after a takeover
+	BotDifficulty string `json:"bot_difficulty,omitempty"`
+	TakenOver     bool   `json:"taken_over,omitempty"`
+}
+
+// GameRecord is the complete history of a finished game.
+// Decks are shuffled with the global random source so there is no seed to record,
+// instead the state of the game right after every deal is kept in Deals.
+type GameRecord struct {
+	ID          string          `json:"id"`
+	RoomID      string          `json:"room_id"`
+	StartedAt   time.Time       `json:"started_at"`
+	EndedAt     time.Time       `json:"ended_at"`
+	Settings    RoomSettings    `json:"settings"`
+	MaxPlayers  int             `json:"max_players"`
+	Seats       []ArchivedSeat  `json:"seats"`
+	Deals       []game.Snapshot `json:"deals"`
+	Events      []ArchivedEvent `json:"events"`
+	Rounds      []game.Round    `json:"rounds"`
+	Winner      game.PlayerID   `json:"winner"`
+	TotalTurns  int             `json:"total_turns"`
+	TotalRounds int             `json:"total_rounds"`
+	// set if the room closed before the game finished
+	CloseReason CloseReason `json:"close_reason,omitempty"`
+}
+
+// GameSummary is the part of a GameRecord returned when listing the archive.
+type GameSummary struct {
+	ID          string         `json:"id"`
+	RoomID      string         `json:"room_id"`
+	StartedAt   time.Time      `json:"started_at"`
+	EndedAt     time.Time      `json:"ended_at"`
+	Seats       []ArchivedSeat `json:"seats"`
+	Winner      game.PlayerID  `json:"winner"`
+	TotalTurns  int            `json:"total_turns"`
+	TotalRounds int            `json:"total_rounds"`
+	CloseReason CloseReason    `json:"close_reason,omitempty"`
+}
+
+func (g GameRecord) Summary() GameSummary {
+	return GameSummary{
+		ID:          g.ID,
+		RoomID:      g.RoomID,
+		StartedAt:   g.StartedAt,
+		EndedAt:     g.EndedAt,
+		Seats:       g.Seats,
+		Winner:      g.Winner,
+		TotalTurns:  g.TotalTurns,
+		TotalRounds: g.TotalRounds,
+		CloseReason: g.CloseReason,
+	}
+}
+
+// ArchiveFilter selects games from the archive. Zero values match every game.
+type ArchiveFilter struct {
+	// games that ended at or after this time
+	From time.Time
+	// games that ended before this time
+	To     time.Time
+	Player game.PlayerID
+	// games where at least one seat was played by a bot of this difficulty
+	BotDifficulty string
+}
+
+func (f ArchiveFilter) Matches(g GameSummary) bool {
+	if !f.From.IsZero() && g.EndedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !g.EndedAt.Before(f.To) {
+		return false
+	}
+	if f.Player != "" && !slices.ContainsFunc(g.Seats, func(s ArchivedSeat) bool { return s.Player == f.Player }) {
+		return false
+	}
+	if f.BotDifficulty != "" && !slices.ContainsFunc(g.Seats, func(s ArchivedSeat) bool { return s.BotDifficulty == f.BotDifficulty }) {
+		return false
+	}
+	return true
+}
+
+// startRecording begins a new game record if the room has an archive.
+// Must be called from the room goroutine.
+func (r *Room) startRecording() {
+	if r.archive == nil {
+		return
+	}
+	now := time.Now()
+	r.recording = &GameRecord{
+		ID:         r.ID + "-" + strconv.FormatInt(now.UnixMilli(), 10),
+		RoomID:     r.ID,
+		StartedAt:  now,
+		Settings:   r.settings,
+		MaxPlayers: r.maxPlayers,
+		Events:     make([]ArchivedEvent, 0),
+		Deals:      make([]game.Snapshot, 0),
+	}
+}
+
+// recordDeal keeps the state of the game after the cards are dealt.
+func (r *Room) recordDeal() {
+	if r.recording == nil {
+		return
+	}
+	r.recording.Deals = append(r.recording.Deals, r.state.Snapshot())
+}
+
+func (r *Room) recordAction(action TypedAction) {
+	if r.recording == nil {
+		return
+	}
+	r.recordEvent(EventKindAction, string(action.GetType()), action.GetPlayerID(), "", action)
+}
+
+// recordUpdate keeps an update sent to the given player, or to everyone except the given player.
+func (r *Room) recordUpdate(update TypedUpdate, player game.PlayerID, except game.PlayerID) {
+	if r.recording == nil {
+		return
+	}
+	r.recordEvent(EventKindUpdate, string(update.GetType()), player, except, update)
+}
+
+func (r *Room) recordEvent(kind EventKind, eventType string, player game.PlayerID, except game.PlayerID, message any) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		r.logger.Error("error encoding archived event", "err", err, "type", eventType)
+		return
+	}
+	r.recording.Events = append(r.recording.Events, ArchivedEvent{
+		Time:    time.Now(),
+		Kind:    kind,
+		Type:    eventType,
+		Player:  player,
+		Except:  except,
+		Message: data,
+	})
+}
+
+// archiveGame completes the record of the game that just ended and saves it.
+// Errors are logged as the room can go on without it.
+func (r *Room) archiveGame() {
+	record := r.recording
+	r.recording = nil
+	if record == nil || r.archive == nil {
+		return
+	}
+	record.EndedAt = time.Now()
+	record.Seats = r.gameSeats()
+	if winner, err := r.state.Winner(); err == nil {
+		record.Winner = winner.ID
+	}
+	record.Rounds = r.state.RoundHistory()
+	record.TotalTurns = r.state.TotalTurns()
+	record.TotalRounds = r.state.TotalRounds()
+	if err := r.archive.Save(*record); err != nil {
+		r.logger.Error("error archiving game", "err", err)
+	}
+}
+
+// FileArchive keeps each game in a JSON file inside a directory.
+type FileArchive struct {
+	dir string
+}
+
+func NewFileArchive(dir string) (*FileArchive, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating archive directory: %w", err)
+	}
+	return &FileArchive{dir: dir}, nil
+}
+
+func (a *FileArchive) path(gameID string) (string, bool) {
+	if gameID == "" || strings.ContainsAny(gameID, `/\.`) {
+		return "", false
+	}
+	return filepath.Join(a.dir, gameID+".json"), true
+}
+
+func (a *FileArchive) Save(record GameRecord) error {
+	path, ok := a.path(record.ID)
+	if !ok {
+		return fmt.Errorf("invalid game id: %q", record.ID)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding game: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("error writing game: %w", err)
+	}
+	return nil
+}
+
+func (a *FileArchive) Get(gameID string) (GameRecord, error) {
+	path, ok := a.path(gameID)
+	if !ok {
+		return GameRecord{}, ErrArchivedGameNotFound
+	}
+	return ReadGameRecord(path)
+}
+
+// List reads every game in the archive, skipping the ones that can't be decoded.
+func (a *FileArchive) List(filter ArchiveFilter) ([]GameSummary, error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive directory: %w", err)
+	}
+	summaries := make([]GameSummary, 0)
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		record, err := ReadGameRecord(filepath.Join(a.dir, entry.Name()))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		summary := record.Summary()
+		if filter.Matches(summary) {
+			summaries = append(summaries, summary)
+		}
+	}
+	slices.SortFunc(summaries, func(a, b GameSummary) int {
+		if c := b.EndedAt.Compare(a.EndedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return summaries, errors.Join(errs...)
+}
+
+// ReadGameRecord decodes a game saved by a FileArchive.
+func ReadGameRecord(path string) (GameRecord, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return GameRecord{}, ErrArchivedGameNotFound
+	} else if err != nil {
+		return GameRecord{}, fmt.Errorf("error reading %s: %w", filepath.Base(path), err)
+	}
+	var record GameRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return GameRecord{}, fmt.Errorf("error decoding %s: %w", filepath.Base(path), err)
+	}
+	return record, nil
+}
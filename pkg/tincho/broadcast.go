@@ -2,11 +2,14 @@ package tincho
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/manuelpepe/tincho/pkg/game"
 )
 
+// BroadcastUpdate sends an update to all players and spectators.
 func (r *Room) BroadcastUpdate(update TypedUpdate) {
+	r.updateEmitted(update, "", "")
 	for _, player := range r.state.GetPlayers() {
 		conn, ok := r.getConnection(player.ID)
 		if !ok {
@@ -15,9 +18,12 @@ func (r *Room) BroadcastUpdate(update TypedUpdate) {
 		}
 		conn.SendUpdateOrDrop(update)
 	}
+	r.broadcastToSpectators(update)
 }
 
+// BroadcastUpdateExcept sends an update to all players except the given one, and to all spectators.
 func (r *Room) BroadcastUpdateExcept(update TypedUpdate, player game.PlayerID) {
+	r.updateEmitted(update, "", player)
 	for _, p := range r.state.GetPlayers() {
 		if p.ID != player {
 			conn, ok := r.getConnection(p.ID)
@@ -28,9 +34,17 @@ func (r *Room) BroadcastUpdateExcept(update TypedUpdate, player game.PlayerID) {
 			conn.SendUpdateOrDrop(update)
 		}
 	}
+	r.broadcastToSpectators(update)
+}
+
+func (r *Room) broadcastToSpectators(update TypedUpdate) {
+	for _, spectator := range r.spectators {
+		spectator.SendUpdateOrDrop(update)
+	}
 }
 
 func (r *Room) TargetedUpdate(player game.PlayerID, update TypedUpdate) {
+	r.updateEmitted(update, player, "")
 	for _, p := range r.state.GetPlayers() {
 		if p.ID == player {
 			conn, ok := r.getConnection(p.ID)
@@ -53,44 +67,126 @@ func (r *Room) TargetedError(player game.PlayerID, err error) {
 	})
 }
 
-func (r *Room) broadcastGameConfig(cardInDeck int) error {
+// sendError sends an error to a connection that may not be seated, like a spectator or a player
+// that couldn't join. It's sent from the room goroutine, which owns the connection's player and
+// numbers its updates, or right away once the room stopped.
+func (r *Room) sendError(conn *Connection, err error) {
+	update := Update[UpdateErrorData]{
+		Type: UpdateTypeError,
+		Data: UpdateErrorData{Message: err.Error()},
+	}
+	if err := r.query(func() { conn.SendUpdateOrDrop(update) }); err != nil {
+		<-r.done
+		conn.SendUpdateOrDrop(update)
+	}
+}
+
+func (r *Room) broadcastPlayersChanged() {
+	r.BroadcastUpdate(Update[UpdatePlayersChangedData]{
+		Type: UpdateTypePlayersChanged,
+		Data: UpdatePlayersChangedData{
+			Players: r.getMarshalledPlayers(),
+			Leader:  r.leader,
+		},
+	})
+}
+
+func (r *Room) broadcastGameConfig() {
 	r.BroadcastUpdate(Update[UpdateGameConfig]{
 		Type: UpdateTypeGameConfig,
-		Data: UpdateGameConfig{
-			CardsInDeck: cardInDeck,
-		},
+		Data: r.gameConfig(),
 	})
-	return nil
 }
 
 func (r *Room) sendRejoinState(conn *Connection) {
-	playerToPlay := r.state.PlayerToPlay().ID
-	pendStorage := r.state.GetPendingStorage()
-	cardsInDeck := r.state.CountBaseDeck()
-	cardsInDrawPile := r.state.CountDrawPile()
-	var lastDrawSource *game.DrawSource
-	var cardInHandVal *game.Card
-	if (conn.ID == playerToPlay && pendStorage != game.Card{}) {
-		cardInHandVal = &pendStorage
-		ds := r.state.LastDrawSource()
-		lastDrawSource = &ds
+	r.TargetedUpdate(conn.ID, r.rejoinState(conn))
+}
+
+// sendSpectatorState sends a new spectator the public state of the room.
+func (r *Room) sendSpectatorState(conn *Connection) {
+	conn.SendUpdateOrDrop(Update[UpdatePlayersChangedData]{
+		Type: UpdateTypePlayersChanged,
+		Data: UpdatePlayersChangedData{
+			Players: r.getMarshalledPlayers(),
+			Leader:  r.leader,
+		},
+	})
+	if r.state.Playing() {
+		conn.SendUpdateOrDrop(r.rejoinState(conn))
+	}
+}
+
+// phase returns the screen the room is in.
+func (r *Room) phase() RoomPhase {
+	switch {
+	case r.state.Playing() && !r.state.AllPlayersFirstPeeked():
+		return RoomPhaseFirstPeek
+	case r.state.Playing():
+		return RoomPhaseTurns
+	case r.lastRounds != nil:
+		return RoomPhaseEnded
+	default:
+		return RoomPhaseLobby
+	}
+}
+
+// rejoinState builds the rejoin update for a connection with everything needed to rebuild the screen
+// of the current phase. Players get back every card they have seen, where it is now, unless the room is hardcore.
+func (r *Room) rejoinState(conn *Connection) Update[UpdateTypeRejoinData] {
+	phase := r.phase()
+	data := UpdateTypeRejoinData{
+		Phase:        phase,
+		Players:      r.getMarshalledPlayers(),
+		Config:       r.gameConfig(),
+		CardsInDeck:  r.state.CountBaseDeck(),
+		KnownCards:   make([]KnownCard, 0),
+		Rounds:       r.state.RoundHistory(),
+		LastCut:      r.lastCut,
+		RematchVotes: r.rematchVoters(),
+		Chat:         slices.Clone(r.chatHistory),
+		Leader:       r.leader,
+		Series:       r.series.data(),
+	}
+	if phase == RoomPhaseEnded {
+		data.Rounds = slices.Clone(r.lastRounds)
+	}
+	if phase == RoomPhaseLobby || phase == RoomPhaseEnded {
+		data.Countdown = r.countdownLeft()
+		return Update[UpdateTypeRejoinData]{Type: UpdateTypeRejoin, Data: data}
 	}
-	var lastDiscarded *game.Card
+
+	if !conn.Spectator && !r.settings.Hardcore {
+		data.KnownCards = r.memory.known(conn.ID)
+	}
+	data.CardsInDrawPile = r.state.CountDrawPile()
 	if r.state.CountDiscardPile() > 0 {
 		v := r.state.LastDiscarded()
-		lastDiscarded = &v
+		data.LastDiscarded = &v
 	}
-	r.TargetedUpdate(conn.ID, Update[UpdateTypeRejoinData]{
-		Type: UpdateTypeRejoin,
-		Data: UpdateTypeRejoinData{
-			Players:          r.getMarshalledPlayers(),
-			CurrentTurn:      playerToPlay,
-			CardInHand:       r.state.GetPendingStorage() != game.Card{},
-			CardInHandVal:    cardInHandVal,
-			CardInHandSource: lastDrawSource,
-			LastDiscarded:    lastDiscarded,
-			CardsInDeck:      cardsInDeck,
-			CardsInDrawPile:  cardsInDrawPile,
+	if phase == RoomPhaseTurns {
+		playerToPlay := r.state.PlayerToPlay().ID
+		pendStorage := r.state.GetPendingStorage()
+		data.CurrentTurn = playerToPlay
+		data.CardInHand = pendStorage != game.Card{}
+		if (!conn.Spectator && conn.ID == playerToPlay && pendStorage != game.Card{}) {
+			data.CardInHandVal = &pendStorage
+			ds := r.state.LastDrawSource()
+			data.CardInHandSource = &ds
+		}
+	}
+	return Update[UpdateTypeRejoinData]{Type: UpdateTypeRejoin, Data: data}
+}
+
+func (r *Room) broadcastSpectatorsChanged() {
+	names := make([]game.PlayerID, 0, len(r.spectators))
+	for _, spectator := range r.spectators {
+		names = append(names, spectator.ID)
+	}
+	r.BroadcastUpdate(Update[UpdateSpectatorsChangedData]{
+		Type: UpdateTypeSpectatorsChanged,
+		Data: UpdateSpectatorsChangedData{
+			Allowed:    r.allowSpectators,
+			Spectators: names,
 		},
 	})
 }
@@ -201,21 +297,23 @@ func (r *Room) broadcastCut(playerID game.PlayerID, withCount bool, declared int
 	players := r.state.GetPlayers()
 	hands := make([][]game.Card, len(players))
 	for ix := range players {
-		hands[ix] = players[ix].Hand
+		hands[ix] = slices.Clone(players[ix].Hand)
 	}
 	marshalled := make([]MarshalledPlayer, 0, len(players))
 	for _, p := range players {
 		marshalled = append(marshalled, NewMarshalledPlayer(p))
 	}
+	data := UpdateCutData{
+		Player:    playerID,
+		WithCount: withCount,
+		Declared:  declared,
+		Players:   marshalled,
+		Hands:     hands,
+	}
+	r.lastCut = &data
 	r.BroadcastUpdate(Update[UpdateCutData]{
 		Type: UpdateTypeCut,
-		Data: UpdateCutData{
-			Player:    playerID,
-			WithCount: withCount,
-			Declared:  declared,
-			Players:   marshalled,
-			Hands:     hands,
-		},
+		Data: data,
 	})
 	return nil
 }
@@ -0,0 +1,80 @@
+package tincho
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// max length in characters of a chat message
+	MAX_CHAT_MESSAGE_LENGTH = 200
+	// amount of chat messages kept by the room and sent on rejoin
+	CHAT_HISTORY_SIZE = 50
+	// amount of messages a player can send in a burst
+	CHAT_BURST = 5
+	// time it takes to recover one message from the burst
+	CHAT_REFILL_INTERVAL = 2 * time.Second
+)
+
+// ChatReactions is the fixed set of reactions that can be sent in the chat.
+var ChatReactions = []string{"👍", "👎", "😂", "😮", "😢", "😡", "🎉", "👏", "🤔", "❤️"}
+
+var ErrEmptyChatMessage = errors.New("empty chat message")
+var ErrChatMessageTooLong = fmt.Errorf("chat message longer than %d characters", MAX_CHAT_MESSAGE_LENGTH)
+var ErrInvalidReaction = errors.New("invalid reaction")
+var ErrChatRateLimited = errors.New("sending messages too fast")
+
+// validateChat checks the action contains either a valid message or a valid reaction, but not both.
+func validateChat(data ActionChatData) (ActionChatData, error) {
+	data.Message = strings.TrimSpace(data.Message)
+	if data.Reaction != "" {
+		if data.Message != "" {
+			return ActionChatData{}, errors.New("chat message can't have both text and reaction")
+		}
+		if !slices.Contains(ChatReactions, data.Reaction) {
+			return ActionChatData{}, fmt.Errorf("%w: %s", ErrInvalidReaction, data.Reaction)
+		}
+		return data, nil
+	}
+	if data.Message == "" {
+		return ActionChatData{}, ErrEmptyChatMessage
+	}
+	if utf8.RuneCountInString(data.Message) > MAX_CHAT_MESSAGE_LENGTH {
+		return ActionChatData{}, ErrChatMessageTooLong
+	}
+	return data, nil
+}
+
+func (r *Room) doChat(action Action[ActionChatData]) error {
+	conn, ok := r.getConnection(action.PlayerID)
+	if !ok {
+		return fmt.Errorf("unknown player: %s", action.PlayerID)
+	}
+	data, err := validateChat(action.Data)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !conn.chatLimiter.Allow(now) {
+		return ErrChatRateLimited
+	}
+	message := UpdateChatData{
+		Player:   action.PlayerID,
+		Message:  data.Message,
+		Reaction: data.Reaction,
+		SentAt:   now.UnixMilli(),
+	}
+	r.chatHistory = append(r.chatHistory, message)
+	if len(r.chatHistory) > CHAT_HISTORY_SIZE {
+		r.chatHistory = slices.Clone(r.chatHistory[len(r.chatHistory)-CHAT_HISTORY_SIZE:])
+	}
+	r.BroadcastUpdate(Update[UpdateChatData]{
+		Type: UpdateTypeChat,
+		Data: message,
+	})
+	return nil
+}
@@ -0,0 +1,121 @@
+package tincho
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// time before a room times out that players are warned, so the table can finish the round.
+// Rooms with shorter timeouts are warned halfway through.
+const ROOM_TIMEOUT_WARNING = 5 * time.Minute
+
+// CloseReason is why a room was closed, sent to everyone in it and kept in the archive.
+type CloseReason string
+
+const (
+	// the game ended in a room set to close at the end
+	CloseReasonGameFinished CloseReason = "game_finished"
+	// the room was open for longer than the room timeout of the server
+	CloseReasonTimeout CloseReason = "timeout"
+	// nobody joined or played for longer than the idle timeout of the server
+	CloseReasonIdle CloseReason = "idle"
+	// closed through the admin API
+	CloseReasonAdmin CloseReason = "admin"
+	// every player left or was kicked
+	CloseReasonEveryoneLeft CloseReason = "everyone_left"
+)
+
+// close sends the reason and the standings so far to everyone in the room and stops it.
+// Games that didn't finish are archived with what was played of them.
+func (r *Room) close(reason CloseReason, message string) {
+	if r.closed {
+		return
+	}
+	r.logger.Info(fmt.Sprintf("Closing room #%s", r.ID), "reason", reason)
+	r.cancelCountdown()
+	r.BroadcastUpdate(Update[UpdateRoomClosedData]{
+		Type: UpdateTypeRoomClosed,
+		Data: UpdateRoomClosedData{
+			Reason:    reason,
+			Message:   message,
+			Standings: r.standings(),
+			Rounds:    r.playedRounds(),
+		},
+	})
+	if r.recording != nil {
+		r.recording.CloseReason = reason
+		r.archiveGame()
+	}
+	r.closeReason = reason
+	r.closed = true
+	// published before stopping, so whoever waits on the context sees how the room ended
+	r.publish()
+	r.closeRoom()
+}
+
+// standings returns the players sorted by their points so far, best first.
+func (r *Room) standings() []MarshalledPlayer {
+	players := r.getMarshalledPlayers()
+	slices.SortStableFunc(players, func(a, b MarshalledPlayer) int {
+		return cmp.Compare(a.Points, b.Points)
+	})
+	return players
+}
+
+// playedRounds returns the rounds of the game being played, or of the last game if there is none.
+func (r *Room) playedRounds() []game.Round {
+	if rounds := r.state.RoundHistory(); len(rounds) > 0 {
+		return rounds
+	}
+	return slices.Clone(r.lastRounds)
+}
+
+// scheduleTimeout closes the room once the room timeout passed since it was created, warning the
+// players before. Restored rooms only get what was left of it, closing right away if nothing was.
+func (r *Room) scheduleTimeout() {
+	if r.timeout <= 0 {
+		return
+	}
+	closesAt := r.createdAt.Add(r.timeout)
+	remaining := time.Until(closesAt)
+	warning := ROOM_TIMEOUT_WARNING
+	if warning >= r.timeout {
+		warning = r.timeout / 2
+	}
+	if remaining > 0 {
+		r.schedule(remaining-warning, func() {
+			r.BroadcastUpdate(Update[UpdateRoomClosingData]{
+				Type: UpdateTypeRoomClosing,
+				Data: UpdateRoomClosingData{Reason: CloseReasonTimeout, ClosesAt: closesAt},
+			})
+		})
+	}
+	r.schedule(remaining, func() {
+		r.close(CloseReasonTimeout, "")
+	})
+}
+
+// checkIdle closes the room if nobody joined or played for the idle timeout, or checks again
+// once it could have been idle for that long.
+func (r *Room) checkIdle() {
+	if r.idleTimeout <= 0 {
+		return
+	}
+	idle := time.Since(r.lastActivity)
+	if idle >= r.idleTimeout {
+		r.close(CloseReasonIdle, "")
+		return
+	}
+	r.schedule(r.idleTimeout-idle, r.checkIdle)
+}
+
+// closeIfEmpty closes the room once the last player leaves.
+func (r *Room) closeIfEmpty() {
+	if len(r.state.GetPlayers()) == 0 {
+		r.close(CloseReasonEveryoneLeft, "")
+	}
+}
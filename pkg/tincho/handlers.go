@@ -6,32 +6,59 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/manuelpepe/tincho/pkg/game"
 	"github.com/manuelpepe/tincho/pkg/metrics"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
+var ErrSpectatorAction = errors.New("spectators can't perform actions")
 
 type Handlers struct {
-	service *Service
-	logger  *slog.Logger
+	service  *Service
+	logger   *slog.Logger
+	upgrader websocket.Upgrader
 }
 
 func NewHandlers(logger *slog.Logger, service *Service) *Handlers {
-	return &Handlers{service: service, logger: logger.With("component", "tincho-handlers")}
+	h := &Handlers{service: service, logger: logger.With("component", "tincho-handlers")}
+	h.upgrader = websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	return h
 }
 
 type RoomConfig struct {
 	Password    string      `json:"password"`
 	MaxPlayers  int         `json:"max_players"`
 	DeckOptions DeckOptions `json:"deck"`
+
+	// bot difficulty for seats of disconnected players, "none" disables takeovers
+	TakeoverDifficulty string `json:"takeover_difficulty"`
+	// seconds to wait for a disconnected player before a bot takes their seat
+	TakeoverGracePeriod int `json:"takeover_grace_period"`
+
+	// start the game once enough players are ready
+	AutoStart bool `json:"auto_start"`
+	// ready players needed to auto start, 0 means everyone
+	MinReady int `json:"min_ready"`
+	// seconds between enough players being ready and the game starting
+	StartCountdown int `json:"start_countdown"`
+
+	// best-of-N games played in the room, 0 means games are not part of a series
+	SeriesLength int `json:"series_length"`
+
+	// zero value means the default rules
+	Rules game.Rules `json:"rules"`
+	// seconds a player has to finish their turn, 0 disables the timer.
+	// The default of the server is used if it's not set.
+	TurnTimer int `json:"turn_timer"`
+	// don't remind reconnecting players of the cards they saw
+	Hardcore bool `json:"hardcore"`
 }
 
 func (rc RoomConfig) Validate() error {
@@ -39,13 +66,69 @@ func (rc RoomConfig) Validate() error {
 		return errors.New("max players should be greater than 1")
 	}
 
-	playerLimit := 10
-	if rc.MaxPlayers > playerLimit {
-		return fmt.Errorf("max players should be less than %d", playerLimit)
+	if rc.MaxPlayers > MAX_PLAYERS {
+		return fmt.Errorf("max players should be less than %d", MAX_PLAYERS)
+	}
+
+	switch rc.TakeoverDifficulty {
+	case "", "none", "easy", "medium", "hard":
+	default:
+		return fmt.Errorf("invalid takeover difficulty: %s", rc.TakeoverDifficulty)
+	}
+
+	if rc.TakeoverGracePeriod < 0 || rc.TakeoverGracePeriod > MAX_TAKEOVER_GRACE_PERIOD {
+		return fmt.Errorf("takeover grace period should be between 0 and %d seconds", MAX_TAKEOVER_GRACE_PERIOD)
+	}
+
+	if rc.MinReady < 0 || rc.MinReady > rc.MaxPlayers {
+		return fmt.Errorf("min ready should be between 0 and %d", rc.MaxPlayers)
+	}
+
+	if rc.StartCountdown < 0 || rc.StartCountdown > MAX_START_COUNTDOWN {
+		return fmt.Errorf("start countdown should be between 0 and %d seconds", MAX_START_COUNTDOWN)
+	}
+
+	if rc.SeriesLength < 0 || rc.SeriesLength > MAX_SERIES_LENGTH {
+		return ErrInvalidSeriesLength
+	}
+
+	if rc.Rules != (game.Rules{}) {
+		if err := rc.Rules.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if rc.TurnTimer < 0 || rc.TurnTimer > MAX_TURN_TIMER {
+		return ErrInvalidTurnTimer
 	}
 	return nil
 }
 
+func (rc RoomConfig) Settings() RoomSettings {
+	settings := DefaultRoomSettings()
+	if rc.TakeoverDifficulty == "none" {
+		settings.TakeoverDifficulty = ""
+	} else if rc.TakeoverDifficulty != "" {
+		settings.TakeoverDifficulty = rc.TakeoverDifficulty
+	}
+	if rc.TakeoverGracePeriod > 0 {
+		settings.TakeoverGracePeriod = time.Duration(rc.TakeoverGracePeriod) * time.Second
+	}
+	settings.AutoStart = rc.AutoStart
+	settings.MinReady = rc.MinReady
+	settings.SeriesLength = rc.SeriesLength
+	settings.DeckOptions = rc.DeckOptions
+	settings.TurnTimer = time.Duration(rc.TurnTimer) * time.Second
+	settings.Hardcore = rc.Hardcore
+	if rc.Rules != (game.Rules{}) {
+		settings.Rules = rc.Rules
+	}
+	if rc.StartCountdown > 0 {
+		settings.StartCountdown = time.Duration(rc.StartCountdown) * time.Second
+	}
+	return settings
+}
+
 type DeckOptions struct {
 	Extended bool `json:"extended"`
 	Chaos    bool `json:"chaos"`
@@ -64,7 +147,11 @@ func buildDeck(options DeckOptions) game.Deck {
 }
 
 func (h *Handlers) NewRoom(w http.ResponseWriter, r *http.Request) {
-	var roomConfig RoomConfig
+	if h.rejectWhileDraining(w) {
+		return
+	}
+	// fields missing from the request keep the defaults of the server
+	roomConfig := RoomConfig{TurnTimer: int(h.service.cfg.DefaultTurnTimer.Seconds())}
 	if err := json.NewDecoder(r.Body).Decode(&roomConfig); err != nil {
 		h.logger.Warn(fmt.Sprintf("Error decoding room config: %s", err), "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -78,11 +165,18 @@ func (h *Handlers) NewRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	deck := buildDeck(roomConfig.DeckOptions)
-	roomID, err := h.service.NewRoom(h.logger, deck, roomConfig.MaxPlayers, roomConfig.Password)
+	roomID, err := h.service.NewRoomForClient(h.logger, clientIP(r), deck, roomConfig.MaxPlayers, roomConfig.Password, roomConfig.Settings())
 	if err != nil {
 		h.logger.Warn(fmt.Sprintf("Error creating room: %s", err), "err", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		if errors.Is(err, ErrRoomsLimitReached) || errors.Is(err, ErrClientRoomsLimitReached) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else if errors.Is(err, ErrMaxPlayersAboveLimit) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
 		w.Write([]byte(fmt.Sprintf("error: %s", err)))
+		return
 	}
 	h.logger.Info(fmt.Sprintf("New room created: %s", roomID))
 	w.Header().Set("Content-Type", "text/plain")
@@ -90,58 +184,307 @@ func (h *Handlers) NewRoom(w http.ResponseWriter, r *http.Request) {
 	metrics.IncGamesTotal()
 }
 
-type RoomInfo struct {
-	ID      string `json:"id"`
-	Players int    `json:"players"`
+const DEFAULT_ROOMS_PER_PAGE = 20
+const MAX_ROOMS_PER_PAGE = 100
+
+type RoomList struct {
+	Rooms   []RoomInfo `json:"rooms"`
+	Total   int        `json:"total"`
+	Page    int        `json:"page"`
+	PerPage int        `json:"per_page"`
 }
 
+// ListRooms returns a page of the open rooms.
+// Supports the `joinable` and `public` filters and the `page` and `per_page` pagination parameters.
 func (h *Handlers) ListRooms(w http.ResponseWriter, r *http.Request) {
-	rooms := make([]RoomInfo, 0, h.service.ActiveRoomCount())
-	for _, room := range h.service.rooms {
-		rooms = append(rooms, RoomInfo{
-			ID:      room.ID,
-			Players: len(room.state.GetPlayers()),
-		})
-	}
-	if err := json.NewEncoder(w).Encode(rooms); err != nil {
+	query := r.URL.Query()
+	page, err := intParam(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid page"))
+		return
+	}
+	perPage, err := intParam(query.Get("per_page"), DEFAULT_ROOMS_PER_PAGE)
+	if err != nil || perPage < 1 || perPage > MAX_ROOMS_PER_PAGE {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("per_page should be between 1 and %d", MAX_ROOMS_PER_PAGE)))
+		return
+	}
+	joinableOnly := query.Get("joinable") == "true"
+	publicOnly := query.Get("public") == "true"
+
+	rooms := make([]RoomInfo, 0)
+	for _, info := range h.service.ListRooms() {
+		if joinableOnly && !info.Joinable() {
+			continue
+		}
+		if publicOnly && info.Private {
+			continue
+		}
+		rooms = append(rooms, info)
+	}
+	list := RoomList{Rooms: make([]RoomInfo, 0), Total: len(rooms), Page: page, PerPage: perPage}
+	if start := (page - 1) * perPage; start < len(rooms) {
+		end := start + perPage
+		if end > len(rooms) {
+			end = len(rooms)
+		}
+		list.Rooms = rooms[start:end]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
 		h.logger.Warn(fmt.Sprintf("Error encoding rooms: %s", err), "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
 
+type ArchiveList struct {
+	Games   []GameSummary `json:"games"`
+	Total   int           `json:"total"`
+	Page    int           `json:"page"`
+	PerPage int           `json:"per_page"`
+}
+
+// ListArchivedGames returns a page of the finished games, newest first.
+// Supports the `from` and `to` dates (YYYY-MM-DD or RFC 3339), `player` and `difficulty` filters
+// and the `page` and `per_page` pagination parameters.
+func (h *Handlers) ListArchivedGames(w http.ResponseWriter, r *http.Request) {
+	archive := h.service.Archive()
+	if archive == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("archive disabled"))
+		return
+	}
+	query := r.URL.Query()
+	page, err := intParam(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid page"))
+		return
+	}
+	perPage, err := intParam(query.Get("per_page"), DEFAULT_ROOMS_PER_PAGE)
+	if err != nil || perPage < 1 || perPage > MAX_ROOMS_PER_PAGE {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("per_page should be between 1 and %d", MAX_ROOMS_PER_PAGE)))
+		return
+	}
+	from, err := timeParam(query.Get("from"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid from date"))
+		return
+	}
+	to, err := timeParam(query.Get("to"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid to date"))
+		return
+	}
+	filter := ArchiveFilter{
+		From:          from,
+		To:            to,
+		Player:        game.PlayerID(query.Get("player")),
+		BotDifficulty: query.Get("difficulty"),
+	}
+
+	games, err := archive.List(filter)
+	if err != nil {
+		// games that could be read are still listed
+		h.logger.Error(fmt.Sprintf("Error listing archive: %s", err), "err", err)
+	}
+	list := ArchiveList{Games: make([]GameSummary, 0), Total: len(games), Page: page, PerPage: perPage}
+	if start := (page - 1) * perPage; start < len(games) {
+		end := start + perPage
+		if end > len(games) {
+			end = len(games)
+		}
+		list.Games = games[start:end]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		h.logger.Warn(fmt.Sprintf("Error encoding archive: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetArchivedGame returns the full record of a finished game.
+func (h *Handlers) GetArchivedGame(w http.ResponseWriter, r *http.Request) {
+	archive := h.service.Archive()
+	if archive == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("archive disabled"))
+		return
+	}
+	record, err := archive.Get(mux.Vars(r)["id"])
+	if errors.Is(err, ErrArchivedGameNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("game not found"))
+		return
+	} else if err != nil {
+		h.logger.Error(fmt.Sprintf("Error reading archived game: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		h.logger.Warn(fmt.Sprintf("Error encoding archived game: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Replay streams the updates a seat recieved during a game through a websocket, with the same format
+// used while playing. Archived games are selected with `game` and live games with `room`.
+// `seat` picks the player whose updates are sent (empty for the spectator view), `omniscient=true`
+// reveals every card and `speed` multiplies the pace of the game.
+// Live games can only be replayed by the player sitting in the requested seat.
+func (h *Handlers) Replay(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	seat := game.PlayerID(query.Get("seat"))
+	omniscient := query.Get("omniscient") == "true"
+	speed := 1.0
+	if value := query.Get("speed"); value != "" {
+		var err error
+		speed, err = strconv.ParseFloat(value, 64)
+		if err != nil || speed <= 0 || speed > MAX_REPLAY_SPEED {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(ErrInvalidReplaySpeed.Error()))
+			return
+		}
+	}
+
+	var record GameRecord
+	if gameID := query.Get("game"); gameID != "" {
+		archive := h.service.Archive()
+		if archive == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("archive disabled"))
+			return
+		}
+		var err error
+		record, err = archive.Get(gameID)
+		if errors.Is(err, ErrArchivedGameNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("game not found"))
+			return
+		} else if err != nil {
+			h.logger.Error(fmt.Sprintf("Error reading archived game: %s", err), "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else if roomID := strings.ToUpper(query.Get("room")); roomID != "" {
+		room, exists := h.service.GetRoom(roomID)
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("room not found"))
+			return
+		}
+		if omniscient || !h.isSeatOwner(r, room, seat) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("live games can only be replayed from your own seat"))
+			return
+		}
+		var recording bool
+		record, recording = room.Recording()
+		if !recording {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("room is not recording a game"))
+			return
+		}
+	} else {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("missing game or room attribute"))
+		return
+	}
+
+	updates, err := ReplayUpdates(record, seat, omniscient)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	ws, err := h.upgradeConnection(w, r, nil)
+	if err != nil {
+		h.logger.Warn(fmt.Sprintf("Error upgrading connection: %s", err), "err", err)
+		return
+	}
+	defer ws.Close()
+
+	// the client closing the socket stops the replay
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	if err := streamReplay(ctx, ws, updates, speed); err != nil && !errors.Is(err, context.Canceled) {
+		h.logger.Warn(fmt.Sprintf("Error streaming replay: %s", err), "err", err)
+		return
+	}
+	ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "replay finished"), time.Now().Add(time.Second))
+}
+
+// isSeatOwner checks if the session cookie of the request belongs to the player in the given seat.
+func (h *Handlers) isSeatOwner(r *http.Request, room *Room, seat game.PlayerID) bool {
+	ses, err := h.service.readSession(r)
+	if err != nil || ses.Player != seat || ses.Room != room.ID {
+		return false
+	}
+	conn, exists := room.GetConnection(seat)
+	return exists && conn.SessionToken == ses.Token
+}
+
 func (h *Handlers) JoinRoom(w http.ResponseWriter, r *http.Request) {
+	if h.rejectWhileDraining(w) {
+		return
+	}
 	roomID := strings.ToUpper(r.URL.Query().Get("room"))
 	playerID := game.PlayerID(r.URL.Query().Get("player"))
 	password := r.URL.Query().Get("password")
+	invite := r.URL.Query().Get("invite")
+	if roomID == "" && invite != "" {
+		// invite codes are enough to join, without knowing the room
+		if inviteRoom, err := h.service.InviteRoom(invite); err == nil {
+			roomID = inviteRoom
+		}
+	}
 	if playerID == "" || roomID == "" {
-		remove_cookie(r, w)
+		h.remove_cookie(r, w)
 		h.logger.Warn("Missing attributes")
 		return
 	}
 
 	room, exists := h.service.GetRoom(roomID)
 	if !exists {
-		remove_cookie(r, w)
+		h.remove_cookie(r, w)
 		h.logger.Warn("Error getting room index")
 		return
 	} else if room.Context.Err() != nil {
-		remove_cookie(r, w)
+		h.remove_cookie(r, w)
 		h.logger.Warn("Room has been closed")
 		return
 	}
 
-	tokenPlayerID, _, sessionToken, err := decode_cookie(r, w)
-	if err == nil {
-		playerID = tokenPlayerID
+	var sessionToken string
+	ses, err := h.service.readSession(r)
+	if err == nil && ses.Room == room.ID {
+		playerID = ses.Player
+		sessionToken = ses.Token
 	} else if errors.Is(err, ErrInvalidCookie) {
+		h.remove_cookie(r, w)
 		h.logger.Warn("Invalid token")
 		return
 	}
 
 	curPlayer, exists := room.GetConnection(playerID)
 	if !exists {
-		h.connect(w, r, playerID, room, password)
+		h.connect(w, r, playerID, room, password, invite)
 	} else if curPlayer.SessionToken == sessionToken {
 		h.reconnect(w, r, curPlayer, room)
 	} else {
@@ -151,11 +494,67 @@ func (h *Handlers) JoinRoom(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *Handlers) connect(w http.ResponseWriter, r *http.Request, playerID game.PlayerID, room *Room, password string) {
+// Spectate connects to a room as a spectator. Spectators can join full or already started rooms,
+// only recieve public updates and can't perform any action.
+func (h *Handlers) Spectate(w http.ResponseWriter, r *http.Request) {
+	if h.rejectWhileDraining(w) {
+		return
+	}
+	roomID := strings.ToUpper(r.URL.Query().Get("room"))
+	name := game.PlayerID(r.URL.Query().Get("name"))
+	password := r.URL.Query().Get("password")
+	if roomID == "" {
+		h.logger.Warn("Missing attributes")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("missing room attribute"))
+		return
+	}
+	if name == "" {
+		name = "spectator"
+	}
+
+	room, exists := h.service.GetRoom(roomID)
+	if !exists {
+		h.logger.Warn("Error getting room index")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("room not found"))
+		return
+	} else if room.Context.Err() != nil {
+		h.logger.Warn("Room has been closed")
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte("room has been closed"))
+		return
+	}
+
+	connection := NewSpectatorConnection(name)
+	ws, err := h.upgradeConnection(w, r, nil)
+	if err != nil {
+		h.logger.Warn(fmt.Sprintf("Error upgrading connection: %s", err), "err", err)
+		return
+	}
+	wslogger := h.logger.With("room_id", room.ID, "spectator", connection.ID)
+	stopWS := h.handleWS(ws, clientIP(r), connection, room, wslogger)
+	if err := h.service.JoinRoom(room.ID, connection, password); err != nil {
+		h.logger.Warn(fmt.Sprintf("Error spectating room: %s", err), "err", err)
+		room.sendError(connection, err)
+		stopWS()
+		return
+	}
+	h.logger.Info(fmt.Sprintf("Spectator %s joined room %s", name, room.ID))
+	metrics.IncSpectatorsTotal()
+}
+
+// connect joins a new player to the room, with the room password or an invite if not empty.
+func (h *Handlers) connect(w http.ResponseWriter, r *http.Request, playerID game.PlayerID, room *Room, password string, invite string) {
 	connection := NewConnection(playerID)
-	sesCookie := encode_cookie(connection.ID, room.ID, connection.SessionToken)
+	sesCookie, err := h.service.newSessionCookie(r, connection.ID, room.ID, connection.SessionToken)
+	if err != nil {
+		h.logger.Warn(fmt.Sprintf("Error creating session: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	ws, err := upgradeConnection(w, r, sesCookie)
+	ws, err := h.upgradeConnection(w, r, sesCookie)
 	if err != nil {
 		h.logger.Warn(fmt.Sprintf("Error upgrading connection: %s", err))
 		w.WriteHeader(http.StatusInternalServerError)
@@ -163,8 +562,13 @@ func (h *Handlers) connect(w http.ResponseWriter, r *http.Request, playerID game
 		return
 	}
 	wslogger := h.logger.With("room_id", room.ID, "player_id", connection.ID)
-	stopWS := handleWS(ws, connection, room, wslogger)
-	if err := h.service.JoinRoom(room.ID, connection, password); err != nil {
+	stopWS := h.handleWS(ws, clientIP(r), connection, room, wslogger)
+	join := func() error { return h.service.JoinRoom(room.ID, connection, password) }
+	if invite != "" {
+		join = func() error { return h.service.JoinRoomWithInvite(room.ID, invite, connection) }
+	}
+	if err := join(); err != nil {
+		room.sendError(connection, err)
 		stopWS()
 		h.logger.Warn(fmt.Sprintf("Error joining room: %s", err), "err", err)
 		w.WriteHeader(http.StatusInternalServerError) // FIXME: headers already sent
@@ -176,7 +580,19 @@ func (h *Handlers) connect(w http.ResponseWriter, r *http.Request, playerID game
 }
 
 func (h *Handlers) reconnect(w http.ResponseWriter, r *http.Request, conn *Connection, room *Room) {
-	ws, err := upgradeConnection(w, r, nil)
+	// drop the previous socket if it's still open, i.e. the player opened the game in a new tab
+	conn.Disconnect()
+	// updates queued while disconnected are replaced by the rejoin state or resent from the history,
+	// drop them before the new socket starts sending
+	conn.ClearPendingUpdates()
+	// renewed so it doesn't expire while playing and gets signed with the newest secret
+	sesCookie, err := h.service.newSessionCookie(r, conn.Player.ID, room.ID, conn.SessionToken)
+	if err != nil {
+		h.logger.Warn(fmt.Sprintf("Error creating session: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ws, err := h.upgradeConnection(w, r, sesCookie)
 	if err != nil {
 		h.logger.Warn(fmt.Sprintf("Error upgrading connection: %s", err), "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -184,8 +600,13 @@ func (h *Handlers) reconnect(w http.ResponseWriter, r *http.Request, conn *Conne
 		return
 	}
 	wslogger := h.logger.With("room_id", room.ID, "player_id", conn.Player.ID)
-	stopWS := handleWS(ws, conn, room, wslogger)
-	if err := h.service.JoinRoomWithoutPassword(room.ID, conn); err != nil {
+	stopWS := h.handleWS(ws, clientIP(r), conn, room, wslogger)
+	join := func() error { return h.service.JoinRoomWithoutPassword(room.ID, conn) }
+	// clients that know the last update they recieved only get the ones they missed
+	if lastSeq, err := strconv.Atoi(r.URL.Query().Get("last_seq")); err == nil {
+		join = func() error { return h.service.ResumeRoom(room.ID, conn, lastSeq) }
+	}
+	if err := join(); err != nil {
 		stopWS()
 		h.logger.Warn(fmt.Sprintf("Error joining room: %s", err), "err", err)
 		w.WriteHeader(http.StatusInternalServerError) // FIXME: headers already sent
@@ -196,80 +617,299 @@ func (h *Handlers) reconnect(w http.ResponseWriter, r *http.Request, conn *Conne
 	metrics.IncConnectionsTotal(true)
 }
 
-const TOKEN_COOKIE_NAME = "session_token"
-const TOKEN_SEPARATOR = "::"
+type InviteConfig struct {
+	// seconds the invite is valid for, 0 uses the default
+	Duration int `json:"duration"`
+	// 0 means unlimited
+	MaxUses int `json:"max_uses"`
+}
+
+// CreateInvite creates an invite to the room of the session cookie. Only the leader can create invites.
+func (h *Handlers) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	ses, err := h.service.readSession(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("no session"))
+		return
+	}
+	var config InviteConfig
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("error decoding invite config"))
+			return
+		}
+	}
+	room, exists := h.service.GetRoom(ses.Room)
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("room not found"))
+		return
+	}
+	if !h.isSeatOwner(r, room, ses.Player) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("session is not valid for the room"))
+		return
+	}
+	invite, err := h.service.CreateInvite(room.ID, ses.Player, time.Duration(config.Duration)*time.Second, config.MaxUses)
+	if errors.Is(err, ErrNotRoomLeader) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	} else if errors.Is(err, ErrInvalidInviteDuration) || errors.Is(err, ErrInvalidInviteUses) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	} else if err != nil {
+		h.logger.Warn(fmt.Sprintf("Error creating invite: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(invite); err != nil {
+		h.logger.Warn(fmt.Sprintf("Error encoding invite: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+type InviteInfo struct {
+	Room string `json:"room"`
+}
+
+// GetInvite returns the room of an invite token or code, so it can be joined.
+func (h *Handlers) GetInvite(w http.ResponseWriter, r *http.Request) {
+	roomID, err := h.service.InviteRoom(mux.Vars(r)["invite"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(InviteInfo{Room: roomID}); err != nil {
+		h.logger.Warn(fmt.Sprintf("Error encoding invite: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
 
-var ErrInvalidCookie = fmt.Errorf("invalid token")
+type SessionInfo struct {
+	Player game.PlayerID `json:"player"`
+	Room   string        `json:"room"`
+}
 
-func decode_cookie(r *http.Request, w http.ResponseWriter) (game.PlayerID, string, string, error) {
-	cookie, err := r.Cookie(TOKEN_COOKIE_NAME)
+// Session returns the player and room of the session cookie, so the frontend can rejoin
+// the last room without reading the cookie.
+func (h *Handlers) Session(w http.ResponseWriter, r *http.Request) {
+	ses, err := h.service.readSession(r)
 	if err != nil {
-		return "", "", "", err
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no session"))
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(SessionInfo{Player: ses.Player, Room: ses.Room}); err != nil {
+		h.logger.Warn(fmt.Sprintf("Error encoding session: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+type AdminCloseRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AdminPlayerRequest struct {
+	Player game.PlayerID `json:"player"`
+	// only used to kick players
+	Ban bool `json:"ban"`
+}
+
+type AdminMessageRequest struct {
+	Message string `json:"message"`
+}
+
+type AdminMessageResult struct {
+	Rooms int `json:"rooms"`
+}
+
+// AdminListRooms returns the details of every open room.
+func (h *Handlers) AdminListRooms(w http.ResponseWriter, r *http.Request) {
+	h.writeAdminJSON(w, h.service.AdminRooms())
+}
 
-	if cookie == nil {
-		return "", "", "", http.ErrNoCookie
+// AdminGetRoom returns the details of a room.
+func (h *Handlers) AdminGetRoom(w http.ResponseWriter, r *http.Request) {
+	info, err := h.service.AdminRoom(mux.Vars(r)["room"])
+	if err != nil {
+		h.writeAdminError(w, err)
+		return
 	}
+	h.writeAdminJSON(w, info)
+}
 
-	parts := strings.Split(cookie.Value, TOKEN_SEPARATOR)
-	if len(parts) != 3 {
-		if err := remove_cookie(r, w); err != nil {
-			return "", "", "", fmt.Errorf("error upgrading connection on decode: %w", err)
+// AdminRoomState returns the full state of a room, including every hand.
+func (h *Handlers) AdminRoomState(w http.ResponseWriter, r *http.Request) {
+	state, err := h.service.AdminRoomState(mux.Vars(r)["room"])
+	if err != nil {
+		h.writeAdminError(w, err)
+		return
+	}
+	h.writeAdminJSON(w, state)
+}
+
+// AdminCloseRoom closes a room, sending the reason to everyone in it.
+func (h *Handlers) AdminCloseRoom(w http.ResponseWriter, r *http.Request) {
+	var req AdminCloseRequest
+	if !h.decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if err := h.service.AdminCloseRoom(mux.Vars(r)["room"], req.Reason); err != nil {
+		h.writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminKickPlayer removes a player from a room, optionally banning it.
+func (h *Handlers) AdminKickPlayer(w http.ResponseWriter, r *http.Request) {
+	var req AdminPlayerRequest
+	if !h.decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if err := h.service.AdminKick(mux.Vars(r)["room"], req.Player, req.Ban); err != nil {
+		h.writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminRemoveBot stops a bot playing in a room.
+func (h *Handlers) AdminRemoveBot(w http.ResponseWriter, r *http.Request) {
+	var req AdminPlayerRequest
+	if !h.decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if err := h.service.AdminRemoveBot(mux.Vars(r)["room"], req.Player); err != nil {
+		h.writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminSendMessage sends a system message to the room in the path, or to every room if there is none.
+func (h *Handlers) AdminSendMessage(w http.ResponseWriter, r *http.Request) {
+	var req AdminMessageRequest
+	if !h.decodeAdminRequest(w, r, &req) {
+		return
+	}
+	roomID, ok := mux.Vars(r)["room"]
+	if !ok {
+		sent, err := h.service.AnnounceAll(req.Message)
+		if err != nil {
+			h.writeAdminError(w, err)
+			return
 		}
-		return "", "", "", ErrInvalidCookie
+		h.writeAdminJSON(w, AdminMessageResult{Rooms: sent})
+		return
+	}
+	if err := h.service.Announce(roomID, req.Message); err != nil {
+		h.writeAdminError(w, err)
+		return
 	}
-	return game.PlayerID(parts[0]), parts[1], parts[2], nil
+	h.writeAdminJSON(w, AdminMessageResult{Rooms: 1})
 }
 
-func encode_cookie(player game.PlayerID, room string, token string) *http.Cookie {
-	encoded := fmt.Sprintf("%s%s%s%s%s", player, TOKEN_SEPARATOR, room, TOKEN_SEPARATOR, token)
-	return &http.Cookie{
-		Name:    TOKEN_COOKIE_NAME,
-		Value:   encoded,
-		Expires: time.Now().Add(24 * time.Hour),
+func (h *Handlers) decodeAdminRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("error decoding request"))
+		return false
+	}
+	return true
+}
+
+func (h *Handlers) writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Warn(fmt.Sprintf("Error encoding admin response: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
-func remove_cookie(r *http.Request, w http.ResponseWriter) error {
+func (h *Handlers) writeAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrRoomNotFound), errors.Is(err, ErrRoomClosed), errors.Is(err, game.ErrPlayerNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, ErrNotABot), errors.Is(err, ErrInvalidSystemMessage):
+		w.WriteHeader(http.StatusBadRequest)
+	// the game doesn't allow it right now
+	case errors.Is(err, game.ErrNotEnoughPlayers), errors.Is(err, game.ErrGameAlreadyStarted), errors.Is(err, game.ErrPendingDiscard):
+		w.WriteHeader(http.StatusConflict)
+	default:
+		h.logger.Warn(fmt.Sprintf("Error in admin request: %s", err), "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write([]byte(err.Error()))
+}
+
+func (h *Handlers) remove_cookie(r *http.Request, w http.ResponseWriter) error {
 	c := &http.Cookie{
-		Name:    TOKEN_COOKIE_NAME,
-		Value:   "",
-		Expires: time.Now().Add(-24 * time.Hour),
+		Name:     TOKEN_COOKIE_NAME,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Now().Add(-24 * time.Hour),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   isSecureRequest(r),
 	}
-	ws, err := upgradeConnection(w, r, c)
-	defer ws.Close()
+	ws, err := h.upgradeConnection(w, r, c)
 	if err != nil {
 		return fmt.Errorf("error upgrading connection to remove token: %w", err)
 	}
+	defer ws.Close()
 	return nil
 }
 
-func upgradeConnection(w http.ResponseWriter, r *http.Request, cookie *http.Cookie) (*websocket.Conn, error) {
+func (h *Handlers) upgradeConnection(w http.ResponseWriter, r *http.Request, cookie *http.Cookie) (*websocket.Conn, error) {
 	var header http.Header
 	if cookie != nil {
 		header = http.Header{"Set-Cookie": []string{cookie.String()}}
 	}
-	ws, err := upgrader.Upgrade(w, r, header)
+	ws, err := h.upgrader.Upgrade(w, r, header)
 	if err != nil {
 		return nil, fmt.Errorf("error upgrading connection: %w", err)
 	}
+	ws.SetReadLimit(MAX_MESSAGE_SIZE)
 	return ws, nil
 }
 
-func handleWS(ws *websocket.Conn, conn *Connection, room *Room, logger *slog.Logger) func() {
+// handleWS starts the loops sending updates to the websocket and reading actions from it, rate limiting
+// the actions of the client. Returns a function to stop both loops.
+func (h *Handlers) handleWS(ws *websocket.Conn, client string, conn *Connection, room *Room, logger *slog.Logger) func() {
 	ctx, cancelWSContext := context.WithCancel(room.Context)
 	stopWS := func() {
 		cancelWSContext()
-		ws.Close()
 	}
+	socketID := conn.Attach(stopWS)
 	player := conn.Player
 
+	room.sockets.Add(1)
 	go func() {
+		defer room.sockets.Done()
 		logger.Info(fmt.Sprintf("Started socket write loop for player %s", player.ID))
-		tick := time.NewTicker(10 * time.Second)
+		tick := time.NewTicker(PING_INTERVAL)
+		defer tick.Stop()
+		defer room.notifySocketClosed(conn, socketID)
+		defer ws.Close()
 		for {
 			select {
 			case update := <-conn.Updates:
+				if ctx.Err() != nil && !conn.isAttached(socketID) {
+					// a newer socket took over the connection, the update is for it
+					conn.queue(update)
+					return
+				}
 				metrics.IncWebsocketOutgoing()
 				logger.Info(
 					fmt.Sprintf("Sending update to player %s", player.ID),
@@ -288,12 +928,12 @@ func handleWS(ws *websocket.Conn, conn *Connection, room *Room, logger *slog.Log
 				}
 			case <-ctx.Done():
 				logger.Info(fmt.Sprintf("Stopping socket write loop for player %s", player.ID))
-				for i := 0; i < len(conn.Updates); i++ {
+				// pending updates belong to the new socket if the player reconnected
+				for conn.isAttached(socketID) && len(conn.Updates) > 0 {
 					update := <-conn.Updates
 					logger.Info(fmt.Sprintf("Sending last buffered messages for player %s", player.ID), "update", update)
 					if err := ws.WriteJSON(update); err != nil {
 						logger.Error(fmt.Sprintf("error sending update to player %s: %s", player.ID, err))
-						stopWS()
 						return
 					}
 				}
@@ -303,26 +943,59 @@ func handleWS(ws *websocket.Conn, conn *Connection, room *Room, logger *slog.Log
 		}
 	}()
 
+	// clients that stop answering pings are disconnected
+	ws.SetReadDeadline(time.Now().Add(PONG_WAIT))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(PONG_WAIT))
+	})
+	disconnect := func(err error) {
+		if code, reason, ok := violation(err); ok {
+			logger.Warn(fmt.Sprintf("Closing socket of player %s: %s", player.ID, err), "reason", reason)
+			metrics.IncWebsocketViolation(reason)
+			closeWithCode(ws, code, err.Error())
+		}
+		logger.Info(fmt.Sprintf("Stopping socket read loop for player %s", player.ID))
+		stopWS()
+	}
+
 	go func() {
 		logger.Info(fmt.Sprintf("Started socket read loop for player %s", player.ID))
 		tick := time.NewTicker(1 * time.Second)
+		defer tick.Stop()
 		for {
 			select {
 			case <-tick.C:
 				_, message, err := ws.ReadMessage()
 				if err != nil {
 					logger.Error(fmt.Sprintf("Error reading message from player %s: %s", player.ID, err), "err", err)
-					logger.Info(fmt.Sprintf("Stopping socket read loop for player %s", player.ID))
-					stopWS()
+					disconnect(err)
 					return
 				}
 				metrics.IncWebsocketIncoming()
 				metrics.ObserveWebsocketIncomingSize(float64(len(message)))
 				action, err := NewActionFromRawMessage(message)
-				if err != nil {
+				if _, _, ok := violation(err); ok {
+					disconnect(err)
+					return
+				} else if err != nil {
 					logger.Error(fmt.Sprintf("Error unmarshalling action from player %s: %s", player.ID, err), "err", err)
 					continue
 				}
+				if conn.Spectator {
+					logger.Warn(fmt.Sprintf("Spectator %s tried to perform an action", player.ID), "action", action)
+					room.sendError(conn, ErrSpectatorAction)
+					continue
+				}
+				if err := h.service.limiter.allow(client, LimitActions, room.ID+"/"+string(player.ID), time.Now()); err != nil {
+					logger.Warn(fmt.Sprintf("Player %s is sending actions too fast", player.ID), "err", err)
+					room.sendError(conn, err)
+					if errors.Is(err, ErrClientBanned) {
+						closeWithCode(ws, websocket.ClosePolicyViolation, err.Error())
+						stopWS()
+						return
+					}
+					continue
+				}
 				conn.QueueAction(action)
 			case <-ctx.Done():
 				logger.Info(fmt.Sprintf("Stopping socket read loop for player %s", player.ID))
@@ -343,3 +1016,42 @@ func unmarshallTypeFromMessage(message []byte) string {
 	}
 	return actionType.Type
 }
+
+// rejectWhileDraining responds with 503 if the service is shutting down.
+func (h *Handlers) rejectWhileDraining(w http.ResponseWriter) bool {
+	if !h.service.Draining() {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(h.service.cfg.RestartEstimate.Seconds())))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(ErrServiceShuttingDown.Error()))
+	return true
+}
+
+// intParam parses an integer query parameter, returning def if it's empty.
+func intParam(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// timeParam parses a date or RFC 3339 timestamp query parameter, returning the zero time if it's empty.
+func timeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// clientIP returns the IP the request comes from, used to limit the rooms created by a client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,201 @@
+package tincho
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+const INVITE_CODE_LENGTH = 6
+
+// letters and digits that are hard to confuse with each other when read aloud or typed
+const INVITE_CODE_CHARS = "ACDEFHJKMNPQRTUVWXY34679"
+
+const DEFAULT_INVITE_DURATION = 24 * time.Hour
+const MAX_INVITE_DURATION = 7 * 24 * time.Hour
+
+const INVITE_ID_BYTES = 16
+
+var ErrInvalidInvite = errors.New("invalid invite")
+var ErrInviteExpired = errors.New("invite expired")
+var ErrInviteUsedUp = errors.New("invite has no uses left")
+var ErrInvalidInviteDuration = fmt.Errorf("invite duration should be between 0 and %s", MAX_INVITE_DURATION)
+var ErrInvalidInviteUses = errors.New("invite max uses can't be negative")
+
+// Invite lets whoever holds it join a room without the password, until it expires or runs out of uses.
+// It can be shared as a link with the token, or as the short code for people typing it.
+type Invite struct {
+	Token     string    `json:"token"`
+	Code      string    `json:"code"`
+	Room      string    `json:"room"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// 0 means unlimited
+	MaxUses int `json:"max_uses"`
+}
+
+// inviteClaims is the signed content of an invite token.
+type inviteClaims struct {
+	ID      string `json:"id"`
+	Room    string `json:"room"`
+	Expires int64  `json:"expires"`
+}
+
+type inviteUses struct {
+	room    string
+	code    string
+	expires time.Time
+	maxUses int
+	uses    int
+}
+
+// invites keeps the uses of every invite and the token each code maps to.
+// They are only kept in memory, so invites stop working after a restart.
+type invites struct {
+	mu     sync.Mutex
+	byID   map[string]*inviteUses
+	byCode map[string]string
+}
+
+func newInvites() *invites {
+	return &invites{
+		byID:   make(map[string]*inviteUses),
+		byCode: make(map[string]string),
+	}
+}
+
+// add registers an invite with a new unused code, dropping the expired ones and the ones of
+// closed rooms. Returns the code.
+func (inv *invites) add(id string, token string, uses *inviteUses, open func(roomID string) bool) string {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	now := time.Now()
+	for oldID, old := range inv.byID {
+		if now.After(old.expires) || !open(old.room) {
+			delete(inv.byCode, old.code)
+			delete(inv.byID, oldID)
+		}
+	}
+	code := randomString(INVITE_CODE_CHARS, INVITE_CODE_LENGTH)
+	for _, taken := inv.byCode[code]; taken; _, taken = inv.byCode[code] {
+		code = randomString(INVITE_CODE_CHARS, INVITE_CODE_LENGTH)
+	}
+	uses.code = code
+	inv.byID[id] = uses
+	inv.byCode[code] = token
+	return code
+}
+
+func (inv *invites) token(code string) (string, bool) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	token, ok := inv.byCode[strings.ToUpper(code)]
+	return token, ok
+}
+
+// use takes one of the uses left of an invite.
+func (inv *invites) use(id string) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	uses, ok := inv.byID[id]
+	if !ok {
+		return ErrInvalidInvite
+	}
+	if uses.maxUses > 0 && uses.uses >= uses.maxUses {
+		return ErrInviteUsedUp
+	}
+	uses.uses++
+	return nil
+}
+
+// refund gives back a use taken by a player that couldn't join.
+func (inv *invites) refund(id string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if uses, ok := inv.byID[id]; ok && uses.uses > 0 {
+		uses.uses--
+	}
+}
+
+// CreateInvite creates an invite to the room. Only the leader of the room can create invites.
+// A duration of 0 uses DEFAULT_INVITE_DURATION and 0 max uses means unlimited.
+func (g *Service) CreateInvite(roomID string, player game.PlayerID, duration time.Duration, maxUses int) (Invite, error) {
+	room, exists := g.GetRoom(roomID)
+	if !exists || room.HasClosed() {
+		return Invite{}, fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+	}
+	if room.Info().Leader != player {
+		return Invite{}, ErrNotRoomLeader
+	}
+	if duration < 0 || duration > MAX_INVITE_DURATION {
+		return Invite{}, ErrInvalidInviteDuration
+	}
+	if duration == 0 {
+		duration = DEFAULT_INVITE_DURATION
+	}
+	if maxUses < 0 {
+		return Invite{}, ErrInvalidInviteUses
+	}
+	expires := time.Now().Add(duration)
+	claims := inviteClaims{ID: generateToken(INVITE_ID_BYTES), Room: roomID, Expires: expires.Unix()}
+	token, err := g.sessions.seal(SIGNED_INVITE, claims)
+	if err != nil {
+		return Invite{}, err
+	}
+	uses := &inviteUses{room: roomID, expires: expires, maxUses: maxUses}
+	code := g.invites.add(claims.ID, token, uses, func(roomID string) bool {
+		room, exists := g.GetRoom(roomID)
+		return exists && !room.HasClosed()
+	})
+	return Invite{Token: token, Code: code, Room: roomID, ExpiresAt: expires, MaxUses: maxUses}, nil
+}
+
+// resolveInvite returns the claims of an invite given its token or code, checking it hasn't expired.
+func (g *Service) resolveInvite(invite string) (inviteClaims, error) {
+	token := invite
+	if len(invite) == INVITE_CODE_LENGTH {
+		var ok bool
+		if token, ok = g.invites.token(invite); !ok {
+			return inviteClaims{}, ErrInvalidInvite
+		}
+	}
+	var claims inviteClaims
+	if err := g.sessions.open(SIGNED_INVITE, token, &claims); err != nil {
+		return inviteClaims{}, ErrInvalidInvite
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return inviteClaims{}, ErrInviteExpired
+	}
+	return claims, nil
+}
+
+// InviteRoom returns the room an invite token or code is for.
+func (g *Service) InviteRoom(invite string) (string, error) {
+	claims, err := g.resolveInvite(invite)
+	if err != nil {
+		return "", err
+	}
+	return claims.Room, nil
+}
+
+// JoinRoomWithInvite joins a room without its password using one of the uses of an invite.
+func (g *Service) JoinRoomWithInvite(roomID string, invite string, conn *Connection) error {
+	claims, err := g.resolveInvite(invite)
+	if err != nil {
+		return err
+	}
+	if claims.Room != roomID {
+		return ErrInvalidInvite
+	}
+	if err := g.invites.use(claims.ID); err != nil {
+		return err
+	}
+	if err := g.JoinRoomWithoutPassword(roomID, conn); err != nil {
+		g.invites.refund(claims.ID)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package tincho
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// LEADER_TIMEOUT is how long a disconnected leader keeps leadership before it moves to another player.
+const LEADER_TIMEOUT = 30 * time.Second
+
+var ErrPlayerBanned = errors.New("player banned from room")
+var ErrKickSelf = errors.New("leader can't kick itself")
+var ErrInvalidLeader = errors.New("leadership can only be transfered to a human player in the room")
+
+func (r *Room) isLeader(playerID game.PlayerID) bool {
+	return r.leader != "" && r.leader == playerID
+}
+
+func (r *Room) isConnected(playerID game.PlayerID) bool {
+	_, disconnected := r.disconnected[playerID]
+	return !disconnected
+}
+
+// setLeader changes the room leader and notifies all players.
+func (r *Room) setLeader(playerID game.PlayerID) {
+	if r.leader == playerID {
+		return
+	}
+	r.leader = playerID
+	r.logger.Info(fmt.Sprintf("Leader changed #%s: %s", r.ID, playerID))
+	r.BroadcastUpdate(Update[UpdateLeaderChangedData]{
+		Type: UpdateTypeLeaderChanged,
+		Data: UpdateLeaderChangedData{Leader: playerID},
+	})
+}
+
+// nextLeader looks for the next connected human after the current leader in seat order.
+func (r *Room) nextLeader() (game.PlayerID, bool) {
+	players := r.state.GetPlayers()
+	start := slices.IndexFunc(players, func(p *game.Player) bool { return p.ID == r.leader })
+	for i := 1; i <= len(players); i++ {
+		p := players[(start+i+len(players))%len(players)]
+		if p.ID == r.leader || !r.isConnected(p.ID) {
+			continue
+		}
+		if conn, ok := r.connections[p.ID]; !ok || conn.Bot {
+			continue
+		}
+		return p.ID, true
+	}
+	return "", false
+}
+
+// replaceLeader moves leadership to the next connected human, if any.
+func (r *Room) replaceLeader() {
+	if next, ok := r.nextLeader(); ok {
+		r.setLeader(next)
+	}
+}
+
+func (r *Room) playerDisconnected(conn *Connection) {
+	if !r.isPlayerInRoom(conn.ID) {
+		return
+	}
+	r.disconnected[conn.ID] = time.Now()
+	if r.isLeader(conn.ID) {
+		r.schedule(LEADER_TIMEOUT, r.checkLeaderTimeout)
+	}
+	r.scheduleTakeover(conn.ID)
+}
+
+// checkLeaderTimeout replaces the leader if it has been disconnected for longer than LEADER_TIMEOUT.
+func (r *Room) checkLeaderTimeout() {
+	disconnectedAt, disconnected := r.disconnected[r.leader]
+	if disconnected && time.Since(disconnectedAt) >= LEADER_TIMEOUT {
+		r.logger.Info(fmt.Sprintf("Leader timed out #%s: %s", r.ID, r.leader))
+		r.replaceLeader()
+	}
+}
+
+// removePlayer takes a player out of the room. If the player was the leader, leadership moves
+// to the next connected human, or to any remaining player if there are no humans left.
+func (r *Room) removePlayer(playerID game.PlayerID) (*Connection, error) {
+	player, exists := r.state.GetPlayer(playerID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", game.ErrPlayerNotFound, playerID)
+	}
+	pendingFirstPeek := player.PendingFirstPeek
+	// the next leader is picked by seat order, so it's looked up before the seat is gone
+	next, hasNext := r.nextLeader()
+	wasTurn, err := r.state.RemovePlayer(playerID)
+	if err != nil {
+		return nil, fmt.Errorf("RemovePlayer: %w", err)
+	}
+	conn := r.connections[playerID]
+	delete(r.connections, playerID)
+	delete(r.disconnected, playerID)
+	delete(r.takenOver, playerID)
+	delete(r.ready, playerID)
+	delete(r.rematchVotes, playerID)
+	r.memory.removePlayer(playerID)
+	if r.isLeader(playerID) {
+		r.leader = ""
+		if hasNext {
+			r.setLeader(next)
+		} else if players := r.state.GetPlayers(); len(players) > 0 {
+			r.setLeader(players[0].ID)
+		}
+	}
+	r.broadcastPlayersChanged()
+	r.updateCountdown()
+	if r.state.Playing() && r.state.AllPlayersFirstPeeked() && (wasTurn || pendingFirstPeek) {
+		if err := r.broadcastPassTurn(); err != nil {
+			return nil, fmt.Errorf("broadcastPassTurn: %w", err)
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,108 @@
+package tincho
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// min players needed for a game to start automatically
+const MIN_PLAYERS_TO_START = 2
+
+// max seconds a room can be configured to wait before starting
+const MAX_START_COUNTDOWN = 60
+
+func (r *Room) isReady(playerID game.PlayerID) bool {
+	if conn, ok := r.connections[playerID]; ok && conn.Bot {
+		return true
+	}
+	return r.ready[playerID]
+}
+
+func (r *Room) doReady(action Action[ActionReadyData]) error {
+	if r.state.Playing() {
+		return game.ErrGameAlreadyStarted
+	}
+	if action.Data.Ready {
+		r.ready[action.PlayerID] = true
+	} else {
+		delete(r.ready, action.PlayerID)
+	}
+	r.broadcastPlayersChanged()
+	r.updateCountdown()
+	return nil
+}
+
+// updateCountdown starts the countdown to auto start the game if enough players are ready,
+// or cancels a running one if they aren't anymore.
+func (r *Room) updateCountdown() {
+	if !r.settings.AutoStart || r.state.Playing() {
+		return
+	}
+	players := r.state.GetPlayers()
+	ready := 0
+	for _, p := range players {
+		if r.isReady(p.ID) {
+			ready++
+		}
+	}
+	needed := r.settings.MinReady
+	if needed <= 0 || needed > len(players) {
+		needed = len(players)
+	}
+	shouldStart := len(players) >= MIN_PLAYERS_TO_START && ready >= needed
+	if shouldStart && r.countdownTimer == nil {
+		r.startCountdown()
+	} else if !shouldStart && r.countdownTimer != nil {
+		r.cancelCountdown()
+		r.BroadcastUpdate(Update[UpdateStartCountdownData]{
+			Type: UpdateTypeStartCountdown,
+			Data: UpdateStartCountdownData{Cancelled: true},
+		})
+	}
+}
+
+func (r *Room) startCountdown() {
+	r.countdownID++
+	countdownID := r.countdownID
+	r.BroadcastUpdate(Update[UpdateStartCountdownData]{
+		Type: UpdateTypeStartCountdown,
+		Data: UpdateStartCountdownData{
+			Seconds: int(math.Ceil(r.settings.StartCountdown.Seconds())),
+		},
+	})
+	r.countdownEnds = time.Now().Add(r.settings.StartCountdown)
+	r.countdownTimer = r.schedule(r.settings.StartCountdown, func() {
+		if countdownID != r.countdownID {
+			return
+		}
+		r.countdownTimer = nil
+		r.logger.Info(fmt.Sprintf("Auto starting game #%s", r.ID))
+		if err := r.startGame(); err != nil {
+			r.logger.Error("error auto starting game", "err", err)
+		}
+	})
+}
+
+// countdownLeft returns the seconds left for the game to auto start, nil if there is no countdown.
+func (r *Room) countdownLeft() *int {
+	if r.countdownTimer == nil {
+		return nil
+	}
+	seconds := int(math.Ceil(time.Until(r.countdownEnds).Seconds()))
+	if seconds < 0 {
+		seconds = 0
+	}
+	return &seconds
+}
+
+// cancelCountdown stops the running countdown without notifying players.
+func (r *Room) cancelCountdown() {
+	r.countdownID++
+	if r.countdownTimer != nil {
+		r.countdownTimer.Stop()
+		r.countdownTimer = nil
+	}
+}
@@ -0,0 +1,113 @@
+package tincho
+
+import (
+	"slices"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// KnownCard is a card a player has legitimately seen and the place where it is now.
+type KnownCard struct {
+	Player   game.PlayerID `json:"player"`
+	Position int           `json:"position"`
+	Card     game.Card     `json:"card"`
+}
+
+// cardMemory keeps track of the cards each player has seen and follows them as they move around hands.
+// Keys are the players that saw the cards.
+type cardMemory map[game.PlayerID][]KnownCard
+
+func (m cardMemory) reset() {
+	for viewer := range m {
+		delete(m, viewer)
+	}
+}
+
+// see records that the viewer knows the card at the given hand position.
+func (m cardMemory) see(viewer game.PlayerID, owner game.PlayerID, position int, card game.Card) {
+	known := slices.DeleteFunc(m[viewer], func(k KnownCard) bool {
+		return k.Player == owner && k.Position == position
+	})
+	m[viewer] = append(known, KnownCard{Player: owner, Position: position, Card: card})
+}
+
+// seeAll records that every given player knows the card at the given hand position.
+func (m cardMemory) seeAll(viewers []*game.Player, owner game.PlayerID, position int, card game.Card) {
+	for _, v := range viewers {
+		m.see(v.ID, owner, position, card)
+	}
+}
+
+// forget removes the knowledge of a hand position for all players. Used when the card in that
+// position is replaced without others seeing the new card.
+func (m cardMemory) forget(owner game.PlayerID, position int) {
+	for viewer, known := range m {
+		m[viewer] = slices.DeleteFunc(known, func(k KnownCard) bool {
+			return k.Player == owner && k.Position == position
+		})
+	}
+}
+
+// swap moves the known cards between two hand positions for all players.
+func (m cardMemory) swap(owner1 game.PlayerID, position1 int, owner2 game.PlayerID, position2 int) {
+	for _, known := range m {
+		for ix, k := range known {
+			if k.Player == owner1 && k.Position == position1 {
+				known[ix].Player, known[ix].Position = owner2, position2
+			} else if k.Player == owner2 && k.Position == position2 {
+				known[ix].Player, known[ix].Position = owner1, position1
+			}
+		}
+	}
+}
+
+// removePosition removes a position from a hand, shifting the following cards one position to the left.
+func (m cardMemory) removePosition(owner game.PlayerID, position int) {
+	m.forget(owner, position)
+	for _, known := range m {
+		for ix, k := range known {
+			if k.Player == owner && k.Position > position {
+				known[ix].Position--
+			}
+		}
+	}
+}
+
+// removePlayer forgets everything the player knew and everything known about their hand.
+func (m cardMemory) removePlayer(playerID game.PlayerID) {
+	delete(m, playerID)
+	for viewer, known := range m {
+		m[viewer] = slices.DeleteFunc(known, func(k KnownCard) bool {
+			return k.Player == playerID
+		})
+	}
+}
+
+// known returns the cards known by the viewer, sorted by player and position.
+func (m cardMemory) known(viewer game.PlayerID) []KnownCard {
+	known := slices.Clone(m[viewer])
+	slices.SortFunc(known, func(a, b KnownCard) int {
+		if a.Player != b.Player {
+			if a.Player < b.Player {
+				return -1
+			}
+			return 1
+		}
+		return a.Position - b.Position
+	})
+	if known == nil {
+		known = make([]KnownCard, 0)
+	}
+	return known
+}
+
+// knownHand returns the viewer's own hand, with unknown cards left empty.
+func (m cardMemory) knownHand(viewer game.PlayerID, size int) []game.Card {
+	hand := make([]game.Card, size)
+	for _, k := range m[viewer] {
+		if k.Player == viewer && k.Position < size {
+			hand[k.Position] = k.Card
+		}
+	}
+	return hand
+}
@@ -0,0 +1,225 @@
+package tincho
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// RoomObserver is notified of everything that happens in the rooms of a Service, with the
+// full information of every event, including hidden cards. It's meant for side systems like
+// metrics, audit logs or webhooks that shouldn't be wired into the game code.
+//
+// Callbacks other than RoomCreated run in the room goroutine, so they should return quickly and
+// must not wait on the room. Observers that need to do slow work should queue the events and
+// process them in their own goroutine.
+type RoomObserver interface {
+	RoomCreated(event RoomCreatedEvent)
+	PlayerJoined(event PlayerJoinedEvent)
+	PlayerLeft(event PlayerLeftEvent)
+	ActionAccepted(event ActionEvent)
+	ActionRejected(event ActionEvent)
+	UpdateEmitted(event UpdateEvent)
+	RoundEnded(event RoundEndedEvent)
+	GameEnded(event GameEndedEvent)
+	RoomClosed(event RoomClosedEvent)
+}
+
+type RoomCreatedEvent struct {
+	Time     time.Time
+	Room     RoomInfo
+	Settings RoomSettings
+}
+
+type PlayerJoinedEvent struct {
+	Time   time.Time
+	RoomID string
+	Player game.PlayerID
+	Bot    bool
+	// the player already had a seat and opened a new connection
+	Rejoined bool
+}
+
+type PlayerLeftEvent struct {
+	Time   time.Time
+	RoomID string
+	Player game.PlayerID
+	// removed by the leader instead of leaving
+	Kicked bool
+	Banned bool
+}
+
+type ActionEvent struct {
+	Time   time.Time
+	RoomID string
+	Action TypedAction
+	// reason the action was rejected, nil if it was accepted
+	Err error
+}
+
+// UpdateEvent is an update sent by the room, with the same recipients the archive keeps.
+type UpdateEvent struct {
+	Time   time.Time
+	RoomID string
+	Update TypedUpdate
+	// only player that recieved the update, empty if it was sent to everyone
+	Player game.PlayerID
+	// player left out of a broadcasted update
+	Except game.PlayerID
+}
+
+type RoundEndedEvent struct {
+	Time   time.Time
+	RoomID string
+	// who cut and every hand at the end of the round
+	Cut    UpdateCutData
+	Rounds []game.Round
+	// the round was the last one of the game
+	Finished bool
+}
+
+type GameEndedEvent struct {
+	Time   time.Time
+	RoomID string
+	Rounds []game.Round
+	Winner game.PlayerID
+	// every seat of the game, as it would be archived
+	Seats       []ArchivedSeat
+	TotalTurns  int
+	TotalRounds int
+}
+
+type RoomClosedEvent struct {
+	Time   time.Time
+	RoomID string
+	// the room was stopped by the service shutting down and will be restored
+	Stopped bool
+	// empty if the room was stopped
+	Reason CloseReason
+}
+
+// NopObserver implements every RoomObserver callback doing nothing.
+// Embed it to implement only the callbacks needed.
+type NopObserver struct{}
+
+func (NopObserver) RoomCreated(RoomCreatedEvent)   {}
+func (NopObserver) PlayerJoined(PlayerJoinedEvent) {}
+func (NopObserver) PlayerLeft(PlayerLeftEvent)     {}
+func (NopObserver) ActionAccepted(ActionEvent)     {}
+func (NopObserver) ActionRejected(ActionEvent)     {}
+func (NopObserver) UpdateEmitted(UpdateEvent)      {}
+func (NopObserver) RoundEnded(RoundEndedEvent)     {}
+func (NopObserver) GameEnded(GameEndedEvent)       {}
+func (NopObserver) RoomClosed(RoomClosedEvent)     {}
+
+// observers is the list of observers shared by a Service and all its rooms,
+// so observers registered later are notified of events in existing rooms too.
+type observers struct {
+	mu   sync.RWMutex
+	list []RoomObserver
+}
+
+func (o *observers) add(observer RoomObserver) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.list = append(o.list, observer)
+}
+
+// notify calls fn with every registered observer. Safe to call on a nil list.
+func (o *observers) notify(fn func(RoomObserver)) {
+	if o == nil {
+		return
+	}
+	o.mu.RLock()
+	list := slices.Clone(o.list)
+	o.mu.RUnlock()
+	for _, observer := range list {
+		fn(observer)
+	}
+}
+
+// Observe registers an observer for the events of every room in the service.
+func (g *Service) Observe(observer RoomObserver) {
+	g.observers.add(observer)
+}
+
+// gameSeats returns the seats of the game being played as they would be archived.
+func (r *Room) gameSeats() []ArchivedSeat {
+	seats := make([]ArchivedSeat, 0, len(r.state.GetPlayers()))
+	for _, p := range r.state.GetPlayers() {
+		seat := ArchivedSeat{Player: p.ID}
+		if conn, ok := r.connections[p.ID]; ok && conn.Bot {
+			seat.Bot = true
+			seat.BotDifficulty = conn.BotDifficulty
+		} else if r.takenOver[p.ID] {
+			seat.TakenOver = true
+			seat.BotDifficulty = r.settings.TakeoverDifficulty
+		}
+		seats = append(seats, seat)
+	}
+	return seats
+}
+
+func (r *Room) notifyPlayerJoined(conn *Connection, rejoined bool) {
+	r.observers.notify(func(o RoomObserver) {
+		o.PlayerJoined(PlayerJoinedEvent{Time: time.Now(), RoomID: r.ID, Player: conn.ID, Bot: conn.Bot, Rejoined: rejoined})
+	})
+}
+
+func (r *Room) notifyPlayerLeft(playerID game.PlayerID, kicked bool, banned bool) {
+	r.observers.notify(func(o RoomObserver) {
+		o.PlayerLeft(PlayerLeftEvent{Time: time.Now(), RoomID: r.ID, Player: playerID, Kicked: kicked, Banned: banned})
+	})
+}
+
+func (r *Room) notifyAction(action TypedAction, err error) {
+	event := ActionEvent{Time: time.Now(), RoomID: r.ID, Action: action, Err: err}
+	r.observers.notify(func(o RoomObserver) {
+		if err != nil {
+			o.ActionRejected(event)
+		} else {
+			o.ActionAccepted(event)
+		}
+	})
+}
+
+// updateEmitted records an update sent by the room and notifies the observers.
+func (r *Room) updateEmitted(update TypedUpdate, player game.PlayerID, except game.PlayerID) {
+	r.recordUpdate(update, player, except)
+	r.observers.notify(func(o RoomObserver) {
+		o.UpdateEmitted(UpdateEvent{Time: time.Now(), RoomID: r.ID, Update: update, Player: player, Except: except})
+	})
+}
+
+// notifyRoundEnded must be called right after the cut is broadcasted, before the next round starts.
+func (r *Room) notifyRoundEnded(finished bool) {
+	if r.lastCut == nil {
+		return
+	}
+	event := RoundEndedEvent{
+		Time:     time.Now(),
+		RoomID:   r.ID,
+		Cut:      *r.lastCut,
+		Rounds:   r.state.RoundHistory(),
+		Finished: finished,
+	}
+	r.observers.notify(func(o RoomObserver) { o.RoundEnded(event) })
+}
+
+// notifyGameEnded must be called before the state is reset for the next game.
+func (r *Room) notifyGameEnded() {
+	event := GameEndedEvent{
+		Time:        time.Now(),
+		RoomID:      r.ID,
+		Rounds:      r.state.RoundHistory(),
+		Seats:       r.gameSeats(),
+		TotalTurns:  r.state.TotalTurns(),
+		TotalRounds: r.state.TotalRounds(),
+	}
+	if winner, err := r.state.Winner(); err == nil {
+		event.Winner = winner.ID
+	}
+	r.observers.notify(func(o RoomObserver) { o.GameEnded(event) })
+}
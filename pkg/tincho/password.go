@@ -0,0 +1,67 @@
+package tincho
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PBKDF2 parameters used to hash room passwords. Rooms keep the iterations they were hashed with,
+// so they can be raised without breaking rooms restored from the store.
+const PASSWORD_HASH_ITERATIONS = 100_000
+const PASSWORD_SALT_BYTES = 16
+const PASSWORD_KEY_BYTES = 32
+
+const PASSWORD_HASH_PREFIX = "pbkdf2-sha256"
+
+// hashPassword returns the hash of a room password as `pbkdf2-sha256$iterations$salt$key`.
+// Empty passwords stay empty, since they mean the room has no password.
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	salt := make([]byte, PASSWORD_SALT_BYTES)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error generating salt: %w", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, PASSWORD_HASH_ITERATIONS, PASSWORD_KEY_BYTES)
+	if err != nil {
+		return "", fmt.Errorf("error hashing password: %w", err)
+	}
+	return strings.Join([]string{
+		PASSWORD_HASH_PREFIX,
+		strconv.Itoa(PASSWORD_HASH_ITERATIONS),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// verifyPassword reports if password matches a hash created with hashPassword, in constant time.
+func verifyPassword(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != PASSWORD_HASH_PREFIX {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(expected))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
@@ -2,6 +2,8 @@ package tincho
 
 import (
 	"log/slog"
+	"slices"
+	"sync"
 
 	"github.com/manuelpepe/tincho/pkg/game"
 )
@@ -12,6 +14,8 @@ type MarshalledPlayer struct {
 	Points           int           `json:"points"`
 	PendingFirstPeek bool          `json:"pending_first_peek"`
 	CardsInHand      int           `json:"cards_in_hand"`
+	// only set while in the lobby
+	Ready bool `json:"ready"`
 }
 
 func NewMarshalledPlayer(p *game.Player) MarshalledPlayer {
@@ -23,31 +27,122 @@ func NewMarshalledPlayer(p *game.Player) MarshalledPlayer {
 	}
 }
 
+// Size of the update queue of a connection and of the history kept to resend updates after a reconnection.
+const UPDATES_BUFFER_SIZE = 64
+
 type Connection struct {
 	*game.Player
 	SessionToken string
 	Actions      chan TypedAction
 	Updates      chan TypedUpdate
+
+	// Spectator connections only recieve public updates and can't perform actions.
+	Spectator bool
+	// Bot connections are driven by a bot instead of a websocket.
+	Bot bool
+	// used to restart the bot if the room is restored
+	BotDifficulty string
+
+	// only used from the room goroutine
+	chatLimiter *tokenBucket
+
+	// sequence number of the last update sent to the connection
+	lastSeq int
+	// last updates sent to the connection, up to UPDATES_BUFFER_SIZE
+	history []TypedUpdate
+	// player as it was when the last update was sent
+	self MarshalledPlayer
+
+	// stop closes the websocket or bot currently attached to the connection, if any.
+	stop     func()
+	attachID int
+	mu       sync.Mutex
 }
 
 func NewConnection(id game.PlayerID) *Connection {
+	return newConnectionForPlayer(game.NewPlayer(id), generateSessionToken())
+}
+
+func newConnectionForPlayer(player *game.Player, sessionToken string) *Connection {
 	return &Connection{
-		Player:       game.NewPlayer(id),
-		SessionToken: generateRandomString(20),
+		Player:       player,
+		SessionToken: sessionToken,
 		Actions:      make(chan TypedAction),
-		Updates:      make(chan TypedUpdate, 20),
+		Updates:      make(chan TypedUpdate, UPDATES_BUFFER_SIZE),
+		chatLimiter:  newTokenBucket(CHAT_BURST, CHAT_REFILL_INTERVAL),
+		self:         NewMarshalledPlayer(player),
 	}
 }
 
+func NewSpectatorConnection(id game.PlayerID) *Connection {
+	conn := NewConnection(id)
+	conn.Spectator = true
+	return conn
+}
+
+func NewBotConnection(id game.PlayerID) *Connection {
+	conn := NewConnection(id)
+	conn.Bot = true
+	return conn
+}
+
+// Attach registers the function used to stop whatever is currently driving the connection
+// (a websocket or a bot) and returns an id for it.
+func (c *Connection) Attach(stop func()) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stop = stop
+	c.attachID++
+	return c.attachID
+}
+
+// isAttached reports whether the given attach id is the last one registered.
+func (c *Connection) isAttached(id int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.attachID == id
+}
+
+// Disconnect stops the websocket or bot attached to the connection.
+// Websockets flush pending updates before closing.
+func (c *Connection) Disconnect() {
+	c.mu.Lock()
+	stop := c.stop
+	c.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+// Self returns the player of the connection as it was when the last update was sent.
+// The embedded Player belongs to the room goroutine, others should use this instead.
+func (c *Connection) Self() MarshalledPlayer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.self
+}
+
 func (c *Connection) QueueAction(action TypedAction) {
 	action.SetPlayerID(c.ID)
 	c.Actions <- action
 }
 
+// SendUpdateOrDrop numbers the update and queues it, dropping it if the queue is full.
+// Dropped updates are kept in the history so the client can get them back by reconnecting.
 func (c *Connection) SendUpdateOrDrop(update TypedUpdate) {
-	// TODO: instead of default dropping maybe this could block until player reconnects
-	// 	and timeout on room close (important so goroutine it doesn't get stuck forever).
-	//  also could kick player of room after a certain timeout.
+	c.mu.Lock()
+	c.self = NewMarshalledPlayer(c.Player)
+	c.lastSeq++
+	update = update.withSeq(c.lastSeq)
+	c.history = append(c.history, update)
+	if len(c.history) > UPDATES_BUFFER_SIZE {
+		c.history = slices.Delete(c.history, 0, 1)
+	}
+	c.mu.Unlock()
+	c.queue(update)
+}
+
+func (c *Connection) queue(update TypedUpdate) {
 	select {
 	case c.Updates <- update:
 	default:
@@ -55,6 +150,25 @@ func (c *Connection) SendUpdateOrDrop(update TypedUpdate) {
 	}
 }
 
+// updatesSince returns the updates sent after the given sequence number,
+// or false if some of them are not in the history anymore.
+func (c *Connection) updatesSince(seq int) ([]TypedUpdate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	missing := c.lastSeq - seq
+	if seq < 0 || missing < 0 || missing > len(c.history) {
+		return nil, false
+	}
+	return slices.Clone(c.history[len(c.history)-missing:]), true
+}
+
+// resendUpdates queues updates from the history keeping their sequence numbers.
+func (c *Connection) resendUpdates(updates []TypedUpdate) {
+	for _, update := range updates {
+		c.queue(update)
+	}
+}
+
 func (c *Connection) ClearPendingUpdates() {
 loop:
 	for {
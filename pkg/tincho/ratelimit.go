@@ -0,0 +1,224 @@
+package tincho
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/metrics"
+)
+
+// tokenBucket is a simple token bucket rate limiter.
+// It's not safe for concurrent use, it's meant to be used from the room goroutine or with a lock held.
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	// tokens added per second
+	rate float64
+	last time.Time
+}
+
+// newTokenBucket creates a full bucket that holds up to burst tokens and refills one token every interval.
+func newTokenBucket(burst int, interval time.Duration) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(burst),
+		tokens:   float64(burst),
+		rate:     1 / interval.Seconds(),
+	}
+}
+
+// Allow consumes a token if one is available.
+func (b *tokenBucket) Allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+var ErrRateLimited = errors.New("too many requests, slow down")
+var ErrClientBanned = errors.New("temporarily banned for too many requests")
+
+// RateLimit allows Burst requests at once and gives back one every Interval.
+// A zero RateLimit doesn't limit anything.
+type RateLimit struct {
+	Burst    int
+	Interval time.Duration
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Burst > 0 && l.Interval > 0
+}
+
+// AbuseConfig limits how fast clients can use the service. HTTP routes are limited per IP and
+// websocket actions per connection. Clients rejected BanAfter times within BanWindow are banned
+// from every route for BanDuration.
+type AbuseConfig struct {
+	NewRoom RateLimit
+	// also used to spectate
+	Join    RateLimit
+	AddBot  RateLimit
+	Actions RateLimit
+	// bots that can be added to a room, 0 doesn't limit them
+	MaxBotsPerRoom int
+	// rejections before banning a client, 0 disables bans
+	BanAfter    int
+	BanWindow   time.Duration
+	BanDuration time.Duration
+}
+
+// routes limited separately, also used as metric labels
+const (
+	LimitNewRoom = "new"
+	LimitJoin    = "join"
+	LimitAddBot  = "add_bot"
+	LimitActions = "actions"
+)
+
+// time a client has to be idle to forget its limits
+const LIMITER_IDLE_TIMEOUT = 10 * time.Minute
+
+type clientLimits struct {
+	// by route and key within the route
+	buckets map[string]*tokenBucket
+	// rejections since firstStrike, reset after BanWindow
+	strikes     int
+	firstStrike time.Time
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+// abuseLimiter keeps the rate limits and bans of every client, safe for concurrent use.
+type abuseLimiter struct {
+	cfg       AbuseConfig
+	mu        sync.Mutex
+	clients   map[string]*clientLimits
+	lastPrune time.Time
+}
+
+func newAbuseLimiter(cfg AbuseConfig) *abuseLimiter {
+	return &abuseLimiter{cfg: cfg, clients: make(map[string]*clientLimits)}
+}
+
+func (l *abuseLimiter) limit(route string) RateLimit {
+	switch route {
+	case LimitNewRoom:
+		return l.cfg.NewRoom
+	case LimitJoin:
+		return l.cfg.Join
+	case LimitAddBot:
+		return l.cfg.AddBot
+	case LimitActions:
+		return l.cfg.Actions
+	}
+	return RateLimit{}
+}
+
+// client returns the limits of a client, forgetting the clients idle for LIMITER_IDLE_TIMEOUT.
+// Must be called with the lock held.
+func (l *abuseLimiter) client(client string, now time.Time) *clientLimits {
+	if now.Sub(l.lastPrune) > LIMITER_IDLE_TIMEOUT {
+		for key, c := range l.clients {
+			if now.Sub(c.lastSeen) > LIMITER_IDLE_TIMEOUT && now.After(c.bannedUntil) {
+				delete(l.clients, key)
+			}
+		}
+		l.lastPrune = now
+	}
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimits{buckets: make(map[string]*tokenBucket)}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+	return c
+}
+
+// allow checks if a client is banned and takes a token from the bucket of the route, if the route
+// is limited. Clients get a bucket per key, so routes can be limited per connection instead of per
+// client, but rejections in any of them count for the ban of the client.
+// Returns ErrClientBanned or ErrRateLimited if the request should be rejected.
+func (l *abuseLimiter) allow(client string, route string, key string, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.client(client, now)
+	if now.Before(c.bannedUntil) {
+		return ErrClientBanned
+	}
+	limit := l.limit(route)
+	if !limit.enabled() {
+		return nil
+	}
+	bucket, ok := c.buckets[route+"/"+key]
+	if !ok {
+		bucket = newTokenBucket(limit.Burst, limit.Interval)
+		c.buckets[route+"/"+key] = bucket
+	}
+	if bucket.Allow(now) {
+		return nil
+	}
+	metrics.IncRateLimited(route)
+	if l.strike(c, now) {
+		metrics.IncClientsBanned()
+		return ErrClientBanned
+	}
+	return ErrRateLimited
+}
+
+// strike counts a rejection of the client and bans it if it's a repeat offender.
+// Returns true if the client got banned. Must be called with the lock held.
+func (l *abuseLimiter) strike(c *clientLimits, now time.Time) bool {
+	if l.cfg.BanAfter <= 0 {
+		return false
+	}
+	if now.Sub(c.firstStrike) > l.cfg.BanWindow {
+		c.strikes = 0
+		c.firstStrike = now
+	}
+	c.strikes++
+	if c.strikes < l.cfg.BanAfter {
+		return false
+	}
+	c.strikes = 0
+	c.bannedUntil = now.Add(l.cfg.BanDuration)
+	return true
+}
+
+// bannedFor returns how long the client is still banned for, 0 if it's not banned.
+func (l *abuseLimiter) bannedFor(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clients[client]
+	if !ok || now.After(c.bannedUntil) {
+		return 0
+	}
+	return c.bannedUntil.Sub(now)
+}
+
+// RateLimited wraps an HTTP handler rejecting requests over the route limit of the client IP,
+// and every request of banned clients, with 429 Too Many Requests.
+func (g *Service) RateLimited(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientIP(r)
+		now := time.Now()
+		if err := g.limiter.allow(client, route, "", now); err != nil {
+			if wait := g.limiter.bannedFor(client, now); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		next(w, r)
+	}
+}
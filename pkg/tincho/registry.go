@@ -0,0 +1,138 @@
+package tincho
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// number of independently locked parts of the room registry
+const REGISTRY_SHARDS = 16
+
+// roomRegistry keeps the rooms of a Service by ID, safe for concurrent use.
+// Rooms are split in shards so lookups from many handlers don't contend on a single lock.
+type roomRegistry struct {
+	shards [REGISTRY_SHARDS]registryShard
+
+	// guards the limits, so checking and reserving a slot is a single step
+	limitsMu sync.Mutex
+	total    int
+	byClient map[string]int
+	// client that created each room, only for rooms created through a client
+	clients map[string]string
+}
+
+type registryShard struct {
+	mu    sync.RWMutex
+	rooms map[string]*Room
+}
+
+func newRoomRegistry() *roomRegistry {
+	reg := &roomRegistry{
+		byClient: make(map[string]int),
+		clients:  make(map[string]string),
+	}
+	for i := range reg.shards {
+		reg.shards[i].rooms = make(map[string]*Room)
+	}
+	return reg
+}
+
+func (reg *roomRegistry) shard(roomID string) *registryShard {
+	h := fnv.New32a()
+	h.Write([]byte(roomID))
+	return &reg.shards[h.Sum32()%REGISTRY_SHARDS]
+}
+
+func (reg *roomRegistry) get(roomID string) (*Room, bool) {
+	shard := reg.shard(roomID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	room, ok := shard.rooms[roomID]
+	return room, ok
+}
+
+// reserve takes a slot for a new room, checking the global limit and the limit of the client.
+// Rooms without a client only count for the global limit. A client limit of 0 disables it.
+func (reg *roomRegistry) reserve(client string, maxRooms int, maxPerClient int) error {
+	reg.limitsMu.Lock()
+	defer reg.limitsMu.Unlock()
+	if reg.total >= maxRooms {
+		return ErrRoomsLimitReached
+	}
+	if client != "" && maxPerClient > 0 && reg.byClient[client] >= maxPerClient {
+		return ErrClientRoomsLimitReached
+	}
+	reg.total++
+	if client != "" {
+		reg.byClient[client]++
+	}
+	return nil
+}
+
+// release frees a slot taken with reserve for a room that wasn't added.
+func (reg *roomRegistry) release(client string) {
+	reg.limitsMu.Lock()
+	defer reg.limitsMu.Unlock()
+	reg.total--
+	if client != "" {
+		reg.byClient[client]--
+		if reg.byClient[client] <= 0 {
+			delete(reg.byClient, client)
+		}
+	}
+}
+
+// add stores a room in a slot taken with reserve. Returns false if the ID is already used.
+func (reg *roomRegistry) add(room *Room, client string) bool {
+	shard := reg.shard(room.ID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if _, exists := shard.rooms[room.ID]; exists {
+		return false
+	}
+	shard.rooms[room.ID] = room
+	if client != "" {
+		reg.limitsMu.Lock()
+		reg.clients[room.ID] = client
+		reg.limitsMu.Unlock()
+	}
+	return true
+}
+
+// remove deletes a room and frees its slot.
+func (reg *roomRegistry) remove(roomID string) {
+	shard := reg.shard(roomID)
+	shard.mu.Lock()
+	_, exists := shard.rooms[roomID]
+	delete(shard.rooms, roomID)
+	shard.mu.Unlock()
+	if !exists {
+		return
+	}
+	reg.limitsMu.Lock()
+	client := reg.clients[roomID]
+	delete(reg.clients, roomID)
+	reg.limitsMu.Unlock()
+	reg.release(client)
+}
+
+// all returns every room in the registry, in no particular order.
+func (reg *roomRegistry) all() []*Room {
+	rooms := make([]*Room, 0)
+	for i := range reg.shards {
+		shard := &reg.shards[i]
+		shard.mu.RLock()
+		for _, room := range shard.rooms {
+			rooms = append(rooms, room)
+		}
+		shard.mu.RUnlock()
+	}
+	return rooms
+}
+
+// count returns the number of rooms holding a slot, including the ones being created.
+func (reg *roomRegistry) count() int {
+	reg.limitsMu.Lock()
+	defer reg.limitsMu.Unlock()
+	return reg.total
+}
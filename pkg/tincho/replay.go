@@ -0,0 +1,114 @@
+package tincho
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// max speed multiplier a replay can be played at
+const MAX_REPLAY_SPEED = 16
+
+// longest pause between two replayed updates, so slow turns don't stall the replay
+const MAX_REPLAY_GAP = 5 * time.Second
+
+var ErrSeatNotInGame = errors.New("seat not in game")
+var ErrInvalidReplaySpeed = fmt.Errorf("replay speed should be greater than 0 and up to %d", MAX_REPLAY_SPEED)
+
+// ReplayUpdates returns the updates a seat recieved during the game, in the order they were sent.
+// An empty seat gets the updates a spectator would have seen.
+// In omniscient mode the seat is ignored and the copies of updates that reveal hidden cards are used
+// instead of the public ones, so the replay shows every card.
+func ReplayUpdates(record GameRecord, seat game.PlayerID, omniscient bool) ([]ArchivedEvent, error) {
+	if !omniscient && seat != "" && !slices.ContainsFunc(record.Seats, func(s ArchivedSeat) bool { return s.Player == seat }) {
+		return nil, fmt.Errorf("%w: %s", ErrSeatNotInGame, seat)
+	}
+	updates := make([]ArchivedEvent, 0, len(record.Events))
+	for ix, event := range record.Events {
+		if event.Kind != EventKindUpdate {
+			continue
+		}
+		var include bool
+		if omniscient {
+			include = (event.Player == "" && event.Except == "") || revealsHiddenUpdate(record.Events, ix)
+		} else {
+			include = event.Player == seat || (event.Player == "" && event.Except != seat)
+		}
+		if include {
+			updates = append(updates, event)
+		}
+	}
+	return updates, nil
+}
+
+// revealsHiddenUpdate checks if the targeted update at ix is the private copy of an update broadcasted
+// to everyone else without its hidden information. Both copies are always sent one after the other.
+func revealsHiddenUpdate(events []ArchivedEvent, ix int) bool {
+	event := events[ix]
+	if event.Player == "" {
+		return false
+	}
+	for _, other := range []int{ix - 1, ix + 1} {
+		if other < 0 || other >= len(events) {
+			continue
+		}
+		if events[other].Kind == EventKindUpdate && events[other].Type == event.Type && events[other].Except == event.Player {
+			return true
+		}
+	}
+	return false
+}
+
+// replayDelay is the time to wait between two updates, keeping the pace they were sent at.
+func replayDelay(prev time.Time, next time.Time, speed float64) time.Duration {
+	gap := next.Sub(prev)
+	if gap < 0 {
+		gap = 0
+	} else if gap > MAX_REPLAY_GAP {
+		gap = MAX_REPLAY_GAP
+	}
+	return time.Duration(float64(gap) / speed)
+}
+
+// streamReplay writes the updates to the websocket as they were originally sent, waiting between them
+// to keep the pace of the game. Stops early if the context is cancelled.
+func streamReplay(ctx context.Context, ws *websocket.Conn, updates []ArchivedEvent, speed float64) error {
+	for ix, update := range updates {
+		if ix > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(replayDelay(updates[ix-1].Time, update.Time, speed)):
+			}
+		}
+		if err := ws.WriteMessage(websocket.TextMessage, update.Message); err != nil {
+			return fmt.Errorf("error sending update: %w", err)
+		}
+	}
+	return nil
+}
+
+// Recording returns a copy of the record of the game being played, if the room is recording it.
+func (r *Room) Recording() (GameRecord, bool) {
+	var record GameRecord
+	var recording bool
+	err := r.query(func() {
+		if r.recording == nil {
+			return
+		}
+		record = *r.recording
+		record.Events = slices.Clone(record.Events)
+		record.Deals = slices.Clone(record.Deals)
+		record.Seats = make([]ArchivedSeat, 0, len(r.state.GetPlayers()))
+		for _, p := range r.state.GetPlayers() {
+			record.Seats = append(record.Seats, ArchivedSeat{Player: p.ID})
+		}
+		recording = true
+	})
+	return record, err == nil && recording
+}
@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/manuelpepe/tincho/pkg/game"
 	"github.com/manuelpepe/tincho/pkg/metrics"
@@ -14,9 +17,58 @@ import (
 type AddConnectionRequest struct {
 	Conn *Connection
 	Res  chan error
+	// set when a player reconnects knowing the last update they recieved
+	Resume  bool
+	LastSeq int
+}
+
+// socketClosed is sent to the room goroutine when the websocket of a connection stops.
+type socketClosed struct {
+	Conn     *Connection
+	SocketID int
+}
+
+// RoomSettings holds the options a room is created with.
+type RoomSettings struct {
+	// difficulty of the bot that plays for disconnected players, empty disables takeovers
+	TakeoverDifficulty string
+	// time a player can be disconnected during a game before a bot takes their seat
+	TakeoverGracePeriod time.Duration
+
+	// start the game without the leader once enough players are ready
+	AutoStart bool
+	// ready players needed to auto start, 0 means everyone
+	MinReady int
+	// time between enough players being ready and the game starting
+	StartCountdown time.Duration
+
+	// best-of-N games played in the room, 0 means games are not part of a series
+	SeriesLength int
+	// close the room when a game ends instead of waiting for a rematch
+	CloseOnEnd bool
+
+	// options used to build the deck, informative only as the deck is built by the caller
+	DeckOptions DeckOptions
+	// zero value means the default rules
+	Rules game.Rules
+	// time a player has to finish their turn, shown to the players, 0 disables the timer
+	TurnTimer time.Duration
+	// don't remind reconnecting players of the cards they saw
+	Hardcore bool
+}
+
+func DefaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		TakeoverDifficulty:  "medium",
+		TakeoverGracePeriod: 60 * time.Second,
+		StartCountdown:      5 * time.Second,
+		Rules:               game.DefaultRules(),
+	}
 }
 
 // Room represents an ongoing game and contains all necessary state to represent it.
+// The state is owned by the room goroutine (see Start), everything that changes it is sent through
+// channels. Other goroutines read the view published after every change, or use query.
 type Room struct {
 	Context   context.Context
 	closeRoom context.CancelFunc
@@ -25,6 +77,7 @@ type Room struct {
 	ID          string
 	state       *game.Tincho
 	connections map[game.PlayerID]*Connection
+	spectators  []*Connection
 
 	// actions recieved from all players
 	actionsChan chan TypedAction
@@ -32,79 +85,178 @@ type Room struct {
 	// channel used to update goroutine state
 	connectionsChan chan AddConnectionRequest
 
+	// channel used to notify the room of closed websockets
+	disconnectsChan chan socketClosed
+
+	// functions scheduled to run in the room goroutine
+	eventsChan chan func()
+
+	// functions that read the state from the room goroutine without changing it
+	queriesChan chan func()
+
+	// immutable snapshot of the room for other goroutines
+	view atomic.Pointer[roomView]
+
+	leader game.PlayerID
+	banned map[game.PlayerID]bool
+	// players without an open websocket and the time they disconnected
+	disconnected map[game.PlayerID]time.Time
+
+	// cards each player has seen during the current round
+	memory cardMemory
+
+	// players that are ready to start the game, bots are always ready
+	ready map[game.PlayerID]bool
+	// incremented every time a countdown starts or is cancelled to ignore stale countdowns
+	countdownID    int
+	countdownTimer *time.Timer
+	countdownEnds  time.Time
+
+	series       series
+	rematchVotes map[game.PlayerID]bool
+
+	// last cut of the current game, kept for players rejoining on the cut screen
+	lastCut *UpdateCutData
+	// rounds of the last finished game, nil if no game ended since the last start
+	lastRounds []game.Round
+
+	// used to start bots on the seats of disconnected players, nil disables takeovers
+	takeover TakeoverFunc
+	// seats currently played by a bot
+	takenOver map[game.PlayerID]bool
+
+	settings RoomSettings
+
+	// persists the room after every change, nil disables persistence
+	store Store
+	// writes to the store outside of the room goroutine, set while the room is running
+	saver *roomSaver
+
+	// keeps the record of finished games, nil disables the archive
+	archive Archive
+	// record of the game being played, nil if there is no archive
+	recording *GameRecord
+
+	// notified of everything that happens in the room, shared with the service
+	observers *observers
+
+	// last messages sent to the chat, up to CHAT_HISTORY_SIZE
+	chatHistory []UpdateChatData
+
 	maxPlayers int
+	// max players the leader can set, configured by the server, 0 means MAX_PLAYERS
+	playersLimit int
+	// bots that can join the room, 0 doesn't limit them
+	maxBots int
+	// hashed with hashPassword, empty if the room has no password
+	passwordHash string
+	createdAt    time.Time
+	// last time a player joined or sent an action
+	lastActivity    time.Time
+	allowSpectators bool
+
+	// time the room is open for and time without activity before it's closed, 0 disables them
+	timeout     time.Duration
+	idleTimeout time.Duration
 
 	started bool
 	closed  bool
+	// why the room was closed, empty if it was stopped to be restored
+	closeReason CloseReason
+	// closed once the room goroutine stops
+	done chan struct{}
+	// websockets currently writing updates of the room
+	sockets sync.WaitGroup
+}
 
-	sync.RWMutex
+func NewRoomWithDeck(logger *slog.Logger, ctx context.Context, ctxCancel context.CancelFunc, roomID string, deck game.Deck, maxPlayers int) *Room {
+	return NewRoomWithSettings(logger, ctx, ctxCancel, roomID, deck, maxPlayers, DefaultRoomSettings())
 }
 
-func NewRoomWithDeck(logger *slog.Logger, ctx context.Context, ctxCancel context.CancelFunc, roomID string, deck game.Deck, maxPlayers int) Room {
-	return Room{
+func NewRoomWithSettings(logger *slog.Logger, ctx context.Context, ctxCancel context.CancelFunc, roomID string, deck game.Deck, maxPlayers int, settings RoomSettings) *Room {
+	if settings.Rules == (game.Rules{}) {
+		settings.Rules = game.DefaultRules()
+	}
+	state := game.NewTinchoWithDeck(deck)
+	if err := state.SetRules(settings.Rules); err != nil {
+		logger.Warn("invalid rules, using defaults", "err", err)
+		settings.Rules = state.Rules()
+	}
+	room := &Room{
 		Context:         ctx,
 		closeRoom:       ctxCancel,
 		logger:          logger,
 		ID:              roomID,
 		actionsChan:     make(chan TypedAction),
 		connectionsChan: make(chan AddConnectionRequest),
+		disconnectsChan: make(chan socketClosed),
+		eventsChan:      make(chan func()),
+		queriesChan:     make(chan func()),
+		banned:          make(map[game.PlayerID]bool),
+		disconnected:    make(map[game.PlayerID]time.Time),
+		memory:          make(cardMemory),
+		ready:           make(map[game.PlayerID]bool),
+		series:          newSeries(settings.SeriesLength),
+		rematchVotes:    make(map[game.PlayerID]bool),
+		takenOver:       make(map[game.PlayerID]bool),
+		settings:        settings,
 		maxPlayers:      maxPlayers,
-		state:           game.NewTinchoWithDeck(deck),
+		createdAt:       time.Now(),
+		lastActivity:    time.Now(),
+		allowSpectators: true,
+		state:           state,
 		connections:     make(map[game.PlayerID]*Connection),
+		spectators:      make([]*Connection, 0),
+		chatHistory:     make([]UpdateChatData, 0),
 		closed:          false,
+		done:            make(chan struct{}),
 	}
+	room.publish()
+	return room
 }
 
+// Winner returns a copy of the winner of the last game, as of the last published view.
 func (r *Room) Winner() (*game.Player, error) {
-	r.RWMutex.RLock()
-	defer r.RWMutex.RUnlock()
-	return r.state.Winner()
+	view := r.published()
+	return view.winner, view.winnerErr
 }
 
 func (r *Room) TotalTurns() int {
-	r.RWMutex.RLock()
-	defer r.RWMutex.RUnlock()
-	return r.state.TotalTurns()
+	return r.published().totalTurns
 }
 
 func (r *Room) TotalRounds() int {
-	r.RWMutex.RLock()
-	defer r.RWMutex.RUnlock()
-	return r.state.TotalRounds()
+	return r.published().totalRounds
 }
 
 func (r *Room) CurrentPlayers() int {
-	r.RWMutex.RLock()
-	defer r.RWMutex.RUnlock()
-	return len(r.state.GetPlayers())
+	return r.published().info.Players
 }
 
-func (r *Room) HasClosed() bool {
-	r.RWMutex.RLock()
-	defer r.RWMutex.RUnlock()
-	return r.closed
+func (r *Room) CurrentSpectators() int {
+	return r.published().info.Spectators
 }
 
-func (r *Room) close() {
-	if !r.closed {
-		r.closeRoom()
-		r.closed = true
-	}
+func (r *Room) HasClosed() bool {
+	return r.published().closed
 }
 
 func (r *Room) getMarshalledPlayers() []MarshalledPlayer {
 	ps := r.state.GetPlayers()
 	marshalled := make([]MarshalledPlayer, 0, len(ps))
 	for _, p := range ps {
-		marshalled = append(marshalled, NewMarshalledPlayer(p))
+		mp := NewMarshalledPlayer(p)
+		if !r.state.Playing() {
+			mp.Ready = r.isReady(p.ID)
+		}
+		marshalled = append(marshalled, mp)
 	}
 	return marshalled
 }
 
 func (r *Room) GetConnection(id game.PlayerID) (*Connection, bool) {
-	r.RWMutex.RLock()
-	defer r.RWMutex.RUnlock()
-	return r.getConnection(id)
+	conn, ok := r.published().connections[id]
+	return conn, ok
 }
 
 func (r *Room) getConnection(id game.PlayerID) (*Connection, bool) {
@@ -120,36 +272,125 @@ func (r *Room) getConnection(id game.PlayerID) (*Connection, bool) {
 }
 
 func (r *Room) AddConnection(c *Connection) error {
-	req := AddConnectionRequest{
+	return r.requestConnection(AddConnectionRequest{
 		Conn: c,
 		Res:  make(chan error),
+	})
+}
+
+// ResumeConnection reconnects a player that already recieved the updates up to lastSeq.
+// The player gets the updates they missed, or the full state if they are not kept anymore.
+func (r *Room) ResumeConnection(c *Connection, lastSeq int) error {
+	return r.requestConnection(AddConnectionRequest{
+		Conn:    c,
+		Res:     make(chan error),
+		Resume:  true,
+		LastSeq: lastSeq,
+	})
+}
+
+// requestConnection sends the request to the room goroutine and waits for the answer.
+// Once the request is recieved the room always answers.
+func (r *Room) requestConnection(req AddConnectionRequest) error {
+	select {
+	case r.connectionsChan <- req:
+	case <-r.Context.Done():
+		return ErrRoomClosed
 	}
-	r.connectionsChan <- req
 	return <-req.Res
 }
 
 func (r *Room) addPlayer(conn *Connection) error {
-	r.RWMutex.Lock()
-	defer r.RWMutex.Unlock()
-
+	if r.banned[conn.ID] {
+		return ErrPlayerBanned
+	}
 	if len(r.state.GetPlayers()) >= r.maxPlayers {
 		return fmt.Errorf("room is full")
 	}
+	if conn.Bot && r.maxBots > 0 && r.countBots() >= r.maxBots {
+		return ErrTooManyBots
+	}
 	if err := r.state.AddPlayer(conn.Player); err != nil {
 		return fmt.Errorf("tsm.AddPlayer: %w", err)
 	}
 
 	r.connections[conn.ID] = conn
 	go r.watchPlayer(conn)
-	r.BroadcastUpdate(Update[UpdatePlayersChangedData]{
-		Type: UpdateTypePlayersChanged,
-		Data: UpdatePlayersChangedData{
-			Players: r.getMarshalledPlayers(),
-		},
-	})
+	// humans take leadership from bots, the change is sent with the players list
+	leader, ok := r.connections[r.leader]
+	if r.leader == "" || (!conn.Bot && ok && leader.Bot) {
+		r.leader = conn.ID
+	}
+	r.broadcastPlayersChanged()
+	r.updateCountdown()
+	return nil
+}
+
+func (r *Room) rejoinPlayer(req AddConnectionRequest) {
+	conn := req.Conn
+	delete(r.disconnected, conn.ID)
+	// queued updates are still in the history if the player needs them
+	conn.ClearPendingUpdates()
+	if missing, ok := conn.updatesSince(req.LastSeq); req.Resume && ok {
+		conn.resendUpdates(missing)
+		r.endTakeover(conn.ID)
+		return
+	}
+	r.endTakeover(conn.ID)
+	r.sendRejoinState(conn)
+}
+
+var ErrSpectatingDisabled = errors.New("spectating is disabled for this room")
+
+func (r *Room) addSpectator(conn *Connection) error {
+	if !r.allowSpectators {
+		return ErrSpectatingDisabled
+	}
+	r.spectators = append(r.spectators, conn)
+	r.sendSpectatorState(conn)
+	r.broadcastSpectatorsChanged()
 	return nil
 }
 
+func (r *Room) removeSpectator(conn *Connection) {
+	ix := slices.Index(r.spectators, conn)
+	if ix == -1 {
+		return
+	}
+	r.spectators = slices.Delete(r.spectators, ix, ix+1)
+	r.broadcastSpectatorsChanged()
+}
+
+// notifySocketClosed lets the room know that the given socket for a connection has stopped.
+func (r *Room) notifySocketClosed(conn *Connection, socketID int) {
+	select {
+	case r.disconnectsChan <- socketClosed{Conn: conn, SocketID: socketID}:
+	case <-r.Context.Done():
+	}
+}
+
+// schedule runs fn in the room goroutine after the given delay, unless the room closes first.
+func (r *Room) schedule(delay time.Duration, fn func()) *time.Timer {
+	return time.AfterFunc(delay, func() {
+		select {
+		case r.eventsChan <- fn:
+		case <-r.Context.Done():
+		}
+	})
+}
+
+var ErrTooManyBots = errors.New("room can't have more bots")
+
+func (r *Room) countBots() int {
+	bots := 0
+	for _, conn := range r.connections {
+		if conn.Bot {
+			bots++
+		}
+	}
+	return bots
+}
+
 func (r *Room) isPlayerInRoom(playerID game.PlayerID) bool {
 	_, exists := r.state.GetPlayer(playerID)
 	return exists
@@ -158,14 +399,29 @@ func (r *Room) isPlayerInRoom(playerID game.PlayerID) bool {
 func (r *Room) Start() {
 	r.logger.Info("Starting room")
 	r.started = true
+	if r.store != nil {
+		r.saver = newRoomSaver(r.store, r.logger)
+		go r.saver.run()
+	}
+	r.scheduleTimeout()
+	r.checkIdle()
 	defer metrics.IncGamesEnded()
+	defer close(r.done)
 	for {
 		select {
 		case req := <-r.connectionsChan:
-			if r.isPlayerInRoom(req.Conn.ID) {
-				req.Conn.ClearPendingUpdates()
-				r.sendRejoinState(req.Conn)
+			if req.Conn.Spectator {
+				if err := r.addSpectator(req.Conn); err != nil {
+					r.logger.Warn("Error adding spectator to room", "err", err, "spectator", req.Conn.ID)
+					req.Res <- err
+				} else {
+					r.logger.Info(fmt.Sprintf("Spectator joined #%s: %s", r.ID, req.Conn.ID))
+					req.Res <- nil
+				}
+			} else if r.isPlayerInRoom(req.Conn.ID) {
+				r.rejoinPlayer(req)
 				r.logger.Info(fmt.Sprintf("Player rejoined #%s: %s", r.ID, req.Conn.ID))
+				r.notifyPlayerJoined(req.Conn, true)
 				req.Res <- nil
 			} else {
 				if err := r.addPlayer(req.Conn); err != nil {
@@ -173,19 +429,55 @@ func (r *Room) Start() {
 					req.Res <- err
 				} else {
 					r.logger.Info(fmt.Sprintf("Player joined #%s: %s", r.ID, req.Conn.ID))
+					r.notifyPlayerJoined(req.Conn, false)
 					req.Res <- nil
 				}
 			}
+			r.lastActivity = time.Now()
+			r.save()
+		case closed := <-r.disconnectsChan:
+			if !closed.Conn.isAttached(closed.SocketID) {
+				continue
+			}
+			if closed.Conn.Spectator {
+				r.removeSpectator(closed.Conn)
+				r.logger.Info(fmt.Sprintf("Spectator left #%s: %s", r.ID, closed.Conn.ID))
+			} else {
+				r.playerDisconnected(closed.Conn)
+				r.logger.Info(fmt.Sprintf("Player disconnected #%s: %s", r.ID, closed.Conn.ID))
+			}
+			r.save()
+		case fn := <-r.eventsChan:
+			fn()
+			r.save()
+		case fn := <-r.queriesChan:
+			fn()
+			continue
 		case action := <-r.actionsChan:
 			r.logger.Info(fmt.Sprintf("Recieved action from %s", action.GetPlayerID()), "action", action)
+			r.lastActivity = time.Now()
 			r.doAction(action)
+			r.save()
 		case <-r.Context.Done():
 			r.logger.Info("Stopping room")
-			r.RWMutex.Lock()
-			defer r.RWMutex.Unlock()
-			r.close()
+			// rooms stopped by the service shutting down are kept to be restored
+			finished := r.closed
+			r.closed = true
+			r.publish()
+			r.observers.notify(func(o RoomObserver) {
+				o.RoomClosed(RoomClosedEvent{Time: time.Now(), RoomID: r.ID, Stopped: !finished, Reason: r.closeReason})
+			})
+			if r.saver != nil {
+				r.saver.stop()
+			}
+			if r.store != nil && finished {
+				if err := r.store.Delete(r.ID); err != nil {
+					r.logger.Error("error deleting saved room", "err", err)
+				}
+			}
 			return
 		}
+		r.publish()
 	}
 }
 
@@ -193,48 +485,160 @@ var ErrNotYourTurn = fmt.Errorf("not your turn")
 var ErrActionOnClosedRoom = errors.New("action on closed room")
 
 func (r *Room) doAction(action TypedAction) {
-	if r.HasClosed() {
+	if r.closed {
 		r.logger.Error(ErrActionOnClosedRoom.Error())
 		r.TargetedError(action.GetPlayerID(), ErrActionOnClosedRoom)
+		r.notifyAction(action, ErrActionOnClosedRoom)
+		return
+	}
+
+	if !r.isPlayerInRoom(action.GetPlayerID()) {
+		r.logger.Warn("action from player not in room", "player_id", action.GetPlayerID(), "action", action)
 		return
 	}
+	r.recordAction(action)
 
-	r.RWMutex.Lock()
-	defer r.RWMutex.Unlock()
+	err := r.applyAction(action)
+	if err != nil {
+		r.TargetedError(action.GetPlayerID(), err)
+	}
+	r.notifyAction(action, err)
+}
+
+var ErrUnknownAction = errors.New("unknown action")
+var errInvalidActionData = errors.New("invalid action data")
 
+// applyAction performs an action, returning the reason it was rejected.
+func (r *Room) applyAction(action TypedAction) error {
 	switch action.GetType() {
 	case ActionStart:
 		act, ok := action.(*Action[ActionWithoutData])
 		if !ok {
 			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
-			return
+			return errInvalidActionData
 		}
 		if err := r.doStartGame(*act); err != nil {
 			r.logger.Warn("error starting game", "err", err, "player_id", act.GetPlayerID())
-			r.TargetedError(act.GetPlayerID(), err)
-			return
+			return err
 		}
-		return
+		return nil
 	case ActionFirstPeek:
 		act, ok := action.(*Action[ActionWithoutData])
 		if !ok {
 			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
-			return
+			return errInvalidActionData
 		}
 		if err := r.doPeekTwo(*act); err != nil {
 			r.logger.Warn("error on first peek", "err", err, "player_id", act.GetPlayerID())
-			r.TargetedError(act.GetPlayerID(), err)
-			return
+			return err
 		}
-		return
+		return nil
+	case ActionToggleSpectators:
+		act, ok := action.(*Action[ActionToggleSpectatorsData])
+		if !ok {
+			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
+			return errInvalidActionData
+		}
+		if err := r.doToggleSpectators(*act); err != nil {
+			r.logger.Warn("error toggling spectators", "err", err, "player_id", act.GetPlayerID())
+			return err
+		}
+		return nil
+	case ActionChat:
+		act, ok := action.(*Action[ActionChatData])
+		if !ok {
+			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
+			return errInvalidActionData
+		}
+		if err := r.doChat(*act); err != nil {
+			r.logger.Warn("error on chat", "err", err, "player_id", act.GetPlayerID())
+			return err
+		}
+		return nil
+	case ActionKick:
+		act, ok := action.(*Action[ActionKickData])
+		if !ok {
+			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
+			return errInvalidActionData
+		}
+		if err := r.doKick(*act); err != nil {
+			r.logger.Warn("error kicking player", "err", err, "player_id", act.GetPlayerID())
+			return err
+		}
+		return nil
+	case ActionTransferLeader:
+		act, ok := action.(*Action[ActionTransferLeaderData])
+		if !ok {
+			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
+			return errInvalidActionData
+		}
+		if err := r.doTransferLeader(*act); err != nil {
+			r.logger.Warn("error transfering leadership", "err", err, "player_id", act.GetPlayerID())
+			return err
+		}
+		return nil
+	case ActionReady:
+		act, ok := action.(*Action[ActionReadyData])
+		if !ok {
+			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
+			return errInvalidActionData
+		}
+		if err := r.doReady(*act); err != nil {
+			r.logger.Warn("error setting ready", "err", err, "player_id", act.GetPlayerID())
+			return err
+		}
+		return nil
+	case ActionRematch:
+		act, ok := action.(*Action[ActionRematchData])
+		if !ok {
+			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
+			return errInvalidActionData
+		}
+		if err := r.doRematch(*act); err != nil {
+			r.logger.Warn("error voting rematch", "err", err, "player_id", act.GetPlayerID())
+			return err
+		}
+		return nil
+	case ActionSetSeries:
+		act, ok := action.(*Action[ActionSetSeriesData])
+		if !ok {
+			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
+			return errInvalidActionData
+		}
+		if err := r.doSetSeries(*act); err != nil {
+			r.logger.Warn("error setting series", "err", err, "player_id", act.GetPlayerID())
+			return err
+		}
+		return nil
+	case ActionUpdateSettings:
+		act, ok := action.(*Action[ActionUpdateSettingsData])
+		if !ok {
+			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
+			return errInvalidActionData
+		}
+		if err := r.doUpdateSettings(*act); err != nil {
+			r.logger.Warn("error updating settings", "err", err, "player_id", act.GetPlayerID())
+			return err
+		}
+		return nil
+	case ActionLeave:
+		act, ok := action.(*Action[ActionWithoutData])
+		if !ok {
+			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
+			return errInvalidActionData
+		}
+		if err := r.doLeave(*act); err != nil {
+			r.logger.Warn("error leaving room", "err", err, "player_id", act.GetPlayerID())
+			return err
+		}
+		return nil
 	}
 	if !r.state.Playing() || action.GetPlayerID() != r.state.PlayerToPlay().ID {
 		r.logger.Warn(
 			fmt.Sprintf("Player %s tried to perform action out of turn", action.GetPlayerID()),
 			"player_id", action.GetPlayerID(),
 			"action", action)
-		r.TargetedError(action.GetPlayerID(), ErrNotYourTurn)
-		return
+		return ErrNotYourTurn
 	}
 
 	switch action.GetType() {
@@ -242,74 +646,70 @@ func (r *Room) doAction(action TypedAction) {
 		act, ok := action.(*Action[ActionDrawData])
 		if !ok {
 			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
-			return
+			return errInvalidActionData
 		}
 		if err := r.doDraw(*act); err != nil {
 			r.logger.Warn("error on draw", "err", err, "player_id", act.GetPlayerID())
-			r.TargetedError(act.GetPlayerID(), err)
-			return
+			return err
 		}
 	case ActionDiscard:
 		act, ok := action.(*Action[ActionDiscardData])
 		if !ok {
 			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
-			return
+			return errInvalidActionData
 		}
 		if err := r.doDiscard(*act); err != nil {
 			r.logger.Warn("error on discard", "err", err, "player_id", act.GetPlayerID())
-			r.TargetedError(act.GetPlayerID(), err)
-			return
+			return err
 		}
 	case ActionCut:
 		act, ok := action.(*Action[ActionCutData])
 		if !ok {
 			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
-			return
+			return errInvalidActionData
 		}
 		if err := r.doCut(*act); err != nil {
 			r.logger.Warn("error on cut", "err", err, "player_id", act.GetPlayerID())
-			r.TargetedError(act.GetPlayerID(), err)
-			return
+			return err
 		}
 	case ActionPeekOwnCard:
 		act, ok := action.(*Action[ActionPeekOwnCardData])
 		if !ok {
 			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
-			return
+			return errInvalidActionData
 		}
 		if err := r.doEffectPeekOwnCard(*act); err != nil {
 			r.logger.Warn("error on peek own", "err", err, "player_id", act.GetPlayerID())
-			r.TargetedError(act.GetPlayerID(), err)
-			return
+			return err
 		}
-		return
+		return nil
 	case ActionPeekCartaAjena:
 		act, ok := action.(*Action[ActionPeekCartaAjenaData])
 		if !ok {
 			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
-			return
+			return errInvalidActionData
 		}
 		if err := r.doEffectPeekCartaAjena(*act); err != nil {
 			r.logger.Warn("error on peek carta ajena", "err", err, "player_id", act.GetPlayerID())
-			r.TargetedError(act.GetPlayerID(), err)
-			return
+			return err
 		}
-		return
+		return nil
 	case ActionSwapCards:
 		act, ok := action.(*Action[ActionSwapCardsData])
 		if !ok {
 			r.logger.Error("error casting action", "action", act, "player_id", act.GetPlayerID())
-			return
+			return errInvalidActionData
 		}
 		if err := r.doEffectSwapCards(*act); err != nil {
 			r.logger.Warn("error on swap cards", "err", err, "player_id", act.GetPlayerID())
-			r.TargetedError(act.GetPlayerID(), err)
-			return
+			return err
 		}
-		return
+		return nil
 	default:
 		r.logger.Warn("unknown action", "player_id", action.GetPlayerID(), "action", action)
+		return fmt.Errorf("%w: %s", ErrUnknownAction, action.GetType())
 	}
+	return nil
 }
 
 // watchPlayer functions as a goroutine that watches for new actions from a given player.
@@ -2,10 +2,19 @@ package tincho
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,16 +25,51 @@ import (
 )
 
 func NewServer() (*Service, *httptest.Server, context.CancelFunc) {
+	return NewServerWithConfig(ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute})
+}
+
+func NewServerWithConfig(cfg ServiceConfig) (*Service, *httptest.Server, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
-	game := NewService(ctx, ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute})
+	game := NewService(ctx, cfg)
 	r := mux.NewRouter()
 	handlers := NewHandlers(slog.Default(), &game)
-	r.HandleFunc("/join", handlers.JoinRoom)
+	r.HandleFunc("/new", handlers.NewRoom)
+	r.HandleFunc("/join", game.RateLimited(LimitJoin, handlers.JoinRoom))
+	r.HandleFunc("/session", handlers.Session)
+	r.HandleFunc("/invite", handlers.CreateInvite)
+	r.HandleFunc("/invite/{invite}", handlers.GetInvite)
+	r.HandleFunc("/spectate", game.RateLimited(LimitJoin, handlers.Spectate))
+	r.HandleFunc("/list", handlers.ListRooms)
+	r.HandleFunc("/archive", handlers.ListArchivedGames)
+	r.HandleFunc("/archive/{id}", handlers.GetArchivedGame)
+	r.HandleFunc("/replay", handlers.Replay)
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(game.AdminOnly)
+	admin.HandleFunc("/rooms", handlers.AdminListRooms).Methods(http.MethodGet)
+	admin.HandleFunc("/rooms/{room}", handlers.AdminGetRoom).Methods(http.MethodGet)
+	admin.HandleFunc("/rooms/{room}/state", handlers.AdminRoomState).Methods(http.MethodGet)
+	admin.HandleFunc("/rooms/{room}/close", handlers.AdminCloseRoom).Methods(http.MethodPost)
+	admin.HandleFunc("/rooms/{room}/kick", handlers.AdminKickPlayer).Methods(http.MethodPost)
+	admin.HandleFunc("/rooms/{room}/remove-bot", handlers.AdminRemoveBot).Methods(http.MethodPost)
+	admin.HandleFunc("/rooms/{room}/message", handlers.AdminSendMessage).Methods(http.MethodPost)
+	admin.HandleFunc("/message", handlers.AdminSendMessage).Methods(http.MethodPost)
 	return &game, httptest.NewServer(r), cancel
 }
 
+func joinURL(server *httptest.Server, user string, room string) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/join?room=" + room + "&player=" + user
+}
+
 func NewSocket(server *httptest.Server, user string, room string) *websocket.Conn {
-	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/join?room=" + room + "&player=" + user
+	ws, _, err := websocket.DefaultDialer.Dial(joinURL(server, user, room), nil)
+	if err != nil {
+		panic(err)
+	}
+	return ws
+}
+
+func NewSpectatorSocket(server *httptest.Server, name string, room string) *websocket.Conn {
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/spectate?room=" + room + "&name=" + name
 	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
 	if err != nil {
 		panic(err)
@@ -33,6 +77,11 @@ func NewSocket(server *httptest.Server, user string, room string) *websocket.Con
 	return ws
 }
 
+func roomPasswordMatches(g *Service, roomID string, password string) bool {
+	room, exists := g.GetRoom(roomID)
+	return exists && room.checkPassword(password) == nil
+}
+
 func NewRoomBasic(g *Service) (string, error) {
 	deck := game.NewDeck()
 	deck.Shuffle()
@@ -49,16 +98,358 @@ func TestRoomLimit(t *testing.T) {
 	}
 	_, err := NewRoomBasic(g)
 	assert.ErrorIs(t, err, ErrRoomsLimitReached)
-	for _, room := range g.rooms {
-		if room != nil {
-			room.closeRoom()
-		}
+	for _, room := range g.rooms.all() {
+		room.closeRoom()
 	}
 	time.Sleep(1 * time.Second) // wait for rooms to close
+	assert.Equal(t, 0, g.ActiveRoomCount())
 	_, err = NewRoomBasic(g)
 	assert.NoError(t, err)
 }
 
+func TestRoomLimitPerClient(t *testing.T) {
+	g, s, cancel := NewServerWithConfig(ServiceConfig{MaxRooms: 3, MaxRoomsPerClient: 2, RoomTimeout: 5 * time.Minute})
+	defer cancel()
+	defer s.Close()
+	newRoom := func(client string) (string, error) {
+		return g.NewRoomForClient(slog.Default(), client, game.NewDeck(), 4, "", DefaultRoomSettings())
+	}
+	first, err := newRoom("10.0.0.1")
+	assert.NoError(t, err)
+	_, err = newRoom("10.0.0.1")
+	assert.NoError(t, err)
+	_, err = newRoom("10.0.0.1")
+	assert.ErrorIs(t, err, ErrClientRoomsLimitReached)
+
+	// other clients are only limited by the global limit
+	_, err = newRoom("10.0.0.2")
+	assert.NoError(t, err)
+	_, err = newRoom("10.0.0.2")
+	assert.ErrorIs(t, err, ErrRoomsLimitReached)
+
+	// closed rooms are reaped in the background and free the slot of their client
+	room, ok := g.GetRoom(first)
+	assert.True(t, ok)
+	room.closeRoom()
+	assert.Eventually(t, func() bool { _, ok := g.GetRoom(first); return !ok }, time.Second, 10*time.Millisecond)
+	_, err = newRoom("10.0.0.1")
+	assert.NoError(t, err)
+}
+
+func TestServerRoomDefaults(t *testing.T) {
+	g, s, cancel := NewServerWithConfig(ServiceConfig{MaxRooms: 5, RoomTimeout: 5 * time.Minute, MaxPlayers: 4, DefaultTurnTimer: 30 * time.Second})
+	defer cancel()
+	defer s.Close()
+	newRoom := func(config string) (*http.Response, string) {
+		res, err := http.Post(s.URL+"/new", "application/json", strings.NewReader(config))
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return res, string(body)
+	}
+	turnTimer := func(roomID string) time.Duration {
+		room, ok := g.GetRoom(roomID)
+		assert.True(t, ok)
+		var timer time.Duration
+		assert.NoError(t, room.query(func() { timer = room.settings.TurnTimer }))
+		return timer
+	}
+
+	res, _ := newRoom(`{"max_players": 6}`)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// rooms created without a turn timer get the one of the server
+	res, roomID := newRoom(`{"max_players": 4}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 30*time.Second, turnTimer(roomID))
+
+	res, roomID = newRoom(`{"max_players": 4, "turn_timer": 0}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, time.Duration(0), turnTimer(roomID))
+
+	// the leader can't raise max players above the limit either
+	ws := NewSocket(s, "p1", roomID)
+	defer ws.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+	maxPlayers := 6
+	assert.NoError(t, ws.WriteJSON(Action[ActionUpdateSettingsData]{Type: ActionUpdateSettings, Data: ActionUpdateSettingsData{MaxPlayers: &maxPlayers}}))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws, UpdateTypeError), UpdateErrorData{Message: ErrMaxPlayersAboveLimit.Error()})
+}
+
+func TestAdminAPI(t *testing.T) {
+	g, s, cancel := NewServerWithConfig(ServiceConfig{MaxRooms: 5, RoomTimeout: 5 * time.Minute, AdminToken: "admin-token"})
+	defer cancel()
+	defer s.Close()
+	request := func(method string, path string, token string, body string) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+		assert.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		data, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return res, data
+	}
+	admin := func(method string, path string, body string) (*http.Response, []byte) {
+		return request(method, path, "admin-token", body)
+	}
+
+	res, _ := request(http.MethodGet, "/admin/rooms", "", "")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	res, _ = request(http.MethodGet, "/admin/rooms", "wrong-token", "")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	roomID, err := g.NewRoomForClient(slog.Default(), "", game.NewDeck(), 4, "secret", DefaultRoomSettings())
+	assert.NoError(t, err)
+	room, _ := g.GetRoom(roomID)
+	ws := NewSocket(s, "p1", roomID+"&password=secret")
+	defer ws.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+	assert.NoError(t, room.AddConnection(NewBotConnection("bot1")))
+	assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+
+	// rooms are listed with their seats
+	res, data := admin(http.MethodGet, "/admin/rooms", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var rooms []AdminRoomInfo
+	assert.NoError(t, json.Unmarshal(data, &rooms))
+	assert.Len(t, rooms, 1)
+	assert.Equal(t, roomID, rooms[0].ID)
+	assert.Equal(t, RoomPhaseLobby, rooms[0].Phase)
+	assert.Equal(t, []AdminSeat{
+		{Player: "p1", Connected: true, Leader: true},
+		{Player: "bot1", Bot: true, Connected: true, Ready: true},
+	}, rooms[0].Seats)
+
+	// the state is shown without secrets
+	res, data = admin(http.MethodGet, "/admin/rooms/"+roomID+"/state", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var state RoomSnapshot
+	assert.NoError(t, json.Unmarshal(data, &state))
+	assert.Len(t, state.Seats, 2)
+	assert.Empty(t, state.PasswordHash)
+	for _, seat := range state.Seats {
+		assert.Empty(t, seat.SessionToken)
+	}
+
+	res, _ = admin(http.MethodGet, "/admin/rooms/missing", "")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// system messages to the room and to every room
+	res, _ = admin(http.MethodPost, "/admin/rooms/"+roomID+"/message", `{"message": "hello"}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "hello", assertRecieved[UpdateSystemMessageData](t, ws, UpdateTypeSystemMessage).Data.Message)
+	res, data = admin(http.MethodPost, "/admin/message", `{"message": "restarting soon"}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{"rooms": 1}`, string(data))
+	assert.Equal(t, "restarting soon", assertRecieved[UpdateSystemMessageData](t, ws, UpdateTypeSystemMessage).Data.Message)
+	res, _ = admin(http.MethodPost, "/admin/message", `{"message": " "}`)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// only bots can be removed as bots
+	res, _ = admin(http.MethodPost, "/admin/rooms/"+roomID+"/remove-bot", `{"player": "p1"}`)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res, _ = admin(http.MethodPost, "/admin/rooms/"+roomID+"/remove-bot", `{"player": "bot1"}`)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+	assertDataMatches(t, assertRecieved[UpdatePlayerKickedData](t, ws, UpdateTypePlayerKicked), UpdatePlayerKickedData{Player: "bot1"})
+
+	// any player can be kicked and banned
+	assert.NoError(t, room.AddConnection(NewBotConnection("bot2")))
+	assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+	res, _ = admin(http.MethodPost, "/admin/rooms/"+roomID+"/kick", `{"player": "p2"}`)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = admin(http.MethodPost, "/admin/rooms/"+roomID+"/kick", `{"player": "bot2", "ban": true}`)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+	assertDataMatches(t, assertRecieved[UpdatePlayerKickedData](t, ws, UpdateTypePlayerKicked), UpdatePlayerKickedData{Player: "bot2", Banned: true})
+	info, err := room.AdminInfo()
+	assert.NoError(t, err)
+	assert.Len(t, info.Seats, 1)
+
+	// kicks the game doesn't allow are a conflict, not a server error
+	assert.NoError(t, room.AddConnection(NewBotConnection("bot3")))
+	assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+	assert.NoError(t, ws.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+	assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+	res, data = admin(http.MethodPost, "/admin/rooms/"+roomID+"/kick", `{"player": "bot3"}`)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	assert.Contains(t, string(data), game.ErrNotEnoughPlayers.Error())
+
+	// closing sends the reason to everyone left
+	res, _ = admin(http.MethodPost, "/admin/rooms/"+roomID+"/close", `{"reason": "maintenance"}`)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	closed := assertRecieved[UpdateRoomClosedData](t, ws, UpdateTypeRoomClosed)
+	assert.Equal(t, CloseReasonAdmin, closed.Data.Reason)
+	assert.Equal(t, "maintenance", closed.Data.Message)
+	assert.True(t, room.HasClosed())
+	res, _ = admin(http.MethodPost, "/admin/rooms/"+roomID+"/close", `{"reason": "again"}`)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// the API is disabled without a token
+	_, s2, cancel2 := NewServer()
+	defer cancel2()
+	defer s2.Close()
+	res, _ = request(http.MethodGet, "/admin/rooms", "admin-token", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	req, err := http.NewRequest(http.MethodGet, s2.URL+"/admin/rooms", nil)
+	assert.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestRoomCloseReasons(t *testing.T) {
+	archive, err := NewFileArchive(t.TempDir())
+	assert.NoError(t, err)
+	g, s, cancel := NewServerWithConfig(ServiceConfig{MaxRooms: 5, RoomTimeout: 5 * time.Minute, Archive: archive})
+	defer cancel()
+	defer s.Close()
+
+	// interrupted games keep the standings and rounds played so far
+	deck := make(game.Deck, 0, 10)
+	for i := 0; i < 10; i++ {
+		deck = append(deck, game.Card{Suit: game.SuitClubs, Value: 13})
+	}
+	roomID, err := g.NewRoom(slog.Default(), deck, 2, "")
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws1.Close()
+	defer ws2.Close()
+	both := []*websocket.Conn{ws1, ws2}
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	for _, ws := range both {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+	}
+	for _, peeker := range both {
+		assert.NoError(t, peeker.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+		for _, ws := range both {
+			assertRecieved[UpdatePlayerFirstPeekedData](t, ws, UpdateTypePlayerFirstPeeked)
+		}
+	}
+	for _, ws := range both {
+		assertRecieved[UpdateTurnData](t, ws, UpdateTypeTurn)
+	}
+	assert.NoError(t, ws1.WriteJSON(Action[ActionCutData]{Type: ActionCut}))
+	for _, ws := range both {
+		assertRecieved[UpdateCutData](t, ws, UpdateTypeCut)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeStartNextRound)
+	}
+	assert.NoError(t, g.AdminCloseRoom(roomID, "maintenance"))
+	for _, ws := range both {
+		closed := assertRecieved[UpdateRoomClosedData](t, ws, UpdateTypeRoomClosed)
+		assert.Equal(t, CloseReasonAdmin, closed.Data.Reason)
+		assert.Len(t, closed.Data.Standings, 2)
+		assert.True(t, slices.IsSortedFunc(closed.Data.Standings, func(a, b MarshalledPlayer) int { return a.Points - b.Points }))
+		assert.Len(t, closed.Data.Rounds, 1)
+	}
+	games, err := archive.List(ArchiveFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, games, 1)
+	assert.Equal(t, CloseReasonAdmin, games[0].CloseReason)
+	assert.Equal(t, game.PlayerID(""), games[0].Winner)
+	record, err := archive.Get(games[0].ID)
+	assert.NoError(t, err)
+	assert.Len(t, record.Rounds, 1)
+
+	// rooms close once everyone leaves
+	roomID, err = NewRoomBasic(g)
+	assert.NoError(t, err)
+	room, _ := g.GetRoom(roomID)
+	ws3 := NewSocket(s, "p3", roomID)
+	defer ws3.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws3, UpdateTypePlayersChanged)
+	assert.NoError(t, ws3.WriteJSON(Action[ActionWithoutData]{Type: ActionLeave}))
+	assert.Eventually(t, room.HasClosed, 3*time.Second, 10*time.Millisecond)
+
+	// rooms are warned before timing out
+	g, s, cancel = NewServerWithConfig(ServiceConfig{MaxRooms: 5, RoomTimeout: 2 * time.Second})
+	defer cancel()
+	defer s.Close()
+	roomID, err = NewRoomBasic(g)
+	assert.NoError(t, err)
+	ws4 := NewSocket(s, "p4", roomID)
+	defer ws4.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws4, UpdateTypePlayersChanged)
+	closing := assertRecieved[UpdateRoomClosingData](t, ws4, UpdateTypeRoomClosing)
+	assert.Equal(t, CloseReasonTimeout, closing.Data.Reason)
+	assert.WithinDuration(t, time.Now().Add(time.Second), closing.Data.ClosesAt, 500*time.Millisecond)
+	closed := assertRecieved[UpdateRoomClosedData](t, ws4, UpdateTypeRoomClosed)
+	assert.Equal(t, CloseReasonTimeout, closed.Data.Reason)
+	assert.Equal(t, []MarshalledPlayer{{ID: "p4"}}, closed.Data.Standings)
+
+	// and closed if nobody plays for a while
+	g, s, cancel = NewServerWithConfig(ServiceConfig{MaxRooms: 5, IdleTimeout: time.Second})
+	defer cancel()
+	defer s.Close()
+	roomID, err = NewRoomBasic(g)
+	assert.NoError(t, err)
+	room, _ = g.GetRoom(roomID)
+	observer := &recordingObserver{}
+	g.Observe(observer)
+	assert.Eventually(t, room.HasClosed, 1900*time.Millisecond, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return len(observer.Events()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"closed " + roomID + ": idle"}, observer.Events())
+}
+
+func TestRoomRegistryConcurrency(t *testing.T) {
+	g, s, cancel := NewServerWithConfig(ServiceConfig{MaxRooms: 20, RoomTimeout: 5 * time.Minute})
+	defer cancel()
+	defer s.Close()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := make([]string, 0)
+	limited := 0
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			roomID, err := NewRoomBasic(g)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				assert.ErrorIs(t, err, ErrRoomsLimitReached)
+				limited++
+				return
+			}
+			created = append(created, roomID)
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.ListRooms()
+			g.GetRoom("ABCD")
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, created, 20)
+	assert.Equal(t, 20, limited)
+	assert.Equal(t, 20, g.ActiveRoomCount())
+	for _, roomID := range created {
+		room, ok := g.GetRoom(roomID)
+		assert.True(t, ok)
+		assert.Equal(t, roomID, room.ID)
+	}
+
+	for _, roomID := range created[:10] {
+		room, _ := g.GetRoom(roomID)
+		room.closeRoom()
+	}
+	assert.Eventually(t, func() bool { return g.ActiveRoomCount() == 10 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, g.ListRooms(), 10)
+}
+
 func TestPlayersJoinRoom(t *testing.T) {
 	g, s, cancel := NewServer()
 	defer cancel()
@@ -110,8 +501,8 @@ func TestDoubleDiscard(t *testing.T) {
 		// both players recieve game config
 		u1 := assertRecieved[UpdateGameConfig](t, ws1, UpdateTypeGameConfig)
 		u2 := assertRecieved[UpdateGameConfig](t, ws2, UpdateTypeGameConfig)
-		assertDataMatches(t, u1, UpdateGameConfig{CardsInDeck: 11})
-		assertDataMatches(t, u2, UpdateGameConfig{CardsInDeck: 11})
+		assertDataMatches(t, u1, UpdateGameConfig{CardsInDeck: 11, MaxPlayers: 4, Rules: game.DefaultRules(), Leader: "p1"})
+		assertDataMatches(t, u2, UpdateGameConfig{CardsInDeck: 11, MaxPlayers: 4, Rules: game.DefaultRules(), Leader: "p1"})
 	}
 
 	{
@@ -235,8 +626,8 @@ func TestBasicGame(t *testing.T) {
 		// both players recieve game config
 		u1 := assertRecieved[UpdateGameConfig](t, ws1, UpdateTypeGameConfig)
 		u2 := assertRecieved[UpdateGameConfig](t, ws2, UpdateTypeGameConfig)
-		assertDataMatches(t, u1, UpdateGameConfig{CardsInDeck: 50})
-		assertDataMatches(t, u2, UpdateGameConfig{CardsInDeck: 50})
+		assertDataMatches(t, u1, UpdateGameConfig{CardsInDeck: 50, MaxPlayers: 4, Rules: game.DefaultRules(), Leader: "p1"})
+		assertDataMatches(t, u2, UpdateGameConfig{CardsInDeck: 50, MaxPlayers: 4, Rules: game.DefaultRules(), Leader: "p1"})
 	}
 
 	{
@@ -387,6 +778,1766 @@ func TestBasicGame(t *testing.T) {
 	}
 }
 
+func TestSpectator(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	deck := game.NewDeck()
+	roomID, err := g.NewRoom(slog.Default(), deck, 2, "")
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws1.Close()
+	defer ws2.Close()
+
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	// spectator joins a full room
+	ws3 := NewSpectatorSocket(s, "grandma", roomID)
+	defer ws3.Close()
+	{
+		u3 := assertRecieved[UpdatePlayersChangedData](t, ws3, UpdateTypePlayersChanged)
+		assert.Len(t, u3.Data.Players, 2)
+		expected := UpdateSpectatorsChangedData{Allowed: true, Spectators: []game.PlayerID{"grandma"}}
+		assertDataMatches(t, assertRecieved[UpdateSpectatorsChangedData](t, ws1, UpdateTypeSpectatorsChanged), expected)
+		assertDataMatches(t, assertRecieved[UpdateSpectatorsChangedData](t, ws2, UpdateTypeSpectatorsChanged), expected)
+		assertDataMatches(t, assertRecieved[UpdateSpectatorsChangedData](t, ws3, UpdateTypeSpectatorsChanged), expected)
+	}
+	room, _ := g.GetRoom(roomID)
+	assert.Equal(t, 1, room.CurrentSpectators())
+
+	// spectator can't start the game
+	assert.NoError(t, ws3.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws3, UpdateTypeError), UpdateErrorData{Message: ErrSpectatorAction.Error()})
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	for _, ws := range []*websocket.Conn{ws1, ws2, ws3} {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+	}
+
+	// spectator only sees public information
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+	assertDataMatches(t, assertRecieved[UpdatePlayerFirstPeekedData](t, ws1, UpdateTypePlayerFirstPeeked), UpdatePlayerFirstPeekedData{Player: "p1", Cards: deck[:2]})
+	assertDataMatches(t, assertRecieved[UpdatePlayerFirstPeekedData](t, ws2, UpdateTypePlayerFirstPeeked), UpdatePlayerFirstPeekedData{Player: "p1"})
+	assertDataMatches(t, assertRecieved[UpdatePlayerFirstPeekedData](t, ws3, UpdateTypePlayerFirstPeeked), UpdatePlayerFirstPeekedData{Player: "p1"})
+
+	assert.NoError(t, ws2.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+	for _, ws := range []*websocket.Conn{ws1, ws2, ws3} {
+		assertRecieved[UpdatePlayerFirstPeekedData](t, ws, UpdateTypePlayerFirstPeeked)
+		assertRecieved[UpdateTurnData](t, ws, UpdateTypeTurn)
+	}
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionDrawData]{Type: ActionDraw, Data: ActionDrawData{Source: game.DrawSourcePile}}))
+	assertDataMatches(t, assertRecieved[UpdateDrawData](t, ws1, UpdateTypeDraw), UpdateDrawData{Player: "p1", Source: game.DrawSourcePile, Effect: game.CardEffectNone, Card: deck[9]})
+	assertDataMatches(t, assertRecieved[UpdateDrawData](t, ws2, UpdateTypeDraw), UpdateDrawData{Player: "p1", Source: game.DrawSourcePile})
+	assertDataMatches(t, assertRecieved[UpdateDrawData](t, ws3, UpdateTypeDraw), UpdateDrawData{Player: "p1", Source: game.DrawSourcePile})
+
+	// only the leader can disable spectating
+	assert.NoError(t, ws2.WriteJSON(Action[ActionToggleSpectatorsData]{Type: ActionToggleSpectators, Data: ActionToggleSpectatorsData{Allow: false}}))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws2, UpdateTypeError), UpdateErrorData{Message: ErrNotRoomLeader.Error()})
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionToggleSpectatorsData]{Type: ActionToggleSpectators, Data: ActionToggleSpectatorsData{Allow: false}}))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws3, UpdateTypeError), UpdateErrorData{Message: ErrSpectatingDisabled.Error()})
+	_, _, err = ws3.ReadMessage()
+	assert.Error(t, err)
+	expected := UpdateSpectatorsChangedData{Allowed: false, Spectators: []game.PlayerID{}}
+	assertDataMatches(t, assertRecieved[UpdateSpectatorsChangedData](t, ws1, UpdateTypeSpectatorsChanged), expected)
+	assertDataMatches(t, assertRecieved[UpdateSpectatorsChangedData](t, ws2, UpdateTypeSpectatorsChanged), expected)
+	assert.Equal(t, 0, room.CurrentSpectators())
+}
+
+func TestChat(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2, res, err := websocket.DefaultDialer.Dial(joinURL(s, "p2", roomID), nil)
+	assert.NoError(t, err)
+	defer ws1.Close()
+
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	// chat is not restricted by turns
+	assert.NoError(t, ws2.WriteJSON(Action[ActionChatData]{Type: ActionChat, Data: ActionChatData{Message: "  hola abuela  "}}))
+	u1 := assertRecieved[UpdateChatData](t, ws1, UpdateTypeChat)
+	u2 := assertRecieved[UpdateChatData](t, ws2, UpdateTypeChat)
+	assert.Equal(t, "hola abuela", u1.Data.Message)
+	assert.Equal(t, u1.Data, u2.Data)
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionChatData]{Type: ActionChat, Data: ActionChatData{Reaction: ChatReactions[0]}}))
+	u1 = assertRecieved[UpdateChatData](t, ws1, UpdateTypeChat)
+	assertRecieved[UpdateChatData](t, ws2, UpdateTypeChat)
+	assert.Equal(t, game.PlayerID("p1"), u1.Data.Player)
+	assert.Equal(t, ChatReactions[0], u1.Data.Reaction)
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionChatData]{Type: ActionChat, Data: ActionChatData{Reaction: "not-a-reaction"}}))
+	assertRecieved[UpdateErrorData](t, ws1, UpdateTypeError)
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionChatData]{Type: ActionChat, Data: ActionChatData{Message: strings.Repeat("a", MAX_CHAT_MESSAGE_LENGTH+1)}}))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws1, UpdateTypeError), UpdateErrorData{Message: ErrChatMessageTooLong.Error()})
+
+	// history is sent on rejoin
+	ws2.Close()
+	time.Sleep(100 * time.Millisecond)
+	header := http.Header{"Cookie": []string{res.Cookies()[0].String()}}
+	ws2, _, err = websocket.DefaultDialer.Dial(joinURL(s, "p2", roomID), header)
+	assert.NoError(t, err)
+	defer ws2.Close()
+	rejoin := assertRecieved[UpdateTypeRejoinData](t, ws2, UpdateTypeRejoin)
+	assert.Len(t, rejoin.Data.Chat, 2)
+	assert.Equal(t, "hola abuela", rejoin.Data.Chat[0].Message)
+	assert.Equal(t, ChatReactions[0], rejoin.Data.Chat[1].Reaction)
+}
+
+func TestLeaderPowers(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	defer ws1.Close()
+	u := assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assert.Equal(t, game.PlayerID("p1"), u.Data.Leader)
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws2.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+	ws3 := NewSocket(s, "p3", roomID)
+	defer ws3.Close()
+	for _, ws := range []*websocket.Conn{ws1, ws2, ws3} {
+		u := assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+		assert.Equal(t, game.PlayerID("p1"), u.Data.Leader)
+	}
+
+	// only the leader can kick
+	assert.NoError(t, ws2.WriteJSON(Action[ActionKickData]{Type: ActionKick, Data: ActionKickData{Player: "p3"}}))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws2, UpdateTypeError), UpdateErrorData{Message: ErrNotRoomLeader.Error()})
+
+	// leader bans p3
+	assert.NoError(t, ws1.WriteJSON(Action[ActionKickData]{Type: ActionKick, Data: ActionKickData{Player: "p3", Ban: true}}))
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		u := assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+		assert.Len(t, u.Data.Players, 2)
+		assertDataMatches(t, assertRecieved[UpdatePlayerKickedData](t, ws, UpdateTypePlayerKicked), UpdatePlayerKickedData{Player: "p3", Banned: true})
+	}
+	assertDataMatches(t, assertRecieved[UpdatePlayerKickedData](t, ws3, UpdateTypePlayerKicked), UpdatePlayerKickedData{Player: "p3", Banned: true})
+	_, _, err = ws3.ReadMessage()
+	assert.Error(t, err)
+
+	// banned players can't join again
+	ws3 = NewSocket(s, "p3", roomID)
+	defer ws3.Close()
+	assertRecieved[UpdateErrorData](t, ws3, UpdateTypeError)
+	_, _, err = ws3.ReadMessage()
+	assert.Error(t, err)
+	room, _ := g.GetRoom(roomID)
+	assert.Equal(t, 2, room.CurrentPlayers())
+
+	// leader transfers leadership
+	assert.NoError(t, ws1.WriteJSON(Action[ActionTransferLeaderData]{Type: ActionTransferLeader, Data: ActionTransferLeaderData{Player: "p2"}}))
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		assertDataMatches(t, assertRecieved[UpdateLeaderChangedData](t, ws, UpdateTypeLeaderChanged), UpdateLeaderChangedData{Leader: "p2"})
+	}
+
+	// leadership moves when the leader leaves
+	assert.NoError(t, ws2.WriteJSON(Action[ActionWithoutData]{Type: ActionLeave}))
+	assertDataMatches(t, assertRecieved[UpdateLeaderChangedData](t, ws1, UpdateTypeLeaderChanged), UpdateLeaderChangedData{Leader: "p1"})
+	u = assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assert.Equal(t, []MarshalledPlayer{{ID: "p1"}}, u.Data.Players)
+	assert.Equal(t, game.PlayerID("p1"), u.Data.Leader)
+}
+
+func TestLeaderCantLeaveTwoPlayerGame(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	defer ws1.Close()
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws2.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+	}
+
+	// the leave is rejected and the leader keeps the room
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionLeave}))
+	u := assertRecieved[UpdateErrorData](t, ws1, UpdateTypeError)
+	assert.Contains(t, u.Data.Message, game.ErrNotEnoughPlayers.Error())
+	room, _ := g.GetRoom(roomID)
+	info, err := room.AdminInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(info.Seats))
+	assert.True(t, info.Seats[0].Leader)
+	assert.False(t, info.Seats[1].Leader)
+
+	// and nothing was sent to the other player
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+	assertRecieved[UpdatePlayerFirstPeekedData](t, ws2, UpdateTypePlayerFirstPeeked)
+}
+
+func TestReadyAutoStart(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	deck := game.NewDeck()
+	settings := DefaultRoomSettings()
+	settings.AutoStart = true
+	settings.StartCountdown = 200 * time.Millisecond
+	roomID, err := g.NewRoomWithSettings(slog.Default(), deck, 4, "", settings)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws1.Close()
+	defer ws2.Close()
+
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionReadyData]{Type: ActionReady, Data: ActionReadyData{Ready: true}}))
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		u := assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+		assert.Equal(t, []MarshalledPlayer{{ID: "p1", Ready: true}, {ID: "p2"}}, u.Data.Players)
+	}
+
+	// last player ready starts the countdown
+	assert.NoError(t, ws2.WriteJSON(Action[ActionReadyData]{Type: ActionReady, Data: ActionReadyData{Ready: true}}))
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		u := assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+		assert.Equal(t, []MarshalledPlayer{{ID: "p1", Ready: true}, {ID: "p2", Ready: true}}, u.Data.Players)
+		assertDataMatches(t, assertRecieved[UpdateStartCountdownData](t, ws, UpdateTypeStartCountdown), UpdateStartCountdownData{Seconds: 1})
+	}
+
+	// game starts without the leader and ready state is cleared
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		u := assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+		assert.False(t, u.Data.Players[0].Ready)
+	}
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionReadyData]{Type: ActionReady, Data: ActionReadyData{Ready: false}}))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws1, UpdateTypeError), UpdateErrorData{Message: game.ErrGameAlreadyStarted.Error()})
+}
+
+func TestRematchOnlyAfterGame(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws1.Close()
+	defer ws2.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	// votes in the lobby don't start the game
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		assert.NoError(t, ws.WriteJSON(Action[ActionRematchData]{Type: ActionRematch, Data: ActionRematchData{Vote: true}}))
+		assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws, UpdateTypeError), UpdateErrorData{Message: ErrNoGameToRematch.Error()})
+	}
+	room, _ := g.GetRoom(roomID)
+	info, err := room.AdminInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, RoomPhaseLobby, info.Phase)
+}
+
+func TestRematchSeries(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	// every hand is worth 52 points, so the game ends after two cuts
+	deck := make(game.Deck, 0, 10)
+	for i := 0; i < 10; i++ {
+		deck = append(deck, game.Card{Suit: game.SuitClubs, Value: 13})
+	}
+	roomID, err := g.NewRoom(slog.Default(), deck, 2, "")
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws1.Close()
+	defer ws2.Close()
+	both := []*websocket.Conn{ws1, ws2}
+
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	// only the leader sets up the series
+	assert.NoError(t, ws2.WriteJSON(Action[ActionSetSeriesData]{Type: ActionSetSeries, Data: ActionSetSeriesData{Games: 3}}))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws2, UpdateTypeError), UpdateErrorData{Message: ErrNotRoomLeader.Error()})
+	assert.NoError(t, ws1.WriteJSON(Action[ActionSetSeriesData]{Type: ActionSetSeries, Data: ActionSetSeriesData{Games: 3}}))
+	for _, ws := range both {
+		u := assertRecieved[UpdateSeriesData](t, ws, UpdateTypeSeriesChanged)
+		assertDataMatches(t, u, UpdateSeriesData{Length: 3, Wins: map[game.PlayerID]int{}})
+	}
+
+	playRound := func(startUpdate UpdateType, cutter *websocket.Conn) {
+		for _, ws := range both {
+			assertRecieved[UpdateStartNextRoundData](t, ws, startUpdate)
+		}
+		for _, peeker := range both {
+			assert.NoError(t, peeker.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+			for _, ws := range both {
+				assertRecieved[UpdatePlayerFirstPeekedData](t, ws, UpdateTypePlayerFirstPeeked)
+			}
+		}
+		for _, ws := range both {
+			assertRecieved[UpdateTurnData](t, ws, UpdateTypeTurn)
+		}
+		assert.NoError(t, cutter.WriteJSON(Action[ActionCutData]{Type: ActionCut}))
+		for _, ws := range both {
+			assertRecieved[UpdateCutData](t, ws, UpdateTypeCut)
+		}
+	}
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	for _, ws := range both {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+	}
+	playRound(UpdateTypeGameStart, ws1)
+	playRound(UpdateTypeStartNextRound, ws2)
+
+	// the room stays open and keeps the score of the series
+	for _, ws := range both {
+		assertRecieved[UpdateEndGameData](t, ws, UpdateTypeEndGame)
+		u := assertRecieved[UpdateSeriesData](t, ws, UpdateTypeSeriesChanged)
+		assertDataMatches(t, u, UpdateSeriesData{Length: 3, Played: 1, Wins: map[game.PlayerID]int{"p1": 1}})
+		p := assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+		assert.Equal(t, []MarshalledPlayer{{ID: "p1"}, {ID: "p2"}}, p.Data.Players)
+	}
+	room, _ := g.GetRoom(roomID)
+	assert.False(t, room.HasClosed())
+
+	// rematch starts once everyone votes
+	assert.NoError(t, ws1.WriteJSON(Action[ActionRematchData]{Type: ActionRematch, Data: ActionRematchData{Vote: true}}))
+	for _, ws := range both {
+		assertDataMatches(t, assertRecieved[UpdateRematchVotesData](t, ws, UpdateTypeRematchVotes), UpdateRematchVotesData{Votes: []game.PlayerID{"p1"}})
+	}
+	assert.NoError(t, ws2.WriteJSON(Action[ActionRematchData]{Type: ActionRematch, Data: ActionRematchData{Vote: true}}))
+	for _, ws := range both {
+		assertDataMatches(t, assertRecieved[UpdateRematchVotesData](t, ws, UpdateTypeRematchVotes), UpdateRematchVotesData{Votes: []game.PlayerID{"p1", "p2"}})
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		u := assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+		assert.Equal(t, 4, u.Data.Players[1].CardsInHand)
+	}
+
+	// series can't be changed until it's over
+	assert.NoError(t, ws1.WriteJSON(Action[ActionSetSeriesData]{Type: ActionSetSeries, Data: ActionSetSeriesData{Games: 5}}))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws1, UpdateTypeError), UpdateErrorData{Message: ErrSeriesInProgress.Error()})
+}
+
+func TestUpdateSettings(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws1.Close()
+	defer ws2.Close()
+	both := []*websocket.Conn{ws1, ws2}
+
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	password := "secret"
+	maxPlayers := 2
+	turnTimer := 1
+	update := Action[ActionUpdateSettingsData]{Type: ActionUpdateSettings, Data: ActionUpdateSettingsData{
+		MaxPlayers:  &maxPlayers,
+		DeckOptions: &DeckOptions{Extended: true},
+		Password:    &password,
+		Rules:       &game.Rules{MaxPoints: 50},
+		TurnTimer:   &turnTimer,
+	}}
+
+	// only the leader can change settings
+	assert.NoError(t, ws2.WriteJSON(update))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws2, UpdateTypeError), UpdateErrorData{Message: ErrNotRoomLeader.Error()})
+
+	// invalid changes are rejected as a whole
+	invalid := 1
+	assert.NoError(t, ws1.WriteJSON(Action[ActionUpdateSettingsData]{Type: ActionUpdateSettings, Data: ActionUpdateSettingsData{MaxPlayers: &invalid, Password: &password}}))
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws1, UpdateTypeError), UpdateErrorData{Message: ErrInvalidMaxPlayers.Error()})
+	assert.True(t, roomPasswordMatches(g, roomID, ""))
+
+	assert.NoError(t, ws1.WriteJSON(update))
+	for _, ws := range both {
+		u := assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		assertDataMatches(t, u, UpdateGameConfig{
+			CardsInDeck: len(game.AddExtendedVariation(game.NewDeck())),
+			HasPassword: true,
+			MaxPlayers:  2,
+			DeckOptions: DeckOptions{Extended: true},
+			Rules:       game.Rules{MaxPoints: 50},
+			TurnTimer:   1,
+			Leader:      "p1",
+		})
+	}
+	assert.True(t, roomPasswordMatches(g, roomID, password))
+	assert.False(t, roomPasswordMatches(g, roomID, ""))
+}
+
+func TestListRooms(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	fullRoom, err := g.NewRoom(slog.Default(), game.NewDeck(), 2, "")
+	assert.NoError(t, err)
+	privateRoom, err := g.NewRoom(slog.Default(), game.NewDeck(), 4, "secret")
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", fullRoom)
+	ws2 := NewSocket(s, "p2", fullRoom)
+	defer ws1.Close()
+	defer ws2.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	list := func(query string) RoomList {
+		res, err := http.Get(s.URL + "/list?" + query)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var list RoomList
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+		return list
+	}
+
+	all := list("")
+	assert.Equal(t, 2, all.Total)
+	assert.Equal(t, fullRoom, all.Rooms[0].ID)
+	assert.Equal(t, 2, all.Rooms[0].Players)
+	assert.Equal(t, 2, all.Rooms[0].MaxPlayers)
+	assert.Equal(t, game.PlayerID("p1"), all.Rooms[0].Leader)
+	assert.Equal(t, RoomStatusWaiting, all.Rooms[0].Status)
+	assert.Equal(t, game.DefaultRules(), all.Rooms[0].Rules)
+	assert.False(t, all.Rooms[0].CreatedAt.IsZero())
+	assert.True(t, all.Rooms[1].Private)
+
+	joinable := list("joinable=true")
+	assert.Equal(t, 1, joinable.Total)
+	assert.Equal(t, privateRoom, joinable.Rooms[0].ID)
+
+	assert.Equal(t, 0, list("joinable=true&public=true").Total)
+
+	page := list("per_page=1&page=2")
+	assert.Equal(t, 2, page.Total)
+	assert.Len(t, page.Rooms, 1)
+	assert.Equal(t, privateRoom, page.Rooms[0].ID)
+	assert.Empty(t, list("page=3").Rooms)
+
+	res, err := http.Get(s.URL + "/list?per_page=1000")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestSessionCookie(t *testing.T) {
+	g, s, cancel := NewServerWithConfig(ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute, SessionSecrets: [][]byte{[]byte("old")}})
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+
+	// names with the old separator don't break the cookie
+	ws, res, err := websocket.DefaultDialer.Dial(joinURL(s, "a::b", roomID), nil)
+	assert.NoError(t, err)
+	assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+	cookie := res.Cookies()[0]
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.False(t, cookie.Secure)
+	assert.NotContains(t, cookie.Value, "a::b")
+
+	sessionInfo := func(cookie *http.Cookie) (SessionInfo, int) {
+		req, err := http.NewRequest(http.MethodGet, s.URL+"/session", nil)
+		assert.NoError(t, err)
+		req.AddCookie(cookie)
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		var info SessionInfo
+		if res.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&info))
+		}
+		return info, res.StatusCode
+	}
+	info, status := sessionInfo(cookie)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, SessionInfo{Player: "a::b", Room: roomID}, info)
+
+	// tampered cookies are rejected
+	payload, signature, _ := strings.Cut(cookie.Value, ".")
+	forged, err := json.Marshal(session{Player: "p2", Room: roomID, Expires: time.Now().Add(time.Hour).Unix()})
+	assert.NoError(t, err)
+	_, status = sessionInfo(&http.Cookie{Name: TOKEN_COOKIE_NAME, Value: base64.RawURLEncoding.EncodeToString(forged) + "." + signature})
+	assert.Equal(t, http.StatusNotFound, status)
+	_, status = sessionInfo(&http.Cookie{Name: TOKEN_COOKIE_NAME, Value: payload})
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// expiration is checked by the server, not only by the browser
+	_, err = g.sessions.decode(cookie.Value, time.Now().Add(SESSION_DURATION+time.Minute))
+	assert.ErrorIs(t, err, ErrExpiredCookie)
+
+	// cookies signed before rotating the secret are still valid and get signed again on reconnect
+	assert.NoError(t, g.RotateSessionSecret([]byte("new")))
+	_, status = sessionInfo(cookie)
+	assert.Equal(t, http.StatusOK, status)
+	ws.Close()
+	time.Sleep(100 * time.Millisecond)
+	header := http.Header{"Cookie": []string{cookie.String()}}
+	ws, res, err = websocket.DefaultDialer.Dial(joinURL(s, "a::b", roomID), header)
+	assert.NoError(t, err)
+	defer ws.Close()
+	assertRecieved[UpdateTypeRejoinData](t, ws, UpdateTypeRejoin)
+	renewed := res.Cookies()[0]
+	assert.NotEqual(t, cookie.Value, renewed.Value)
+	_, err = newSessionKeys([]byte("new")).decode(renewed.Value, time.Now())
+	assert.NoError(t, err)
+	_, err = newSessionKeys([]byte("new")).decode(cookie.Value, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+}
+
+func TestRoomInvites(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	roomID, err := g.NewRoom(slog.Default(), game.NewDeck(), 4, "secret")
+	assert.NoError(t, err)
+
+	// passwords are still required without an invite
+	wrong := NewSocket(s, "p0", roomID+"&password=Secret")
+	defer wrong.Close()
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, wrong, UpdateTypeError), UpdateErrorData{Message: ErrInvalidPassword.Error()})
+
+	ws1, res1, err := websocket.DefaultDialer.Dial(joinURL(s, "p1", roomID)+"&password=secret", nil)
+	assert.NoError(t, err)
+	defer ws1.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	ws2, res2, err := websocket.DefaultDialer.Dial(joinURL(s, "p2", roomID)+"&password=secret", nil)
+	assert.NoError(t, err)
+	defer ws2.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	createInvite := func(cookie *http.Cookie, config InviteConfig) (Invite, int) {
+		body, err := json.Marshal(config)
+		assert.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, s.URL+"/invite", strings.NewReader(string(body)))
+		assert.NoError(t, err)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		var invite Invite
+		if res.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&invite))
+		}
+		return invite, res.StatusCode
+	}
+
+	// only the leader can create invites
+	_, status := createInvite(nil, InviteConfig{})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	_, status = createInvite(res2.Cookies()[0], InviteConfig{})
+	assert.Equal(t, http.StatusForbidden, status)
+	_, status = createInvite(res1.Cookies()[0], InviteConfig{Duration: int((MAX_INVITE_DURATION + time.Hour).Seconds())})
+	assert.Equal(t, http.StatusBadRequest, status)
+	invite, status := createInvite(res1.Cookies()[0], InviteConfig{Duration: 60, MaxUses: 1})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, roomID, invite.Room)
+	assert.Len(t, invite.Code, INVITE_CODE_LENGTH)
+	for _, c := range invite.Code {
+		assert.Contains(t, INVITE_CODE_CHARS, string(c))
+	}
+
+	res, err := http.Get(s.URL + "/invite/" + strings.ToLower(invite.Code))
+	assert.NoError(t, err)
+	var info InviteInfo
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&info))
+	res.Body.Close()
+	assert.Equal(t, InviteInfo{Room: roomID}, info)
+
+	// the code is enough to join, without the room or the password
+	ws3, _, err := websocket.DefaultDialer.Dial(joinURL(s, "p3", "")+"&invite="+invite.Code, nil)
+	assert.NoError(t, err)
+	defer ws3.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws3, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	// until it runs out of uses
+	ws4 := NewSocket(s, "p4", roomID+"&invite="+invite.Token)
+	defer ws4.Close()
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws4, UpdateTypeError), UpdateErrorData{Message: ErrInviteUsedUp.Error()})
+
+	// or expires
+	expired, err := g.sessions.seal(SIGNED_INVITE, inviteClaims{ID: "expired", Room: roomID, Expires: time.Now().Add(-time.Minute).Unix()})
+	assert.NoError(t, err)
+	ws5 := NewSocket(s, "p5", roomID+"&invite="+expired)
+	defer ws5.Close()
+	assertDataMatches(t, assertRecieved[UpdateErrorData](t, ws5, UpdateTypeError), UpdateErrorData{Message: ErrInviteExpired.Error()})
+
+	// tampered invites are rejected
+	_, signature, _ := strings.Cut(invite.Token, ".")
+	forged, err := json.Marshal(inviteClaims{ID: "forged", Room: roomID, Expires: time.Now().Add(time.Hour).Unix()})
+	assert.NoError(t, err)
+	_, err = g.resolveInvite(base64.RawURLEncoding.EncodeToString(forged) + "." + signature)
+	assert.ErrorIs(t, err, ErrInvalidInvite)
+	// and session cookies can't be used as invites
+	_, err = g.resolveInvite(res1.Cookies()[0].Value)
+	assert.ErrorIs(t, err, ErrInvalidInvite)
+}
+
+func TestWebsocketOrigins(t *testing.T) {
+	dial := func(s *httptest.Server, roomID string, player string, origin string) (*http.Response, error) {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		ws, res, err := websocket.DefaultDialer.Dial(joinURL(s, player, roomID), header)
+		if err == nil {
+			ws.Close()
+		}
+		return res, err
+	}
+
+	// only the server itself by default
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	_, err = dial(s, roomID, "p1", s.URL)
+	assert.NoError(t, err)
+	res, err := dial(s, roomID, "p2", "http://evil.example")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	g, s, cancel = NewServerWithConfig(ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute, AllowedOrigins: []string{"https://tincho.example/"}})
+	defer cancel()
+	defer s.Close()
+	roomID, err = NewRoomBasic(g)
+	assert.NoError(t, err)
+	_, err = dial(s, roomID, "p1", "https://tincho.example")
+	assert.NoError(t, err)
+	_, err = dial(s, roomID, "p2", s.URL)
+	assert.Error(t, err)
+
+	// rejected upgrades when removing the session cookie don't crash the handler
+	handlers := NewHandlers(slog.Default(), g)
+	req := httptest.NewRequest(http.MethodGet, "/join?room=missing&player=p1", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-Websocket-Version", "13")
+	req.Header.Set("Sec-Websocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "http://evil.example")
+	rec := httptest.NewRecorder()
+	assert.NotPanics(t, func() { handlers.JoinRoom(rec, req) })
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestWebsocketMessageLimits(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+
+	assertClosedWith := func(ws *websocket.Conn, code int) {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				assert.True(t, websocket.IsCloseError(err, code), err.Error())
+				return
+			}
+		}
+	}
+
+	ws1 := NewSocket(s, "p1", roomID)
+	defer ws1.Close()
+	assert.NoError(t, ws1.WriteMessage(websocket.TextMessage, []byte(`{"type":"chat","data":{"message":"`+strings.Repeat("a", MAX_MESSAGE_SIZE)+`"}}`)))
+	assertClosedWith(ws1, websocket.CloseMessageTooBig)
+
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws2.Close()
+	deep := `{"type":"chat","data":` + strings.Repeat("[", MAX_JSON_DEPTH) + strings.Repeat("]", MAX_JSON_DEPTH) + `}`
+	assert.NoError(t, ws2.WriteMessage(websocket.TextMessage, []byte(deep)))
+	assertClosedWith(ws2, websocket.ClosePolicyViolation)
+
+	// brackets in strings don't count
+	assert.NoError(t, checkJSONDepth([]byte(`{"type":"chat","data":{"message":"[[[[[[[[[[\"{{{{{{{{{{"}}`)))
+}
+
+func TestRateLimitedRoutes(t *testing.T) {
+	cfg := ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute, Abuse: AbuseConfig{
+		Join:        RateLimit{Burst: 2, Interval: time.Hour},
+		BanAfter:    2,
+		BanWindow:   time.Minute,
+		BanDuration: time.Hour,
+	}}
+	g, s, cancel := NewServerWithConfig(cfg)
+	defer cancel()
+	defer s.Close()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	join := g.RateLimited(LimitJoin, ok)
+	newRoom := g.RateLimited(LimitNewRoom, ok)
+	request := func(handler http.HandlerFunc, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		res := httptest.NewRecorder()
+		handler(res, req)
+		return res
+	}
+
+	assert.Equal(t, http.StatusOK, request(join, "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, request(join, "10.0.0.1").Code)
+	res := request(join, "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, ErrRateLimited.Error(), res.Body.String())
+
+	// repeat offenders are banned from every route
+	res = request(join, "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, ErrClientBanned.Error(), res.Body.String())
+	assert.Equal(t, "3600", res.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, request(newRoom, "10.0.0.1").Code)
+
+	// other clients aren't affected
+	assert.Equal(t, http.StatusOK, request(join, "10.0.0.2").Code)
+	assert.Equal(t, http.StatusOK, request(newRoom, "10.0.0.2").Code)
+}
+
+func TestRateLimitedActions(t *testing.T) {
+	cfg := ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute, Abuse: AbuseConfig{
+		Actions:        RateLimit{Burst: 2, Interval: time.Hour},
+		MaxBotsPerRoom: 1,
+	}}
+	g, s, cancel := NewServerWithConfig(cfg)
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	defer ws1.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+
+	for _, message := range []string{"uno", "dos"} {
+		assert.NoError(t, ws1.WriteJSON(Action[ActionChatData]{Type: ActionChat, Data: ActionChatData{Message: message}}))
+		assertRecieved[UpdateChatData](t, ws1, UpdateTypeChat)
+	}
+	assert.NoError(t, ws1.WriteJSON(Action[ActionChatData]{Type: ActionChat, Data: ActionChatData{Message: "tres"}}))
+	u := assertRecieved[UpdateErrorData](t, ws1, UpdateTypeError)
+	assertDataMatches(t, u, UpdateErrorData{Message: ErrRateLimited.Error()})
+	// numbered by the room like every other update
+	assert.Equal(t, 4, u.Seq)
+
+	// rooms only take up to MaxBotsPerRoom bots
+	room, exists := g.GetRoom(roomID)
+	assert.True(t, exists)
+	assert.NoError(t, room.AddConnection(NewBotConnection("bot1")))
+	assert.ErrorIs(t, room.AddConnection(NewBotConnection("bot2")), ErrTooManyBots)
+}
+
+func TestRestoreKeepsTimeouts(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	cfg := ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute, IdleTimeout: 500 * time.Millisecond, Store: store}
+	g, s, cancel := NewServerWithConfig(cfg)
+	timedOut, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	kept, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	for _, roomID := range []string{timedOut, kept} {
+		room, _ := g.GetRoom(roomID)
+		assert.NoError(t, room.AddConnection(NewBotConnection("bot1")))
+	}
+	cancel()
+	s.Close()
+
+	// the server is down for longer than the room timeout of one room and the idle timeout of both
+	time.Sleep(600 * time.Millisecond)
+	saved, err := store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, saved, 2)
+	for _, snapshot := range saved {
+		if snapshot.ID == timedOut {
+			snapshot.CreatedAt = snapshot.CreatedAt.Add(-10 * time.Minute)
+			assert.NoError(t, store.Save(snapshot))
+		}
+	}
+
+	observer := &recordingObserver{}
+	g, s, cancel = NewServerWithConfig(cfg)
+	defer cancel()
+	defer s.Close()
+	g.Observe(observer)
+	restored, err := g.Restore(slog.Default())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, restored)
+	assert.Eventually(t, func() bool { return len(observer.Events()) == 1 }, 200*time.Millisecond, 10*time.Millisecond)
+	assert.Equal(t, []string{"closed " + timedOut + ": timeout"}, observer.Events())
+
+	// the idle timeout starts over, so players have time to reconnect
+	room, _ := g.GetRoom(kept)
+	assert.False(t, room.HasClosed())
+	assert.Eventually(t, func() bool { return len(observer.Events()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "closed "+kept+": idle", observer.Events()[1])
+	assert.Eventually(t, func() bool {
+		saved, err := store.Load()
+		return err == nil && len(saved) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// blockingStore holds every save until unblock is closed.
+type blockingStore struct {
+	Store
+	unblock chan struct{}
+	saves   atomic.Int32
+}
+
+func (s *blockingStore) Save(snapshot RoomSnapshot) error {
+	<-s.unblock
+	s.saves.Add(1)
+	return s.Store.Save(snapshot)
+}
+
+func TestSlowStore(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	store := &blockingStore{Store: fileStore, unblock: make(chan struct{})}
+	g, s, cancel := NewServerWithConfig(ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute, Store: store})
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	defer ws1.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+
+	// the game goes on while the room is being saved
+	for _, message := range []string{"uno", "dos", "tres"} {
+		assert.NoError(t, ws1.WriteJSON(Action[ActionChatData]{Type: ActionChat, Data: ActionChatData{Message: message}}))
+		assertRecieved[UpdateChatData](t, ws1, UpdateTypeChat)
+	}
+	assert.Equal(t, int32(0), store.saves.Load())
+
+	// saves made while writing are merged, and the last one is written before the room stops
+	room, _ := g.GetRoom(roomID)
+	close(store.unblock)
+	cancel()
+	<-room.done
+	assert.Less(t, store.saves.Load(), int32(4))
+	saved, err := fileStore.Load()
+	assert.NoError(t, err)
+	assert.Len(t, saved, 1)
+	assert.Len(t, saved[0].ChatHistory, 3)
+}
+
+func TestRestoreRoom(t *testing.T) {
+	storeDir := t.TempDir()
+	store, err := NewFileStore(storeDir)
+	assert.NoError(t, err)
+	// the secret must be kept so session cookies are still valid after the restart
+	cfg := ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute, Store: store, SessionSecrets: [][]byte{[]byte("secret")}}
+	g, s, cancel := NewServerWithConfig(cfg)
+	deck := game.NewDeck()
+	roomID, err := g.NewRoom(slog.Default(), deck, 4, "secret")
+	assert.NoError(t, err)
+	ws1, res, err := websocket.DefaultDialer.Dial(joinURL(s, "p1", roomID)+"&password=secret", nil)
+	assert.NoError(t, err)
+	ws2 := NewSocket(s, "p2", roomID+"&password=secret")
+	both := []*websocket.Conn{ws1, ws2}
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	for _, ws := range both {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+	}
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+	for _, ws := range both {
+		assertRecieved[UpdatePlayerFirstPeekedData](t, ws, UpdateTypePlayerFirstPeeked)
+	}
+
+	// server goes down
+	ws1.Close()
+	ws2.Close()
+	cancel()
+	s.Close()
+	time.Sleep(100 * time.Millisecond)
+	saved, err := store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, saved, 1)
+	// passwords are only saved hashed
+	data, err := os.ReadFile(filepath.Join(storeDir, roomID+".json"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), `"secret"`)
+	assert.NotEmpty(t, saved[0].PasswordHash)
+
+	// and comes back with the room
+	g, s, cancel = NewServerWithConfig(cfg)
+	defer cancel()
+	defer s.Close()
+	restored, err := g.Restore(slog.Default())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, restored)
+	assert.True(t, roomPasswordMatches(g, roomID, "secret"))
+	assert.False(t, roomPasswordMatches(g, roomID, "Secret"))
+
+	header := http.Header{"Cookie": []string{res.Cookies()[0].String()}}
+	ws1, _, err = websocket.DefaultDialer.Dial(joinURL(s, "p1", roomID), header)
+	assert.NoError(t, err)
+	defer ws1.Close()
+	rejoin := assertRecieved[UpdateTypeRejoinData](t, ws1, UpdateTypeRejoin)
+	assert.Equal(t, []MarshalledPlayer{
+		{ID: "p1", CardsInHand: 4},
+		{ID: "p2", PendingFirstPeek: true, CardsInHand: 4},
+	}, rejoin.Data.Players)
+	assert.Equal(t, game.PlayerID("p1"), rejoin.Data.Leader)
+
+	// let the room stop before the store directory is removed
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestArchiveGame(t *testing.T) {
+	archive, err := NewFileArchive(t.TempDir())
+	assert.NoError(t, err)
+	g, s, cancel := NewServerWithConfig(ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute, Archive: archive})
+	defer cancel()
+	defer s.Close()
+	// every hand is worth 52 points, so the game ends after two cuts
+	deck := make(game.Deck, 0, 10)
+	for i := 0; i < 10; i++ {
+		deck = append(deck, game.Card{Suit: game.SuitClubs, Value: 13})
+	}
+	roomID, err := g.NewRoom(slog.Default(), deck, 2, "")
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws1.Close()
+	defer ws2.Close()
+	both := []*websocket.Conn{ws1, ws2}
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	for _, ws := range both {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+	}
+	for round, cutter := range both {
+		startUpdate := UpdateTypeGameStart
+		if round > 0 {
+			startUpdate = UpdateTypeStartNextRound
+		}
+		for _, ws := range both {
+			assertRecieved[UpdateStartNextRoundData](t, ws, startUpdate)
+		}
+		for _, peeker := range both {
+			assert.NoError(t, peeker.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+			for _, ws := range both {
+				assertRecieved[UpdatePlayerFirstPeekedData](t, ws, UpdateTypePlayerFirstPeeked)
+			}
+		}
+		for _, ws := range both {
+			assertRecieved[UpdateTurnData](t, ws, UpdateTypeTurn)
+		}
+		assert.NoError(t, cutter.WriteJSON(Action[ActionCutData]{Type: ActionCut}))
+		for _, ws := range both {
+			assertRecieved[UpdateCutData](t, ws, UpdateTypeCut)
+		}
+	}
+	for _, ws := range both {
+		assertRecieved[UpdateEndGameData](t, ws, UpdateTypeEndGame)
+	}
+
+	// the game is archived right after the end is sent
+	var list ArchiveList
+	assert.Eventually(t, func() bool {
+		res, err := http.Get(s.URL + "/archive?player=p2&from=" + time.Now().UTC().Format(time.DateOnly))
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+		return list.Total == 1
+	}, time.Second, 10*time.Millisecond)
+	summary := list.Games[0]
+	assert.Equal(t, roomID, summary.RoomID)
+	assert.Equal(t, []ArchivedSeat{{Player: "p1"}, {Player: "p2"}}, summary.Seats)
+	assert.Equal(t, game.PlayerID("p1"), summary.Winner)
+	assert.Equal(t, 2, summary.TotalRounds)
+
+	// filters leave out games that don't match
+	for _, query := range []string{"player=p3", "difficulty=easy", "to=2000-01-01"} {
+		res, err := http.Get(s.URL + "/archive?" + query)
+		assert.NoError(t, err)
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+		res.Body.Close()
+		assert.Equal(t, 0, list.Total, query)
+	}
+
+	res, err := http.Get(s.URL + "/archive/" + summary.ID)
+	assert.NoError(t, err)
+	var record GameRecord
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&record))
+	res.Body.Close()
+	assert.Len(t, record.Deals, 2)
+	assert.Len(t, record.Rounds, 2)
+	assert.Equal(t, EventKindUpdate, record.Events[0].Kind)
+	assert.Equal(t, string(UpdateTypeGameConfig), record.Events[0].Type)
+	last := record.Events[len(record.Events)-1]
+	assert.Equal(t, string(UpdateTypeEndGame), last.Type)
+	actions := 0
+	for _, event := range record.Events {
+		if event.Kind == EventKindAction {
+			actions++
+		}
+		// first peeks are only shown to the player peeking
+		if event.Type == string(UpdateTypePlayerFirstPeeked) && event.Player == "" {
+			assert.NotEmpty(t, event.Except)
+		}
+	}
+	// four first peeks and two cuts
+	assert.Equal(t, 6, actions)
+
+	res, err = http.Get(s.URL + "/archive/missing")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestReplay(t *testing.T) {
+	archive, err := NewFileArchive(t.TempDir())
+	assert.NoError(t, err)
+	_, s, cancel := NewServerWithConfig(ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute, Archive: archive})
+	defer cancel()
+	defer s.Close()
+
+	card := game.Card{Suit: game.SuitClubs, Value: 5}
+	start := time.Now()
+	event := func(offset time.Duration, player game.PlayerID, except game.PlayerID, update Update[UpdateDrawData]) ArchivedEvent {
+		message, err := json.Marshal(update)
+		assert.NoError(t, err)
+		return ArchivedEvent{Time: start.Add(offset), Kind: EventKindUpdate, Type: string(update.Type), Player: player, Except: except, Message: message}
+	}
+	hidden := Update[UpdateDrawData]{Type: UpdateTypeDraw, Data: UpdateDrawData{Player: "p1", Source: game.DrawSourcePile}}
+	shown := Update[UpdateDrawData]{Type: UpdateTypeDraw, Data: UpdateDrawData{Player: "p1", Source: game.DrawSourcePile, Card: card, Effect: card.GetEffect()}}
+	record := GameRecord{
+		ID:    "ABCD-1",
+		Seats: []ArchivedSeat{{Player: "p1"}, {Player: "p2"}},
+		Events: []ArchivedEvent{
+			{Time: start, Kind: EventKindAction, Type: string(ActionDraw), Player: "p1", Message: json.RawMessage(`{}`)},
+			event(0, "p1", "", shown),
+			event(0, "", "p1", hidden),
+			// gaps are capped and sped up
+			event(time.Hour, "p1", "", Update[UpdateDrawData]{Type: UpdateTypeTurn}),
+		},
+	}
+	assert.NoError(t, archive.Save(record))
+
+	replayURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/replay?game=ABCD-1&speed=16"
+	ws, _, err := websocket.DefaultDialer.Dial(replayURL+"&seat=p2", nil)
+	assert.NoError(t, err)
+	defer ws.Close()
+	u := assertRecieved[UpdateDrawData](t, ws, UpdateTypeDraw)
+	assertDataMatches(t, u, hidden.Data)
+	// the socket is closed once the replay finishes
+	_, _, err = ws.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+
+	ws, _, err = websocket.DefaultDialer.Dial(replayURL+"&seat=p1", nil)
+	assert.NoError(t, err)
+	defer ws.Close()
+	assertDataMatches(t, assertRecieved[UpdateDrawData](t, ws, UpdateTypeDraw), shown.Data)
+	assertDataMatches(t, assertRecieved[UpdateDrawData](t, ws, UpdateTypeTurn), UpdateDrawData{})
+
+	// omniscient replays use the copies with hidden cards
+	updates, err := ReplayUpdates(record, "p2", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []ArchivedEvent{record.Events[1]}, updates)
+
+	_, res, err := websocket.DefaultDialer.Dial(replayURL+"&seat=p3", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	_, res, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/replay?room=ABCD&seat=p1", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestShutdown(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	g, s, cancel := NewServerWithConfig(ServiceConfig{MaxRooms: 3, RoomTimeout: 5 * time.Minute, Store: store, RestartEstimate: time.Minute})
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws1.Close()
+	defer ws2.Close()
+	both := []*websocket.Conn{ws1, ws2}
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	before := time.Now()
+	assert.NoError(t, g.Shutdown(ctx))
+
+	// everyone is told before their socket is closed
+	for _, ws := range both {
+		u := assertRecieved[UpdateServerRestartingData](t, ws, UpdateTypeServerRestarting)
+		assert.WithinDuration(t, before.Add(time.Minute), u.Data.ReturnAt, time.Second)
+		_, _, err := ws.ReadMessage()
+		assert.Error(t, err)
+	}
+
+	// the room is kept to be restored
+	saved, err := store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, saved, 1)
+	assert.Len(t, saved[0].Seats, 2)
+
+	// new rooms and players are rejected
+	_, err = NewRoomBasic(g)
+	assert.ErrorIs(t, err, ErrServiceShuttingDown)
+	_, res, err := websocket.DefaultDialer.Dial(joinURL(s, "p3", roomID), nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, "60", res.Header.Get("Retry-After"))
+}
+
+func TestShutdownBusyRoom(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	busyID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	busy, _ := g.GetRoom(busyID)
+	room, _ := g.GetRoom(roomID)
+	unblock := make(chan struct{})
+	defer close(unblock)
+	blocked := make(chan struct{})
+	busy.schedule(0, func() {
+		close(blocked)
+		<-unblock
+	})
+	<-blocked
+
+	// a room that doesn't answer in time doesn't keep the others from stopping
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelShutdown()
+	err = g.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "error notifying room "+busyID)
+	assert.Error(t, room.Context.Err())
+	assert.Eventually(t, room.HasClosed, time.Second, 10*time.Millisecond)
+}
+
+func TestResumeUpdates(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	roomID, err := NewRoomBasic(g)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2, res, err := websocket.DefaultDialer.Dial(joinURL(s, "p2", roomID), nil)
+	assert.NoError(t, err)
+	defer ws1.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	u := assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+	assert.Equal(t, 1, u.Seq)
+
+	// p2 misses some messages while disconnected
+	ws2.Close()
+	time.Sleep(100 * time.Millisecond)
+	for _, message := range []string{"uno", "dos"} {
+		assert.NoError(t, ws1.WriteJSON(Action[ActionChatData]{Type: ActionChat, Data: ActionChatData{Message: message}}))
+		assertRecieved[UpdateChatData](t, ws1, UpdateTypeChat)
+	}
+
+	// and gets them back in order when reconnecting
+	header := http.Header{"Cookie": []string{res.Cookies()[0].String()}}
+	ws2, _, err = websocket.DefaultDialer.Dial(joinURL(s, "p2", roomID)+"&last_seq=1", header)
+	assert.NoError(t, err)
+	for ix, message := range []string{"uno", "dos"} {
+		u := assertRecieved[UpdateChatData](t, ws2, UpdateTypeChat)
+		assert.Equal(t, 2+ix, u.Seq)
+		assert.Equal(t, message, u.Data.Message)
+	}
+	ws2.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// unknown sequence numbers get the full state
+	ws2, _, err = websocket.DefaultDialer.Dial(joinURL(s, "p2", roomID)+"&last_seq=1000", header)
+	assert.NoError(t, err)
+	defer ws2.Close()
+	rejoin := assertRecieved[UpdateTypeRejoinData](t, ws2, UpdateTypeRejoin)
+	assert.Equal(t, 4, rejoin.Seq)
+	assert.Len(t, rejoin.Data.Chat, 2)
+}
+
+func TestUpdatesHistory(t *testing.T) {
+	conn := NewConnection("p1")
+	for i := 0; i < UPDATES_BUFFER_SIZE+10; i++ {
+		conn.SendUpdateOrDrop(Update[UpdateTurnData]{Type: UpdateTypeTurn})
+	}
+	missing, ok := conn.updatesSince(UPDATES_BUFFER_SIZE)
+	assert.True(t, ok)
+	assert.Len(t, missing, 10)
+	assert.Equal(t, UPDATES_BUFFER_SIZE+1, missing[0].GetSeq())
+	missing, ok = conn.updatesSince(UPDATES_BUFFER_SIZE + 10)
+	assert.True(t, ok)
+	assert.Empty(t, missing)
+	// the first updates are not kept anymore
+	_, ok = conn.updatesSince(5)
+	assert.False(t, ok)
+}
+
+func TestRejoinPhases(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	// every hand is worth 52 points, so the game ends after two cuts
+	deck := make(game.Deck, 0, 10)
+	for i := 0; i < 10; i++ {
+		deck = append(deck, game.Card{Suit: game.SuitClubs, Value: 13})
+	}
+	roomID, err := g.NewRoom(slog.Default(), deck, 2, "")
+	assert.NoError(t, err)
+	ws1, res1, err := websocket.DefaultDialer.Dial(joinURL(s, "p1", roomID), nil)
+	assert.NoError(t, err)
+	ws2, res2, err := websocket.DefaultDialer.Dial(joinURL(s, "p2", roomID), nil)
+	assert.NoError(t, err)
+	sockets := map[string]*websocket.Conn{"p1": ws1, "p2": ws2}
+	cookies := map[string]*http.Response{"p1": res1, "p2": res2}
+	defer func() {
+		for _, ws := range sockets {
+			ws.Close()
+		}
+	}()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	rejoin := func(player string) UpdateTypeRejoinData {
+		sockets[player].Close()
+		time.Sleep(100 * time.Millisecond)
+		header := http.Header{"Cookie": []string{cookies[player].Cookies()[0].String()}}
+		ws, _, err := websocket.DefaultDialer.Dial(joinURL(s, player, roomID), header)
+		assert.NoError(t, err)
+		sockets[player] = ws
+		return assertRecieved[UpdateTypeRejoinData](t, ws, UpdateTypeRejoin).Data
+	}
+	both := func(fn func(ws *websocket.Conn)) {
+		fn(sockets["p1"])
+		fn(sockets["p2"])
+	}
+
+	// lobby
+	data := rejoin("p2")
+	assert.Equal(t, RoomPhaseLobby, data.Phase)
+	assert.Equal(t, 2, data.Config.MaxPlayers)
+	assert.Empty(t, data.CurrentTurn)
+	assert.Nil(t, data.Countdown)
+
+	// first peek
+	assert.NoError(t, sockets["p1"].WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	both(func(ws *websocket.Conn) {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+	})
+	assert.NoError(t, sockets["p1"].WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+	both(func(ws *websocket.Conn) {
+		assertRecieved[UpdatePlayerFirstPeekedData](t, ws, UpdateTypePlayerFirstPeeked)
+	})
+	data = rejoin("p2")
+	assert.Equal(t, RoomPhaseFirstPeek, data.Phase)
+	assert.Equal(t, []MarshalledPlayer{
+		{ID: "p1", CardsInHand: 4},
+		{ID: "p2", PendingFirstPeek: true, CardsInHand: 4},
+	}, data.Players)
+	assert.Empty(t, data.KnownCards)
+	assert.Empty(t, data.CurrentTurn)
+
+	// turns, with the cards the player peeked
+	assert.NoError(t, sockets["p2"].WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+	both(func(ws *websocket.Conn) {
+		assertRecieved[UpdatePlayerFirstPeekedData](t, ws, UpdateTypePlayerFirstPeeked)
+		assertRecieved[UpdateTurnData](t, ws, UpdateTypeTurn)
+	})
+	data = rejoin("p1")
+	assert.Equal(t, RoomPhaseTurns, data.Phase)
+	assert.Equal(t, game.PlayerID("p1"), data.CurrentTurn)
+	king := game.Card{Suit: game.SuitClubs, Value: 13}
+	assert.Equal(t, []KnownCard{{Player: "p1", Position: 0, Card: king}, {Player: "p1", Position: 1, Card: king}}, data.KnownCards)
+	assert.Empty(t, data.Rounds)
+	assert.Nil(t, data.LastCut)
+
+	// cut screen, while the next round waits for the first peeks
+	assert.NoError(t, sockets["p1"].WriteJSON(Action[ActionCutData]{Type: ActionCut}))
+	both(func(ws *websocket.Conn) {
+		assertRecieved[UpdateCutData](t, ws, UpdateTypeCut)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeStartNextRound)
+	})
+	data = rejoin("p2")
+	assert.Equal(t, RoomPhaseFirstPeek, data.Phase)
+	assert.Equal(t, game.PlayerID("p1"), data.LastCut.Player)
+	assert.Len(t, data.LastCut.Hands, 2)
+	assert.Len(t, data.Rounds, 1)
+	assert.Equal(t, 72, data.Players[0].Points)
+
+	// end game summary
+	for _, peeker := range []string{"p1", "p2"} {
+		assert.NoError(t, sockets[peeker].WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+		both(func(ws *websocket.Conn) {
+			assertRecieved[UpdatePlayerFirstPeekedData](t, ws, UpdateTypePlayerFirstPeeked)
+		})
+	}
+	both(func(ws *websocket.Conn) { assertRecieved[UpdateTurnData](t, ws, UpdateTypeTurn) })
+	assert.NoError(t, sockets["p2"].WriteJSON(Action[ActionCutData]{Type: ActionCut}))
+	both(func(ws *websocket.Conn) {
+		assertRecieved[UpdateCutData](t, ws, UpdateTypeCut)
+		assertRecieved[UpdateEndGameData](t, ws, UpdateTypeEndGame)
+	})
+	data = rejoin("p1")
+	assert.Equal(t, RoomPhaseEnded, data.Phase)
+	assert.Len(t, data.Rounds, 2)
+	assert.Equal(t, game.PlayerID("p2"), data.LastCut.Player)
+	assert.Equal(t, 1, data.Series.Played)
+}
+
+func TestRejoinKnownCards(t *testing.T) {
+	for _, hardcore := range []bool{false, true} {
+		t.Run(fmt.Sprintf("hardcore=%t", hardcore), func(t *testing.T) {
+			g, s, cancel := NewServer()
+			defer cancel()
+			defer s.Close()
+			deck := make(game.Deck, 0, 12)
+			for i := 0; i < 12; i++ {
+				deck = append(deck, game.Card{Suit: game.SuitClubs, Value: i + 1})
+			}
+			// p1 draws a card that lets them peek at someone else's card
+			deck[9] = game.Card{Suit: game.SuitHearts, Value: 8}
+			roomID, err := g.NewRoomWithSettings(slog.Default(), deck, 2, "", RoomSettings{Hardcore: hardcore})
+			assert.NoError(t, err)
+			ws1, res, err := websocket.DefaultDialer.Dial(joinURL(s, "p1", roomID), nil)
+			assert.NoError(t, err)
+			ws2 := NewSocket(s, "p2", roomID)
+			defer ws2.Close()
+			assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+			assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+			assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+			assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+			for _, ws := range []*websocket.Conn{ws1, ws2} {
+				assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+				assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+			}
+			for _, peeker := range []*websocket.Conn{ws1, ws2} {
+				assert.NoError(t, peeker.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+				assertRecieved[UpdatePlayerFirstPeekedData](t, ws1, UpdateTypePlayerFirstPeeked)
+				assertRecieved[UpdatePlayerFirstPeekedData](t, ws2, UpdateTypePlayerFirstPeeked)
+			}
+			for _, ws := range []*websocket.Conn{ws1, ws2} {
+				assertRecieved[UpdateTurnData](t, ws, UpdateTypeTurn)
+			}
+
+			assert.NoError(t, ws1.WriteJSON(Action[ActionDrawData]{Type: ActionDraw, Data: ActionDrawData{Source: game.DrawSourcePile}}))
+			assert.NoError(t, ws1.WriteJSON(Action[ActionPeekCartaAjenaData]{
+				Type: ActionPeekCartaAjena,
+				Data: ActionPeekCartaAjenaData{Player: "p2", CardPosition: 2},
+			}))
+			for _, ws := range []*websocket.Conn{ws1, ws2} {
+				assertRecieved[UpdateDrawData](t, ws, UpdateTypeDraw)
+				assertRecieved[UpdatePeekCardData](t, ws, UpdateTypePeekCard)
+				assertRecieved[UpdateDiscardData](t, ws, UpdateTypeDiscard)
+				assertRecieved[UpdateTurnData](t, ws, UpdateTypeTurn)
+			}
+
+			ws1.Close()
+			time.Sleep(100 * time.Millisecond)
+			header := http.Header{"Cookie": []string{res.Cookies()[0].String()}}
+			ws1, _, err = websocket.DefaultDialer.Dial(joinURL(s, "p1", roomID), header)
+			assert.NoError(t, err)
+			defer ws1.Close()
+			data := assertRecieved[UpdateTypeRejoinData](t, ws1, UpdateTypeRejoin).Data
+			assert.Equal(t, hardcore, data.Config.Hardcore)
+			if hardcore {
+				assert.Empty(t, data.KnownCards)
+			} else {
+				assert.Equal(t, []KnownCard{
+					{Player: "p1", Position: 0, Card: deck[0]},
+					{Player: "p1", Position: 1, Card: deck[1]},
+					{Player: "p2", Position: 2, Card: deck[6]},
+				}, data.KnownCards)
+			}
+		})
+	}
+}
+
+type recordingObserver struct {
+	NopObserver
+	mu      sync.Mutex
+	events  []string
+	updates []UpdateEvent
+	rounds  []RoundEndedEvent
+}
+
+func (o *recordingObserver) record(event string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+}
+
+func (o *recordingObserver) Events() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Clone(o.events)
+}
+
+func (o *recordingObserver) RoomCreated(e RoomCreatedEvent) { o.record("created " + e.Room.ID) }
+func (o *recordingObserver) PlayerJoined(e PlayerJoinedEvent) {
+	o.record("joined " + string(e.Player))
+}
+func (o *recordingObserver) PlayerLeft(e PlayerLeftEvent) { o.record("left " + string(e.Player)) }
+func (o *recordingObserver) ActionAccepted(e ActionEvent) {
+	o.record("accepted " + string(e.Action.GetType()))
+}
+func (o *recordingObserver) ActionRejected(e ActionEvent) {
+	o.record("rejected " + string(e.Action.GetType()) + ": " + e.Err.Error())
+}
+func (o *recordingObserver) UpdateEmitted(e UpdateEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.updates = append(o.updates, e)
+}
+func (o *recordingObserver) RoundEnded(e RoundEndedEvent) {
+	o.mu.Lock()
+	o.rounds = append(o.rounds, e)
+	o.mu.Unlock()
+	o.record("round ended")
+}
+func (o *recordingObserver) RoomClosed(e RoomClosedEvent) {
+	if e.Reason == "" {
+		o.record("closed " + e.RoomID)
+		return
+	}
+	o.record("closed " + e.RoomID + ": " + string(e.Reason))
+}
+
+func TestRoomObserver(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	observer := &recordingObserver{}
+	g.Observe(observer)
+	deck := make(game.Deck, 0, 10)
+	for i := 0; i < 10; i++ {
+		deck = append(deck, game.Card{Suit: game.SuitClubs, Value: i + 1})
+	}
+	roomID, err := g.NewRoom(slog.Default(), deck, 3, "")
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	defer ws1.Close()
+	ws2 := NewSocket(s, "p2", roomID)
+	defer ws2.Close()
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+	ws3 := NewSocket(s, "p3", roomID)
+	defer ws3.Close()
+	assert.NoError(t, ws3.WriteJSON(Action[ActionWithoutData]{Type: ActionLeave}))
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+		assertRecieved[UpdatePlayersChangedData](t, ws, UpdateTypePlayersChanged)
+	}
+
+	assert.NoError(t, ws2.WriteJSON(Action[ActionDrawData]{Type: ActionDraw, Data: ActionDrawData{Source: game.DrawSourcePile}}))
+	assertRecieved[UpdateErrorData](t, ws2, UpdateTypeError)
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+	}
+	for _, peeker := range []*websocket.Conn{ws1, ws2} {
+		assert.NoError(t, peeker.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+		assertRecieved[UpdatePlayerFirstPeekedData](t, ws1, UpdateTypePlayerFirstPeeked)
+		assertRecieved[UpdatePlayerFirstPeekedData](t, ws2, UpdateTypePlayerFirstPeeked)
+	}
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		assertRecieved[UpdateTurnData](t, ws, UpdateTypeTurn)
+	}
+	assert.NoError(t, ws1.WriteJSON(Action[ActionCutData]{Type: ActionCut}))
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		assertRecieved[UpdateCutData](t, ws, UpdateTypeCut)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeStartNextRound)
+	}
+	cancel()
+
+	assert.Eventually(t, func() bool { return len(observer.Events()) == 13 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{
+		"created " + roomID,
+		"joined p1",
+		"joined p2",
+		"joined p3",
+		"left p3",
+		"accepted leave",
+		"rejected draw: " + ErrNotYourTurn.Error(),
+		"accepted start",
+		"accepted first_peek",
+		"accepted first_peek",
+		"round ended",
+		"accepted cut",
+		"closed " + roomID,
+	}, observer.Events())
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	// observers get the hands of every player, not only what was revealed to them
+	assert.Len(t, observer.rounds, 1)
+	assert.Equal(t, []game.Card(deck[:4]), observer.rounds[0].Cut.Hands[0])
+	ix := slices.IndexFunc(observer.updates, func(e UpdateEvent) bool {
+		return e.Player == "p1" && e.Update.GetType() == UpdateTypePlayerFirstPeeked
+	})
+	assert.NotEqual(t, -1, ix)
+	peeked, ok := observer.updates[ix].Update.(Update[UpdatePlayerFirstPeekedData])
+	assert.True(t, ok)
+	assert.Equal(t, []game.Card(deck[:2]), peeked.Data.Cards)
+}
+
+// TestRoomConcurrency joins players, sends actions, reads the room and closes it all at the same time.
+// Meant to be run with the race detector.
+func TestRoomConcurrency(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		room := NewRoomWithDeck(slog.Default(), ctx, cancel, "RACE", game.NewDeck(), 4)
+		go room.Start()
+
+		var wg sync.WaitGroup
+		for p := 0; p < 6; p++ {
+			conn := NewConnection(game.PlayerID(fmt.Sprintf("p%d", p)))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := room.AddConnection(conn); err != nil {
+					return
+				}
+				actions := []TypedAction{
+					&Action[ActionReadyData]{Type: ActionReady, Data: ActionReadyData{Ready: true}},
+					&Action[ActionChatData]{Type: ActionChat, Data: ActionChatData{Message: "hi"}},
+					&Action[ActionWithoutData]{Type: ActionStart},
+					&Action[ActionWithoutData]{Type: ActionFirstPeek},
+				}
+				for _, action := range actions {
+					action.SetPlayerID(conn.ID)
+					select {
+					case conn.Actions <- action:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !room.HasClosed() {
+				room.Info()
+				room.Winner()
+				room.GetConnection("p0")
+				room.Recording()
+				room.CurrentPlayers()
+			}
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * time.Millisecond)
+			cancel()
+		}()
+
+		finished := make(chan struct{})
+		go func() {
+			wg.Wait()
+			<-room.done
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("room didn't stop, iteration %d", i)
+		}
+		assert.True(t, room.HasClosed())
+		assert.ErrorIs(t, room.AddConnection(NewConnection("late")), ErrRoomClosed)
+		_, recording := room.Recording()
+		assert.False(t, recording)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(2, time.Second)
+	assert.True(t, bucket.Allow(now))
+	assert.True(t, bucket.Allow(now))
+	assert.False(t, bucket.Allow(now))
+	assert.False(t, bucket.Allow(now.Add(500*time.Millisecond)))
+	assert.True(t, bucket.Allow(now.Add(time.Second)))
+	assert.True(t, bucket.Allow(now.Add(10*time.Second)))
+	assert.True(t, bucket.Allow(now.Add(10*time.Second)))
+	assert.False(t, bucket.Allow(now.Add(10*time.Second)))
+}
+
+func TestTakeover(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	type takeover struct {
+		conn  *Connection
+		state TakeoverState
+	}
+	takeovers := make(chan takeover, 1)
+	stopped := make(chan struct{})
+	g.cfg.Takeover = func(ctx context.Context, logger *slog.Logger, conn *Connection, difficulty string, state TakeoverState) error {
+		conn.Attach(func() { close(stopped) })
+		takeovers <- takeover{conn, state}
+		return nil
+	}
+	deck := game.NewDeck()
+	settings := RoomSettings{TakeoverDifficulty: "hard", TakeoverGracePeriod: 500 * time.Millisecond}
+	roomID, err := g.NewRoomWithSettings(slog.Default(), deck, 4, "", settings)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	ws2, res, err := websocket.DefaultDialer.Dial(joinURL(s, "p2", roomID), nil)
+	assert.NoError(t, err)
+	defer ws1.Close()
+
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+	}
+	assert.NoError(t, ws2.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+	assertRecieved[UpdatePlayerFirstPeekedData](t, ws1, UpdateTypePlayerFirstPeeked)
+	assertRecieved[UpdatePlayerFirstPeekedData](t, ws2, UpdateTypePlayerFirstPeeked)
+
+	// p2 drops before p1 peeks and a bot takes the seat after the grace period
+	ws2.Close()
+	tk := <-takeovers
+	assert.Equal(t, game.PlayerID("p2"), tk.conn.ID)
+	assert.Equal(t, []game.Card{deck[4], deck[5], {}, {}}, tk.state.Hand)
+	assert.Len(t, tk.state.Players, 2)
+	assertDataMatches(t, assertRecieved[UpdateTakeoverData](t, ws1, UpdateTypeTakeoverStarted), UpdateTakeoverData{Player: "p2", Difficulty: "hard"})
+
+	// p2 comes back and gets the seat
+	header := http.Header{"Cookie": []string{res.Cookies()[0].String()}}
+	ws2, _, err = websocket.DefaultDialer.Dial(joinURL(s, "p2", roomID), header)
+	assert.NoError(t, err)
+	defer ws2.Close()
+	<-stopped
+	assertDataMatches(t, assertRecieved[UpdateTakeoverData](t, ws1, UpdateTypeTakeoverEnded), UpdateTakeoverData{Player: "p2"})
+	assertRecieved[UpdateTakeoverData](t, ws2, UpdateTypeTakeoverEnded)
+	assertRecieved[UpdateTypeRejoinData](t, ws2, UpdateTypeRejoin)
+}
+
+func TestAdminReplacesStuckTakeover(t *testing.T) {
+	g, s, cancel := NewServer()
+	defer cancel()
+	defer s.Close()
+	takeovers := make(chan *Connection, 2)
+	stopped := make(chan struct{})
+	g.cfg.Takeover = func(ctx context.Context, logger *slog.Logger, conn *Connection, difficulty string, state TakeoverState) error {
+		if len(takeovers) == 0 {
+			// the first bot gets stuck without playing
+			conn.Attach(func() { close(stopped) })
+		} else {
+			select {
+			case <-stopped:
+			default:
+				t.Error("replacement started before the stuck bot stopped")
+			}
+			conn.Attach(func() {})
+			go conn.QueueAction(&Action[ActionWithoutData]{Type: ActionFirstPeek})
+		}
+		takeovers <- conn
+		return nil
+	}
+	deck := game.NewDeck()
+	settings := RoomSettings{TakeoverDifficulty: "hard", TakeoverGracePeriod: 100 * time.Millisecond}
+	roomID, err := g.NewRoomWithSettings(slog.Default(), deck, 4, "", settings)
+	assert.NoError(t, err)
+	ws1 := NewSocket(s, "p1", roomID)
+	defer ws1.Close()
+	ws2 := NewSocket(s, "p2", roomID)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws1, UpdateTypePlayersChanged)
+	assertRecieved[UpdatePlayersChangedData](t, ws2, UpdateTypePlayersChanged)
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionStart}))
+	for _, ws := range []*websocket.Conn{ws1, ws2} {
+		assertRecieved[UpdateGameConfig](t, ws, UpdateTypeGameConfig)
+		assertRecieved[UpdateStartNextRoundData](t, ws, UpdateTypeGameStart)
+	}
+	ws2.Close()
+	assertDataMatches(t, assertRecieved[UpdateTakeoverData](t, ws1, UpdateTypeTakeoverStarted), UpdateTakeoverData{Player: "p2", Difficulty: "hard"})
+
+	// the stuck bot is replaced by one that plays the seat
+	assert.NoError(t, g.AdminRemoveBot(roomID, "p2"))
+	<-stopped
+	assert.Len(t, takeovers, 2)
+	assertDataMatches(t, assertRecieved[UpdateTakeoverData](t, ws1, UpdateTypeTakeoverStarted), UpdateTakeoverData{Player: "p2", Difficulty: "hard"})
+	assert.Equal(t, game.PlayerID("p2"), assertRecieved[UpdatePlayerFirstPeekedData](t, ws1, UpdateTypePlayerFirstPeeked).Data.Player)
+	assert.NoError(t, ws1.WriteJSON(Action[ActionWithoutData]{Type: ActionFirstPeek}))
+	assertRecieved[UpdatePlayerFirstPeekedData](t, ws1, UpdateTypePlayerFirstPeeked)
+	assertDataMatches(t, assertRecieved[UpdateTurnData](t, ws1, UpdateTypeTurn), UpdateTurnData{Player: "p1"})
+}
+
+func TestCardMemory(t *testing.T) {
+	c := func(v int) game.Card { return game.Card{Suit: game.SuitClubs, Value: v} }
+	m := make(cardMemory)
+	m.see("p1", "p1", 0, c(1))
+	m.see("p1", "p1", 1, c(2))
+	m.see("p1", "p2", 3, c(3))
+	m.swap("p1", 0, "p2", 3)
+	assert.Equal(t, []KnownCard{{"p1", 0, c(3)}, {"p1", 1, c(2)}, {"p2", 3, c(1)}}, m.known("p1"))
+	m.removePosition("p1", 0)
+	assert.Equal(t, []game.Card{c(2), {}, {}}, m.knownHand("p1", 3))
+	m.forget("p2", 3)
+	m.removePlayer("p1")
+	assert.Equal(t, []KnownCard{}, m.known("p1"))
+}
+
 func assertRecieved[D UpdateData](t *testing.T, ws *websocket.Conn, updateType UpdateType) Update[D] {
 	var temp Update[D]
 	_, message, err := ws.ReadMessage()
@@ -0,0 +1,62 @@
+package tincho
+
+import (
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+type RoomStatus string
+
+const (
+	RoomStatusWaiting  RoomStatus = "waiting"
+	RoomStatusPlaying  RoomStatus = "playing"
+	RoomStatusFinished RoomStatus = "finished"
+)
+
+// RoomInfo is a public snapshot of a room used to list rooms.
+type RoomInfo struct {
+	ID          string        `json:"id"`
+	Players     int           `json:"players"`
+	MaxPlayers  int           `json:"max_players"`
+	Private     bool          `json:"private"`
+	Status      RoomStatus    `json:"status"`
+	Leader      game.PlayerID `json:"leader"`
+	DeckOptions DeckOptions   `json:"deck"`
+	CardsInDeck int           `json:"cards_in_deck"`
+	Rules       game.Rules    `json:"rules"`
+	Spectators  int           `json:"spectators"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+// Joinable reports whether a new player could join the room.
+func (i RoomInfo) Joinable() bool {
+	return i.Status != RoomStatusPlaying && i.Players < i.MaxPlayers
+}
+
+// Info returns the snapshot of the room as of the last published view.
+func (r *Room) Info() RoomInfo {
+	return r.published().info
+}
+
+func (r *Room) info() RoomInfo {
+	status := RoomStatusWaiting
+	if r.state.Playing() {
+		status = RoomStatusPlaying
+	} else if r.series.played > 0 {
+		status = RoomStatusFinished
+	}
+	return RoomInfo{
+		ID:          r.ID,
+		Players:     len(r.state.GetPlayers()),
+		MaxPlayers:  r.maxPlayers,
+		Private:     r.passwordHash != "",
+		Status:      status,
+		Leader:      r.leader,
+		DeckOptions: r.settings.DeckOptions,
+		CardsInDeck: r.state.CountBaseDeck(),
+		Rules:       r.state.Rules(),
+		Spectators:  len(r.spectators),
+		CreatedAt:   r.createdAt,
+	}
+}
@@ -0,0 +1,167 @@
+package tincho
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// max games a series can be configured to last
+const MAX_SERIES_LENGTH = 9
+
+var ErrInvalidSeriesLength = fmt.Errorf("series length should be between 0 and %d", MAX_SERIES_LENGTH)
+var ErrSeriesInProgress = errors.New("series already in progress")
+var ErrNoGameToRematch = errors.New("no game played to rematch")
+
+// series keeps the scoreboard of consecutive games played in the same room.
+type series struct {
+	// best-of-N games, 0 means games are not part of a series
+	length int
+	played int
+	wins   map[game.PlayerID]int
+}
+
+func newSeries(length int) series {
+	return series{length: length, wins: make(map[game.PlayerID]int)}
+}
+
+func (s *series) record(winner game.PlayerID) {
+	s.played++
+	s.wins[winner]++
+}
+
+// winner returns the player that can't be caught anymore, if any.
+func (s *series) winner() (game.PlayerID, bool) {
+	if s.length == 0 {
+		return "", false
+	}
+	for playerID, wins := range s.wins {
+		if wins > s.length/2 {
+			return playerID, true
+		}
+	}
+	return "", false
+}
+
+func (s *series) finished() bool {
+	if _, ok := s.winner(); ok {
+		return true
+	}
+	return s.length > 0 && s.played >= s.length
+}
+
+func (s *series) data() UpdateSeriesData {
+	winner, _ := s.winner()
+	wins := make(map[game.PlayerID]int, len(s.wins))
+	for playerID, w := range s.wins {
+		wins[playerID] = w
+	}
+	return UpdateSeriesData{
+		Length:   s.length,
+		Played:   s.played,
+		Wins:     wins,
+		Finished: s.finished(),
+		Winner:   winner,
+	}
+}
+
+// endGame records the finished game in the series and takes the room back to the lobby,
+// keeping the same seats for a rematch.
+func (r *Room) endGame() error {
+	winner, err := r.state.Winner()
+	if err != nil {
+		return fmt.Errorf("Winner: %w", err)
+	}
+	r.series.record(winner.ID)
+	r.lastRounds = r.state.RoundHistory()
+	r.state.Reset()
+	r.memory.reset()
+	for playerID := range r.rematchVotes {
+		delete(r.rematchVotes, playerID)
+	}
+	r.broadcastSeriesChanged()
+	r.broadcastPlayersChanged()
+	return nil
+}
+
+func (r *Room) doSetSeries(action Action[ActionSetSeriesData]) error {
+	if !r.isLeader(action.PlayerID) {
+		return ErrNotRoomLeader
+	}
+	if action.Data.Games < 0 || action.Data.Games > MAX_SERIES_LENGTH {
+		return ErrInvalidSeriesLength
+	}
+	if r.state.Playing() || (r.series.played > 0 && !r.series.finished()) {
+		return ErrSeriesInProgress
+	}
+	r.series = newSeries(action.Data.Games)
+	r.broadcastSeriesChanged()
+	return nil
+}
+
+// doRematch only takes votes once a game ended, before that the game is started by the leader
+// or the ready countdown.
+func (r *Room) doRematch(action Action[ActionRematchData]) error {
+	if r.state.Playing() {
+		return game.ErrGameAlreadyStarted
+	}
+	if r.lastRounds == nil && r.series.played == 0 {
+		return ErrNoGameToRematch
+	}
+	if action.Data.Vote {
+		r.rematchVotes[action.PlayerID] = true
+	} else {
+		delete(r.rematchVotes, action.PlayerID)
+	}
+	r.broadcastRematchVotes()
+	if !r.rematchAgreed() {
+		return nil
+	}
+	if r.series.finished() {
+		r.series = newSeries(r.series.length)
+		r.broadcastSeriesChanged()
+	}
+	return r.startGame()
+}
+
+// rematchAgreed checks if every connected human voted for a rematch.
+// Bots and seats played by bots don't need to vote.
+func (r *Room) rematchAgreed() bool {
+	votes := 0
+	for _, p := range r.state.GetPlayers() {
+		conn, ok := r.connections[p.ID]
+		if !ok || conn.Bot || r.takenOver[p.ID] || !r.isConnected(p.ID) {
+			continue
+		}
+		if !r.rematchVotes[p.ID] {
+			return false
+		}
+		votes++
+	}
+	return votes > 0
+}
+
+func (r *Room) rematchVoters() []game.PlayerID {
+	voters := make([]game.PlayerID, 0, len(r.rematchVotes))
+	for playerID := range r.rematchVotes {
+		voters = append(voters, playerID)
+	}
+	slices.Sort(voters)
+	return voters
+}
+
+func (r *Room) broadcastSeriesChanged() {
+	r.BroadcastUpdate(Update[UpdateSeriesData]{
+		Type: UpdateTypeSeriesChanged,
+		Data: r.series.data(),
+	})
+}
+
+func (r *Room) broadcastRematchVotes() {
+	r.BroadcastUpdate(Update[UpdateRematchVotesData]{
+		Type: UpdateTypeRematchVotes,
+		Data: UpdateRematchVotesData{Votes: r.rematchVoters()},
+	})
+}
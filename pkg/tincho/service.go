@@ -2,10 +2,14 @@ package tincho
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"log/slog"
-	"math/rand"
+	"math/big"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/manuelpepe/tincho/pkg/game"
@@ -15,78 +19,198 @@ const ROOM_ID_LENGTH = 4
 
 var ErrRoomNotFound = errors.New("room not found")
 var ErrRoomsLimitReached = errors.New("rooms limit reached")
+var ErrClientRoomsLimitReached = errors.New("rooms limit reached for client")
 
 type ServiceConfig struct {
-	MaxRooms    int
-	RoomTimeout time.Duration
+	MaxRooms int
+	// rooms a single client can have open at the same time, 0 disables the limit
+	MaxRoomsPerClient int
+	RoomTimeout       time.Duration
+	// time without players joining or playing before a room is closed, 0 disables it
+	IdleTimeout time.Duration
+	// max players a room can hold, 0 means MAX_PLAYERS
+	MaxPlayers int
+	// turn timer of rooms created without one, 0 leaves them without a timer
+	DefaultTurnTimer time.Duration
+	// starts bots on the seats of disconnected players, nil disables takeovers
+	Takeover TakeoverFunc
+	// persists rooms to restore them after a restart, nil disables persistence
+	Store Store
+	// keeps a record of every finished game, nil disables the archive
+	Archive Archive
+	// time the server is expected to be down when restarting, sent to players on shutdown
+	RestartEstimate time.Duration
+	// secrets used to sign session cookies, the first one signs new cookies and the rest are only
+	// accepted to verify cookies signed before rotating it. A random secret is used if empty.
+	SessionSecrets [][]byte
+	// origins allowed to open websockets, like https://example.com, or "*" to allow any origin.
+	// Only the origin of the server is allowed if empty.
+	AllowedOrigins []string
+	// rate limits, bans and caps to protect against abuse, nothing is limited if empty
+	Abuse AbuseConfig
+	// bearer token required by the admin API, the API is disabled if empty
+	AdminToken string
 }
 
 // Service is the object keeping state of all games.
-// Contains a map of rooms, where the key is the room ID.
+// Contains a registry of rooms, where the key is the room ID.
 type Service struct {
-	context   context.Context
-	rooms     []*Room
-	passwords map[string]string
-	cfg       ServiceConfig
+	context context.Context
+	// stops all rooms without closing them, so they are kept in the store
+	cancel   context.CancelFunc
+	draining *atomic.Bool
+	rooms    *roomRegistry
+	cfg      ServiceConfig
+	sessions *sessionKeys
+	invites  *invites
+	limiter  *abuseLimiter
+	// shared with every room
+	observers *observers
 }
 
 func NewService(ctx context.Context, cfg ServiceConfig) Service {
+	ctx, cancel := context.WithCancel(ctx)
 	return Service{
 		context:   ctx,
-		rooms:     make([]*Room, 0, cfg.MaxRooms),
-		passwords: make(map[string]string),
+		cancel:    cancel,
+		draining:  &atomic.Bool{},
+		rooms:     newRoomRegistry(),
 		cfg:       cfg,
+		sessions:  newSessionKeys(cfg.SessionSecrets...),
+		invites:   newInvites(),
+		limiter:   newAbuseLimiter(cfg.Abuse),
+		observers: &observers{},
 	}
 }
 
 func (g *Service) NewRoom(logger *slog.Logger, deck game.Deck, maxPlayers int, password string) (string, error) {
+	return g.NewRoomWithSettings(logger, deck, maxPlayers, password, DefaultRoomSettings())
+}
+
+func (g *Service) NewRoomWithSettings(logger *slog.Logger, deck game.Deck, maxPlayers int, password string, settings RoomSettings) (string, error) {
+	return g.NewRoomForClient(logger, "", deck, maxPlayers, password, settings)
+}
+
+// NewRoomForClient creates a room counting it for the rooms limit of the client, usually its IP.
+// An empty client only counts for the global limit.
+func (g *Service) NewRoomForClient(logger *slog.Logger, client string, deck game.Deck, maxPlayers int, password string, settings RoomSettings) (string, error) {
+	if g.Draining() {
+		return "", ErrServiceShuttingDown
+	}
 	if maxPlayers <= 0 {
 		return "", fmt.Errorf("max players should be greater than 0, got %d", maxPlayers)
 	}
-	if g.ActiveRoomCount() >= g.cfg.MaxRooms {
-		return "", ErrRoomsLimitReached
+	if g.cfg.MaxPlayers > 0 && maxPlayers > g.cfg.MaxPlayers {
+		return "", fmt.Errorf("%w: %d", ErrMaxPlayersAboveLimit, g.cfg.MaxPlayers)
 	}
-	ctx, cancel := context.WithTimeout(g.context, g.cfg.RoomTimeout)
-	roomID := g.getUnusedID()
-	roomLogger := logger.With("room_id", roomID, "component", "room")
-	room := NewRoomWithDeck(roomLogger, ctx, cancel, roomID, deck, maxPlayers)
-	g.rooms = append(g.rooms, &room)
-	if password != "" {
-		g.passwords[roomID] = password
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return "", err
 	}
+	if err := g.rooms.reserve(client, g.cfg.MaxRooms, g.cfg.MaxRoomsPerClient); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithCancel(g.context)
+	var room *Room
+	for {
+		roomID := generateRandomString(ROOM_ID_LENGTH)
+		roomLogger := logger.With("room_id", roomID, "component", "room")
+		room = NewRoomWithSettings(roomLogger, ctx, cancel, roomID, deck, maxPlayers, settings)
+		room.takeover = g.cfg.Takeover
+		room.passwordHash = passwordHash
+		room.store = g.cfg.Store
+		room.archive = g.cfg.Archive
+		room.observers = g.observers
+		room.maxBots = g.cfg.Abuse.MaxBotsPerRoom
+		room.playersLimit = g.cfg.MaxPlayers
+		room.timeout = g.cfg.RoomTimeout
+		room.idleTimeout = g.cfg.IdleTimeout
+		// not started yet, so the view can be published from here
+		room.publish()
+		// the ID can be taken by another room created at the same time, so it's checked when adding it
+		if g.rooms.add(room, client) {
+			break
+		}
+	}
+	event := RoomCreatedEvent{Time: time.Now(), Room: room.Info(), Settings: settings}
+	g.observers.notify(func(o RoomObserver) { o.RoomCreated(event) })
 	go room.Start()
+	go g.reap(room)
 	return room.ID, nil
 }
 
-func (g *Service) getRoomIndex(roomID string) (int, bool) {
-	for idx, room := range g.rooms {
-		if room != nil && room.ID == roomID {
-			return idx, true
+// Restore loads the rooms saved in the store and starts them with all their players disconnected,
+// so they can reconnect with their session tokens. Returns the number of restored rooms.
+func (g *Service) Restore(logger *slog.Logger) (int, error) {
+	if g.cfg.Store == nil {
+		return 0, nil
+	}
+	snapshots, err := g.cfg.Store.Load()
+	if err != nil {
+		logger.Error("error loading some rooms", "err", err)
+	}
+	restored := 0
+	for _, snapshot := range snapshots {
+		if _, exists := g.rooms.get(snapshot.ID); exists {
+			continue
 		}
+		if err := g.rooms.reserve("", g.cfg.MaxRooms, g.cfg.MaxRoomsPerClient); err != nil {
+			return restored, err
+		}
+		ctx, cancel := context.WithCancel(g.context)
+		roomLogger := logger.With("room_id", snapshot.ID, "component", "room")
+		room := NewRoomFromSnapshot(roomLogger, ctx, cancel, snapshot)
+		room.takeover = g.cfg.Takeover
+		room.store = g.cfg.Store
+		room.archive = g.cfg.Archive
+		room.observers = g.observers
+		room.maxBots = g.cfg.Abuse.MaxBotsPerRoom
+		room.playersLimit = g.cfg.MaxPlayers
+		room.timeout = g.cfg.RoomTimeout
+		room.idleTimeout = g.cfg.IdleTimeout
+		if !g.rooms.add(room, "") {
+			g.rooms.release("")
+			cancel()
+			continue
+		}
+		go room.Start()
+		go g.reap(room)
+		room.resume()
+		restored++
 	}
-	return 0, false
+	return restored, nil
 }
 
-func (g *Service) GetRoom(roomID string) (*Room, bool) {
-	roomix, exists := g.getRoomIndex(roomID)
-	if !exists {
-		return nil, false
-	}
-	return g.rooms[roomix], true
+// Archive returns the archive of finished games, nil if it's disabled.
+func (g *Service) Archive() Archive {
+	return g.cfg.Archive
 }
 
-func (g *Service) GetRoomPassword(roomID string) string {
-	return g.passwords[roomID]
+// reap removes the room from the registry once it closes, freeing its slot.
+func (g *Service) reap(room *Room) {
+	<-room.done
+	g.rooms.remove(room.ID)
+}
+
+func (g *Service) GetRoom(roomID string) (*Room, bool) {
+	return g.rooms.get(roomID)
 }
 
 func (g *Service) JoinRoom(roomID string, conn *Connection, password string) error {
-	if pass, exists := g.passwords[roomID]; exists && pass != password {
-		return fmt.Errorf("invalid password")
+	room, exists := g.GetRoom(roomID)
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+	}
+	if err := room.checkPassword(password); err != nil {
+		return err
 	}
 	return g.JoinRoomWithoutPassword(roomID, conn)
 }
 
 func (g *Service) JoinRoomWithoutPassword(roomID string, conn *Connection) error {
+	if g.Draining() {
+		return ErrServiceShuttingDown
+	}
 	room, exists := g.GetRoom(roomID)
 	if !exists {
 		return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
@@ -97,47 +221,60 @@ func (g *Service) JoinRoomWithoutPassword(roomID string, conn *Connection) error
 	return nil
 }
 
-func (g *Service) ActiveRoomCount() int {
-	g.ClearClosedRooms()
-	return len(g.rooms)
+// ResumeRoom reconnects a player to a room, sending the updates after lastSeq they missed.
+func (g *Service) ResumeRoom(roomID string, conn *Connection, lastSeq int) error {
+	if g.Draining() {
+		return ErrServiceShuttingDown
+	}
+	room, exists := g.GetRoom(roomID)
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+	}
+	return room.ResumeConnection(conn, lastSeq)
 }
 
-func (g *Service) ClearClosedRooms() {
-	toRemove := make([]int, 0)
-	for idx, room := range g.rooms {
-		if room != nil && room.HasClosed() {
-			toRemove = append([]int{idx}, toRemove...)
-		}
-	}
-	for _, idx := range toRemove {
-		delete(g.passwords, g.rooms[idx].ID)
-		g.rooms = unordered_remove(g.rooms, idx)
+// ListRooms returns a snapshot of every open room, oldest first.
+func (g *Service) ListRooms() []RoomInfo {
+	rooms := g.openRooms()
+	infos := make([]RoomInfo, 0, len(rooms))
+	for _, room := range rooms {
+		infos = append(infos, room.Info())
 	}
+	slices.SortFunc(infos, func(a, b RoomInfo) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return infos
 }
 
-// unordered_remove removes the element at index i from a slice changing its length
-// and without preserving the order of the elements.
-func unordered_remove[T any](a []T, i int) []T {
-	a[i] = a[len(a)-1]
-	a = a[:len(a)-1]
-	return a
+// openRooms returns the rooms that haven't closed yet.
+func (g *Service) openRooms() []*Room {
+	rooms := g.rooms.all()
+	return slices.DeleteFunc(rooms, (*Room).HasClosed)
 }
 
-func (g *Service) getUnusedID() string {
-	roomID := generateRandomString(ROOM_ID_LENGTH)
-	for exists := true; exists; _, exists = g.getRoomIndex(roomID) {
-		roomID = generateRandomString(ROOM_ID_LENGTH)
-	}
-	return roomID
+// ActiveRoomCount returns the number of rooms counting for the rooms limit.
+// Closed rooms stop counting once they are reaped.
+func (g *Service) ActiveRoomCount() int {
+	return g.rooms.count()
 }
 
 // Function to generate a random string with a given length
 func generateRandomString(length int) string {
-	chars := "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	rand.Seed(time.Now().UnixNano())
+	return randomString("ABCDEFGHIJKLMNOPQRSTUVWXYZ", length)
+}
+
+// randomString generates a random string with a given length using only the given chars.
+func randomString(chars string, length int) string {
 	result := make([]byte, length)
 	for i := range result {
-		result[i] = chars[rand.Intn(len(chars))]
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			panic(fmt.Errorf("error generating random string: %w", err))
+		}
+		result[i] = chars[n.Int64()]
 	}
 	return string(result)
 }
@@ -0,0 +1,203 @@
+package tincho
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+const TOKEN_COOKIE_NAME = "session_token"
+
+// time a session cookie is valid for, renewed every time the player reconnects
+const SESSION_DURATION = 24 * time.Hour
+
+// secrets kept to verify cookies signed before a rotation, including the current one
+const MAX_SESSION_SECRETS = 3
+
+const SESSION_TOKEN_BYTES = 32
+
+var ErrInvalidCookie = errors.New("invalid token")
+var ErrExpiredCookie = errors.New("expired token")
+
+// session is the content of the session cookie, linking a browser to a seat in a room.
+type session struct {
+	Player  game.PlayerID `json:"player"`
+	Room    string        `json:"room"`
+	Token   string        `json:"token"`
+	Expires int64         `json:"expires"`
+}
+
+// sessionKeys signs and verifies session cookies with HMAC-SHA256.
+// Cookies are always signed with the newest secret, but the previous ones are still accepted
+// so rotating the secret doesn't log everyone out.
+type sessionKeys struct {
+	mu      sync.RWMutex
+	secrets [][]byte
+}
+
+// newSessionKeys creates the keys from the current secret followed by the previous ones.
+// If no secret is given a random one is used, so sessions won't survive a restart.
+func newSessionKeys(secrets ...[]byte) *sessionKeys {
+	keys := &sessionKeys{}
+	for _, secret := range secrets {
+		if len(secret) > 0 {
+			keys.secrets = append(keys.secrets, secret)
+		}
+	}
+	if len(keys.secrets) == 0 {
+		secret := make([]byte, SESSION_TOKEN_BYTES)
+		if _, err := rand.Read(secret); err != nil {
+			panic(fmt.Errorf("error generating session secret: %w", err))
+		}
+		keys.secrets = [][]byte{secret}
+	}
+	return keys
+}
+
+// rotate makes secret the one used to sign new cookies, dropping the oldest secrets
+// after MAX_SESSION_SECRETS.
+func (k *sessionKeys) rotate(secret []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.secrets = append([][]byte{secret}, k.secrets...)
+	if len(k.secrets) > MAX_SESSION_SECRETS {
+		k.secrets = k.secrets[:MAX_SESSION_SECRETS]
+	}
+}
+
+// kinds of signed values, part of the signature so a value of one kind can't be used as another
+const SIGNED_SESSION = "session"
+const SIGNED_INVITE = "invite"
+
+func sign(secret []byte, kind string, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(kind + "." + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// seal encodes v as JSON and signs it with the newest secret.
+func (k *sessionKeys) seal(kind string, v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("error encoding %s: %w", kind, err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return payload + "." + sign(k.secrets[0], kind, payload), nil
+}
+
+// open verifies the signature of a sealed value and decodes it into v.
+func (k *sessionKeys) open(kind string, value string, v any) error {
+	payload, signature, found := strings.Cut(value, ".")
+	if !found {
+		return ErrInvalidCookie
+	}
+	if !k.verify(kind, payload, signature) {
+		return ErrInvalidCookie
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ErrInvalidCookie
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalidCookie
+	}
+	return nil
+}
+
+func (k *sessionKeys) verify(kind string, payload string, signature string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, secret := range k.secrets {
+		if hmac.Equal([]byte(signature), []byte(sign(secret, kind, payload))) {
+			return true
+		}
+	}
+	return false
+}
+
+// encode returns the signed value of the cookie for the session.
+func (k *sessionKeys) encode(s session) (string, error) {
+	return k.seal(SIGNED_SESSION, s)
+}
+
+// decode verifies the signature and expiration of a cookie value and returns its session.
+func (k *sessionKeys) decode(value string, now time.Time) (session, error) {
+	var s session
+	if err := k.open(SIGNED_SESSION, value, &s); err != nil {
+		return session{}, err
+	}
+	if now.Unix() >= s.Expires {
+		return session{}, ErrExpiredCookie
+	}
+	return s, nil
+}
+
+// RotateSessionSecret starts signing session cookies with a new secret.
+// Cookies signed with the previous secrets are still accepted until they expire or the secret is
+// dropped after MAX_SESSION_SECRETS rotations, and are signed again when players reconnect.
+func (g *Service) RotateSessionSecret(secret []byte) error {
+	if len(secret) == 0 {
+		return errors.New("session secret can't be empty")
+	}
+	g.sessions.rotate(secret)
+	return nil
+}
+
+// newSessionCookie returns the cookie for a session starting now.
+func (g *Service) newSessionCookie(r *http.Request, player game.PlayerID, room string, token string) (*http.Cookie, error) {
+	expires := time.Now().Add(SESSION_DURATION)
+	value, err := g.sessions.encode(session{Player: player, Room: room, Token: token, Expires: expires.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Cookie{
+		Name:     TOKEN_COOKIE_NAME,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   isSecureRequest(r),
+	}, nil
+}
+
+// readSession returns the session of the cookie sent with the request.
+// Returns http.ErrNoCookie if there is none.
+func (g *Service) readSession(r *http.Request) (session, error) {
+	cookie, err := r.Cookie(TOKEN_COOKIE_NAME)
+	if err != nil {
+		return session{}, err
+	}
+	return g.sessions.decode(cookie.Value, time.Now())
+}
+
+// isSecureRequest reports if the request reached the server, or the proxy in front of it, through https.
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// generateSessionToken returns a random token to identify the owner of a seat.
+func generateSessionToken() string {
+	return generateToken(SESSION_TOKEN_BYTES)
+}
+
+// generateToken returns a random URL safe token made from the given number of bytes.
+func generateToken(size int) string {
+	token := make([]byte, size)
+	if _, err := rand.Read(token); err != nil {
+		panic(fmt.Errorf("error generating token: %w", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
@@ -0,0 +1,108 @@
+package tincho
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// max players a room can be configured to hold
+const MAX_PLAYERS = 10
+
+// max seconds a room can be configured to give each turn
+const MAX_TURN_TIMER = 300
+
+var ErrInvalidPassword = errors.New("invalid password")
+var ErrInvalidMaxPlayers = fmt.Errorf("max players should be between 2 and %d", MAX_PLAYERS)
+var ErrInvalidTurnTimer = fmt.Errorf("turn timer should be between 0 and %d seconds", MAX_TURN_TIMER)
+var ErrMaxPlayersBelowCurrent = errors.New("max players can't be lower than the current players")
+var ErrMaxPlayersAboveLimit = errors.New("max players is above the limit of the server")
+
+func (r *Room) checkPassword(password string) error {
+	hash := r.published().passwordHash
+	if hash != "" && !verifyPassword(hash, password) {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+func (r *Room) gameConfig() UpdateGameConfig {
+	return UpdateGameConfig{
+		CardsInDeck: r.state.CountBaseDeck(),
+		HasPassword: r.passwordHash != "",
+		MaxPlayers:  r.maxPlayers,
+		DeckOptions: r.settings.DeckOptions,
+		Rules:       r.state.Rules(),
+		TurnTimer:   int(r.settings.TurnTimer.Seconds()),
+		Hardcore:    r.settings.Hardcore,
+		Leader:      r.leader,
+	}
+}
+
+// doUpdateSettings lets the leader change the room settings while in the lobby.
+// All the changes are validated before any of them is applied.
+func (r *Room) doUpdateSettings(action Action[ActionUpdateSettingsData]) error {
+	if !r.isLeader(action.PlayerID) {
+		return ErrNotRoomLeader
+	}
+	if r.state.Playing() {
+		return game.ErrGameAlreadyStarted
+	}
+	data := action.Data
+	if data.MaxPlayers != nil {
+		if *data.MaxPlayers < 2 || *data.MaxPlayers > MAX_PLAYERS {
+			return ErrInvalidMaxPlayers
+		}
+		if r.playersLimit > 0 && *data.MaxPlayers > r.playersLimit {
+			return ErrMaxPlayersAboveLimit
+		}
+		if *data.MaxPlayers < len(r.state.GetPlayers()) {
+			return ErrMaxPlayersBelowCurrent
+		}
+	}
+	if data.Rules != nil {
+		if err := data.Rules.Validate(); err != nil {
+			return err
+		}
+	}
+	if data.TurnTimer != nil && (*data.TurnTimer < 0 || *data.TurnTimer > MAX_TURN_TIMER) {
+		return ErrInvalidTurnTimer
+	}
+	var passwordHash string
+	if data.Password != nil {
+		hash, err := hashPassword(*data.Password)
+		if err != nil {
+			return err
+		}
+		passwordHash = hash
+	}
+
+	if data.MaxPlayers != nil {
+		r.maxPlayers = *data.MaxPlayers
+	}
+	if data.DeckOptions != nil {
+		if err := r.state.SetDeck(buildDeck(*data.DeckOptions)); err != nil {
+			return fmt.Errorf("SetDeck: %w", err)
+		}
+		r.settings.DeckOptions = *data.DeckOptions
+	}
+	if data.Password != nil {
+		r.passwordHash = passwordHash
+	}
+	if data.Rules != nil {
+		if err := r.state.SetRules(*data.Rules); err != nil {
+			return fmt.Errorf("SetRules: %w", err)
+		}
+		r.settings.Rules = *data.Rules
+	}
+	if data.TurnTimer != nil {
+		r.settings.TurnTimer = time.Duration(*data.TurnTimer) * time.Second
+	}
+	if data.Hardcore != nil {
+		r.settings.Hardcore = *data.Hardcore
+	}
+	r.broadcastGameConfig()
+	return nil
+}
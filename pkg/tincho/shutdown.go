@@ -0,0 +1,80 @@
+package tincho
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrServiceShuttingDown = errors.New("server is shutting down")
+
+// Draining reports if the service is shutting down and not accepting new rooms or players.
+func (g *Service) Draining() bool {
+	return g.draining.Load()
+}
+
+// Shutdown stops accepting rooms and players, lets everyone know the server is restarting and stops
+// all rooms, waiting until their sockets send every pending update.
+// Rooms are saved before stopping and kept in the store to be restored when the server comes back.
+// Rooms that can't be notified or don't stop before ctx is done are reported in the returned error,
+// without keeping the rest from stopping.
+func (g *Service) Shutdown(ctx context.Context) error {
+	g.draining.Store(true)
+	returnAt := time.Now().Add(g.cfg.RestartEstimate)
+	rooms := g.openRooms()
+	var errs []error
+	for _, room := range rooms {
+		if err := room.notifyShutdown(ctx, returnAt); err != nil {
+			errs = append(errs, fmt.Errorf("error notifying room %s: %w", room.ID, err))
+		}
+	}
+	g.cancel()
+	for _, room := range rooms {
+		if err := room.wait(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error waiting for room %s: %w", room.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notifyShutdown sends the restart notice from the room goroutine, which saves the room right after.
+func (r *Room) notifyShutdown(ctx context.Context, returnAt time.Time) error {
+	sent := make(chan struct{})
+	fn := func() {
+		defer close(sent)
+		r.BroadcastUpdate(Update[UpdateServerRestartingData]{
+			Type: UpdateTypeServerRestarting,
+			Data: UpdateServerRestartingData{ReturnAt: returnAt},
+		})
+	}
+	select {
+	case r.eventsChan <- fn:
+	case <-r.Context.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-sent:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wait blocks until the room goroutine and all of its sockets stop.
+func (r *Room) wait(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		<-r.done
+		r.sockets.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
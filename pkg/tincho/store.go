@@ -0,0 +1,353 @@
+package tincho
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// Store persists rooms so they can be restored after a restart.
+type Store interface {
+	// Save replaces the stored state of the room.
+	Save(snapshot RoomSnapshot) error
+	// Delete removes a room that is not going to be played anymore.
+	Delete(roomID string) error
+	// Load returns all the stored rooms.
+	Load() ([]RoomSnapshot, error)
+}
+
+// RoomSnapshot is everything needed to restore a room, including secrets like passwords and session tokens.
+type RoomSnapshot struct {
+	ID              string                        `json:"id"`
+	CreatedAt       time.Time                     `json:"created_at"`
+	Game            game.Snapshot                 `json:"game"`
+	Seats           []SeatSnapshot                `json:"seats"`
+	Settings        RoomSettings                  `json:"settings"`
+	MaxPlayers      int                           `json:"max_players"`
+	PasswordHash    string                        `json:"password_hash"`
+	Leader          game.PlayerID                 `json:"leader"`
+	Banned          []game.PlayerID               `json:"banned"`
+	AllowSpectators bool                          `json:"allow_spectators"`
+	ChatHistory     []UpdateChatData              `json:"chat_history"`
+	Memory          map[game.PlayerID][]KnownCard `json:"memory"`
+	Series          UpdateSeriesData              `json:"series"`
+	// record of the game being played, if the room has an archive
+	Recording *GameRecord `json:"recording,omitempty"`
+	// shown to players rejoining after a cut or the end of a game
+	LastCut    *UpdateCutData `json:"last_cut,omitempty"`
+	LastRounds []game.Round   `json:"last_rounds,omitempty"`
+}
+
+type SeatSnapshot struct {
+	Player        game.PlayerID `json:"player"`
+	SessionToken  string        `json:"session_token"`
+	Bot           bool          `json:"bot"`
+	BotDifficulty string        `json:"bot_difficulty"`
+}
+
+// snapshot must be called from the room goroutine.
+func (r *Room) snapshot() RoomSnapshot {
+	state := r.state.Snapshot()
+	seats := make([]SeatSnapshot, 0, len(state.Players))
+	for _, p := range state.Players {
+		conn, ok := r.connections[p.ID]
+		if !ok {
+			continue
+		}
+		seats = append(seats, SeatSnapshot{
+			Player:        p.ID,
+			SessionToken:  conn.SessionToken,
+			Bot:           conn.Bot,
+			BotDifficulty: conn.BotDifficulty,
+		})
+	}
+	banned := make([]game.PlayerID, 0, len(r.banned))
+	for playerID := range r.banned {
+		banned = append(banned, playerID)
+	}
+	slices.Sort(banned)
+	memory := make(map[game.PlayerID][]KnownCard, len(r.memory))
+	for viewer := range r.memory {
+		memory[viewer] = r.memory.known(viewer)
+	}
+	var recording *GameRecord
+	if r.recording != nil {
+		rec := *r.recording
+		rec.Deals = slices.Clone(rec.Deals)
+		rec.Events = slices.Clone(rec.Events)
+		recording = &rec
+	}
+	return RoomSnapshot{
+		ID:              r.ID,
+		CreatedAt:       r.createdAt,
+		Game:            state,
+		Seats:           seats,
+		Settings:        r.settings,
+		MaxPlayers:      r.maxPlayers,
+		PasswordHash:    r.passwordHash,
+		Leader:          r.leader,
+		Banned:          banned,
+		AllowSpectators: r.allowSpectators,
+		ChatHistory:     slices.Clone(r.chatHistory),
+		Memory:          memory,
+		Series:          r.series.data(),
+		Recording:       recording,
+		LastCut:         r.lastCut,
+		LastRounds:      slices.Clone(r.lastRounds),
+	}
+}
+
+// NewRoomFromSnapshot restores a room with all its players disconnected.
+// Room.resume must be called once the room is started to bring back bots and watch for actions.
+func NewRoomFromSnapshot(logger *slog.Logger, ctx context.Context, ctxCancel context.CancelFunc, snapshot RoomSnapshot) *Room {
+	room := NewRoomWithSettings(logger, ctx, ctxCancel, snapshot.ID, nil, snapshot.MaxPlayers, snapshot.Settings)
+	room.state = game.NewTinchoFromSnapshot(snapshot.Game)
+	// the room timeout keeps counting from before the restart, while the idle timeout starts over
+	// so players get the whole of it to reconnect
+	room.createdAt = snapshot.CreatedAt
+	room.passwordHash = snapshot.PasswordHash
+	room.leader = snapshot.Leader
+	room.allowSpectators = snapshot.AllowSpectators
+	if snapshot.ChatHistory != nil {
+		room.chatHistory = snapshot.ChatHistory
+	}
+	for _, playerID := range snapshot.Banned {
+		room.banned[playerID] = true
+	}
+	for viewer, known := range snapshot.Memory {
+		room.memory[viewer] = known
+	}
+	room.recording = snapshot.Recording
+	room.lastCut = snapshot.LastCut
+	room.lastRounds = snapshot.LastRounds
+	room.series = newSeries(snapshot.Series.Length)
+	room.series.played = snapshot.Series.Played
+	for playerID, wins := range snapshot.Series.Wins {
+		room.series.wins[playerID] = wins
+	}
+	now := time.Now()
+	for _, seat := range snapshot.Seats {
+		player, ok := room.state.GetPlayer(seat.Player)
+		if !ok {
+			continue
+		}
+		conn := newConnectionForPlayer(player, seat.SessionToken)
+		conn.Bot = seat.Bot
+		conn.BotDifficulty = seat.BotDifficulty
+		room.connections[player.ID] = conn
+		if !conn.Bot {
+			room.disconnected[player.ID] = now
+		}
+	}
+	room.publish()
+	return room
+}
+
+// resume starts watching the restored seats, restarts the bots and gives disconnected players
+// their grace period before their seat is taken over.
+func (r *Room) resume() {
+	r.schedule(0, func() {
+		for _, conn := range r.connections {
+			go r.watchPlayer(conn)
+		}
+		for _, p := range r.state.GetPlayers() {
+			conn, ok := r.connections[p.ID]
+			if !ok {
+				continue
+			}
+			if !conn.Bot {
+				r.scheduleTakeover(p.ID)
+				continue
+			}
+			if r.takeover == nil {
+				r.logger.Warn("can't restart bot without a takeover function", "player_id", p.ID)
+				continue
+			}
+			difficulty := conn.BotDifficulty
+			if difficulty == "" {
+				difficulty = "medium"
+			}
+			state := TakeoverState{
+				Players:       r.getMarshalledPlayers(),
+				Hand:          r.memory.knownHand(p.ID, len(conn.Hand)),
+				LastDiscarded: r.state.LastDiscarded(),
+			}
+			logger := r.logger.With("player_id", p.ID, "component", "bot")
+			if err := r.takeover(r.Context, logger, conn, difficulty, state); err != nil {
+				r.logger.Error("error restarting bot", "err", err, "player_id", p.ID)
+				continue
+			}
+			r.promptSeat(conn)
+		}
+	})
+}
+
+// save hands the room to the saver if it has a store, so a slow disk doesn't hold up the game.
+func (r *Room) save() {
+	if r.saver == nil || r.closed {
+		return
+	}
+	r.saver.save(r.snapshot())
+}
+
+// roomSaver writes the snapshots of a room from its own goroutine. Snapshots taken while a write
+// is in progress replace each other, so only the latest one is written next.
+// Errors are logged as the game can continue without it.
+type roomSaver struct {
+	store  Store
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending *RoomSnapshot
+
+	wake    chan struct{}
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+func newRoomSaver(store Store, logger *slog.Logger) *roomSaver {
+	return &roomSaver{
+		store:   store,
+		logger:  logger,
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (s *roomSaver) save(snapshot RoomSnapshot) {
+	s.mu.Lock()
+	s.pending = &snapshot
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *roomSaver) run() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.wake:
+			s.write()
+		case <-s.stopped:
+			s.write()
+			return
+		}
+	}
+}
+
+func (s *roomSaver) write() {
+	s.mu.Lock()
+	snapshot := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if snapshot == nil {
+		return
+	}
+	if err := s.store.Save(*snapshot); err != nil {
+		s.logger.Error("error saving room", "err", err)
+	}
+}
+
+// stop writes the last snapshot and waits until the writes are done, so the room can be deleted
+// from the store without a late write bringing it back.
+func (s *roomSaver) stop() {
+	close(s.stopped)
+	<-s.done
+}
+
+// FileStore keeps each room in a JSON file inside a directory.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating store directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(roomID string) string {
+	return filepath.Join(s.dir, roomID+".json")
+}
+
+// Save writes to a temporary file and renames it so a crash never leaves a half written room.
+func (s *FileStore) Save(snapshot RoomSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("error encoding room: %w", err)
+	}
+	return writeFileAtomic(s.path(snapshot.ID), data)
+}
+
+// writeFileAtomic writes to a temporary file in the same directory and renames it,
+// so readers never see a half written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error renaming file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Delete(roomID string) error {
+	if err := os.Remove(s.path(roomID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting room: %w", err)
+	}
+	return nil
+}
+
+// Load returns every room that could be read, along with the errors for the ones that couldn't.
+func (s *FileStore) Load() ([]RoomSnapshot, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading store directory: %w", err)
+	}
+	snapshots := make([]RoomSnapshot, 0, len(entries))
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error reading %s: %w", entry.Name(), err))
+			continue
+		}
+		var snapshot RoomSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			errs = append(errs, fmt.Errorf("error decoding %s: %w", entry.Name(), err))
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, errors.Join(errs...)
+}
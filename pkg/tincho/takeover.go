@@ -0,0 +1,121 @@
+package tincho
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+// max seconds a room can be configured to wait before a takeover
+const MAX_TAKEOVER_GRACE_PERIOD = 600
+
+// TakeoverFunc starts a bot of the given difficulty that plays the connection's seat until ctx is done
+// or the connection is disconnected. It must attach itself to the connection before returning, so a
+// reconnecting player can stop it with Connection.Disconnect, which must not return until the bot
+// stopped reading updates and queuing actions.
+type TakeoverFunc func(ctx context.Context, logger *slog.Logger, conn *Connection, difficulty string, state TakeoverState) error
+
+// TakeoverState is what the player legitimately knew when the bot took their seat.
+type TakeoverState struct {
+	Players []MarshalledPlayer
+	// the player's own hand with unknown cards left empty
+	Hand          []game.Card
+	LastDiscarded game.Card
+}
+
+// scheduleTakeover checks if the player is still disconnected after the grace period
+// and gives the seat to a bot if so.
+func (r *Room) scheduleTakeover(playerID game.PlayerID) {
+	if r.takeover == nil || r.settings.TakeoverDifficulty == "" {
+		return
+	}
+	disconnectedAt, disconnected := r.disconnected[playerID]
+	if !disconnected {
+		return
+	}
+	r.schedule(r.settings.TakeoverGracePeriod, func() {
+		at, disconnected := r.disconnected[playerID]
+		if !disconnected || !at.Equal(disconnectedAt) || r.takenOver[playerID] || !r.state.Playing() {
+			return
+		}
+		if err := r.startTakeover(playerID); err != nil {
+			r.logger.Error("error starting takeover", "err", err, "player_id", playerID)
+		}
+	})
+}
+
+func (r *Room) startTakeover(playerID game.PlayerID) error {
+	conn, ok := r.getConnection(playerID)
+	if !ok {
+		return fmt.Errorf("%w: %s", game.ErrPlayerNotFound, playerID)
+	}
+	conn.ClearPendingUpdates()
+	state := TakeoverState{
+		Players:       r.getMarshalledPlayers(),
+		Hand:          r.memory.knownHand(playerID, len(conn.Hand)),
+		LastDiscarded: r.state.LastDiscarded(),
+	}
+	logger := r.logger.With("player_id", playerID, "component", "takeover")
+	if err := r.takeover(r.Context, logger, conn, r.settings.TakeoverDifficulty, state); err != nil {
+		return fmt.Errorf("takeover: %w", err)
+	}
+	r.takenOver[playerID] = true
+	r.logger.Info(fmt.Sprintf("Bot took over seat #%s: %s", r.ID, playerID))
+	r.BroadcastUpdate(Update[UpdateTakeoverData]{
+		Type: UpdateTypeTakeoverStarted,
+		Data: UpdateTakeoverData{
+			Player:     playerID,
+			Difficulty: r.settings.TakeoverDifficulty,
+		},
+	})
+	r.promptSeat(conn)
+	return nil
+}
+
+// endTakeover gives the seat back to a reconnected player. The bot must be stopped by then.
+func (r *Room) endTakeover(playerID game.PlayerID) {
+	if !r.takenOver[playerID] {
+		return
+	}
+	delete(r.takenOver, playerID)
+	r.logger.Info(fmt.Sprintf("Player returned to seat #%s: %s", r.ID, playerID))
+	r.BroadcastUpdate(Update[UpdateTakeoverData]{
+		Type: UpdateTypeTakeoverEnded,
+		Data: UpdateTakeoverData{Player: playerID},
+	})
+}
+
+// promptSeat re-sends the update a seat needs to act if it has something pending.
+func (r *Room) promptSeat(conn *Connection) {
+	if conn.PendingFirstPeek {
+		r.TargetedUpdate(conn.ID, Update[UpdateStartNextRoundData]{
+			Type: UpdateTypeStartNextRound,
+			Data: UpdateStartNextRoundData{
+				Players:    r.getMarshalledPlayers(),
+				TopDiscard: r.state.LastDiscarded(),
+			},
+		})
+		return
+	}
+	if !r.state.AllPlayersFirstPeeked() || r.state.PlayerToPlay().ID != conn.ID {
+		return
+	}
+	if pending := r.state.GetPendingStorage(); pending != (game.Card{}) {
+		r.TargetedUpdate(conn.ID, Update[UpdateDrawData]{
+			Type: UpdateTypeDraw,
+			Data: UpdateDrawData{
+				Player: conn.ID,
+				Source: r.state.LastDrawSource(),
+				Card:   pending,
+				Effect: pending.GetEffect(),
+			},
+		})
+		return
+	}
+	r.TargetedUpdate(conn.ID, Update[UpdateTurnData]{
+		Type: UpdateTypeTurn,
+		Data: UpdateTurnData{Player: conn.ID},
+	})
+}
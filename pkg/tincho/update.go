@@ -1,6 +1,8 @@
 package tincho
 
 import (
+	"time"
+
 	"github.com/manuelpepe/tincho/pkg/game"
 )
 
@@ -22,6 +24,19 @@ const (
 	UpdateTypeStartNextRound      UpdateType = "start_next_round"
 	UpdateTypeEndGame             UpdateType = "end_game"
 	UpdateTypeRejoin              UpdateType = "rejoin_state"
+	UpdateTypeSpectatorsChanged   UpdateType = "spectators_changed"
+	UpdateTypeChat                UpdateType = "chat"
+	UpdateTypeLeaderChanged       UpdateType = "leader_changed"
+	UpdateTypePlayerKicked        UpdateType = "player_kicked"
+	UpdateTypeTakeoverStarted     UpdateType = "takeover_started"
+	UpdateTypeTakeoverEnded       UpdateType = "takeover_ended"
+	UpdateTypeStartCountdown      UpdateType = "start_countdown"
+	UpdateTypeSeriesChanged       UpdateType = "series_changed"
+	UpdateTypeRematchVotes        UpdateType = "rematch_votes"
+	UpdateTypeServerRestarting    UpdateType = "server_restarting"
+	UpdateTypeSystemMessage       UpdateType = "system_message"
+	UpdateTypeRoomClosing         UpdateType = "room_closing"
+	UpdateTypeRoomClosed          UpdateType = "room_closed"
 )
 
 type UpdateData interface {
@@ -38,32 +53,63 @@ type UpdateData interface {
 		UpdateCutData |
 		UpdateErrorData |
 		UpdateEndGameData |
-		UpdateTypeRejoinData
+		UpdateTypeRejoinData |
+		UpdateSpectatorsChangedData |
+		UpdateChatData |
+		UpdateLeaderChangedData |
+		UpdatePlayerKickedData |
+		UpdateTakeoverData |
+		UpdateStartCountdownData |
+		UpdateSeriesData |
+		UpdateRematchVotesData |
+		UpdateServerRestartingData |
+		UpdateSystemMessageData |
+		UpdateRoomClosingData |
+		UpdateRoomClosedData
 }
 
 type Update[T UpdateData] struct {
 	Type UpdateType `json:"type"`
 	Data T          `json:"data"`
+	// sequence number of the update for the connection recieving it, starting at 1
+	Seq int `json:"seq,omitempty"`
 }
 
+// TypedUpdate is an interface used to pass around Update[T] types without needing to
+// know the exact type of T. It can't be implemented outside this package, use `Update[T UpdateData]` instead.
 type TypedUpdate interface {
 	GetType() UpdateType
+	GetSeq() int
+	withSeq(seq int) TypedUpdate
 }
 
 func (u Update[T]) GetType() UpdateType {
 	return u.Type
 }
 
+func (u Update[T]) GetSeq() int {
+	return u.Seq
+}
+
+func (u Update[T]) withSeq(seq int) TypedUpdate {
+	u.Seq = seq
+	return u
+}
+
 type UpdatePlayersChangedData struct {
 	Players []MarshalledPlayer `json:"players"`
+	Leader  game.PlayerID      `json:"leader"`
 }
 
 type UpdateGameConfig struct {
-	CardsInDeck int `json:"cardsInDeck"`
-	// Maybe:
-	//  - has password
-	//	- deck options
-	// 	- room owner
+	CardsInDeck int           `json:"cardsInDeck"`
+	HasPassword bool          `json:"hasPassword"`
+	MaxPlayers  int           `json:"maxPlayers"`
+	DeckOptions DeckOptions   `json:"deck"`
+	Rules       game.Rules    `json:"rules"`
+	TurnTimer   int           `json:"turnTimer"`
+	Hardcore    bool          `json:"hardcore"`
+	Leader      game.PlayerID `json:"leader"`
 }
 
 type UpdateStartNextRoundData struct {
@@ -129,13 +175,119 @@ type UpdateEndGameData struct {
 	Rounds []game.Round `json:"rounds"`
 }
 
+// RoomPhase is the screen a room is in, used to rebuild it on rejoin.
+type RoomPhase string
+
+const (
+	// waiting for the game to start
+	RoomPhaseLobby RoomPhase = "lobby"
+	// dealt cards and waiting for players to peek their first two cards
+	RoomPhaseFirstPeek RoomPhase = "first_peek"
+	// players taking turns
+	RoomPhaseTurns RoomPhase = "turns"
+	// the last game ended and the room waits for a rematch
+	RoomPhaseEnded RoomPhase = "ended"
+)
+
 type UpdateTypeRejoinData struct {
-	Players          []MarshalledPlayer `json:"players"`
-	CurrentTurn      game.PlayerID      `json:"currentTurn"`
-	CardInHand       bool               `json:"cardInHand"`
-	CardInHandVal    *game.Card         `json:"cardInHandValue"`
-	CardInHandSource *game.DrawSource   `json:"cardInHandSource"`
-	LastDiscarded    *game.Card         `json:"lastDiscarded"`
-	CardsInDeck      int                `json:"cardsInDeck"`
-	CardsInDrawPile  int                `json:"cardsInDrawPile"`
+	Phase   RoomPhase          `json:"phase"`
+	Players []MarshalledPlayer `json:"players"`
+	Config  UpdateGameConfig   `json:"config"`
+	// only set while players take turns
+	CurrentTurn      game.PlayerID    `json:"currentTurn"`
+	CardInHand       bool             `json:"cardInHand"`
+	CardInHandVal    *game.Card       `json:"cardInHandValue"`
+	CardInHandSource *game.DrawSource `json:"cardInHandSource"`
+	LastDiscarded    *game.Card       `json:"lastDiscarded"`
+	CardsInDeck      int              `json:"cardsInDeck"`
+	CardsInDrawPile  int              `json:"cardsInDrawPile"`
+	// cards the player has seen this round in the positions they are now, empty for spectators and hardcore rooms
+	KnownCards []KnownCard `json:"knownCards"`
+	// scores of the rounds of the current game, or of the last one if it ended
+	Rounds []game.Round `json:"rounds"`
+	// last cut of the current game, nil before the first one
+	LastCut *UpdateCutData `json:"lastCut"`
+	// seconds left to auto start the game, nil if there is no countdown
+	Countdown    *int             `json:"countdown"`
+	RematchVotes []game.PlayerID  `json:"rematchVotes"`
+	Chat         []UpdateChatData `json:"chat"`
+	Leader       game.PlayerID    `json:"leader"`
+	Series       UpdateSeriesData `json:"series"`
+}
+
+type UpdateSpectatorsChangedData struct {
+	Allowed    bool            `json:"allowed"`
+	Spectators []game.PlayerID `json:"spectators"`
+}
+
+type UpdateChatData struct {
+	Player   game.PlayerID `json:"player"`
+	Message  string        `json:"message,omitempty"`
+	Reaction string        `json:"reaction,omitempty"`
+	// unix timestamp in milliseconds
+	SentAt int64 `json:"sentAt"`
+}
+
+type UpdateLeaderChangedData struct {
+	Leader game.PlayerID `json:"leader"`
+}
+
+type UpdatePlayerKickedData struct {
+	Player game.PlayerID `json:"player"`
+	Banned bool          `json:"banned"`
+}
+
+type UpdateTakeoverData struct {
+	Player     game.PlayerID `json:"player"`
+	Difficulty string        `json:"difficulty,omitempty"`
+}
+
+// UpdateStartCountdownData is sent when enough players are ready and the game is about to start,
+// and again with Cancelled set if a player stops being ready before it does.
+type UpdateStartCountdownData struct {
+	Seconds   int  `json:"seconds"`
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// UpdateSeriesData is the scoreboard of the games played in the room.
+type UpdateSeriesData struct {
+	// best-of-N games, 0 means games are not part of a series
+	Length   int                   `json:"length"`
+	Played   int                   `json:"played"`
+	Wins     map[game.PlayerID]int `json:"wins"`
+	Finished bool                  `json:"finished"`
+	Winner   game.PlayerID         `json:"winner,omitempty"`
+}
+
+type UpdateRematchVotesData struct {
+	Votes []game.PlayerID `json:"votes"`
+}
+
+// UpdateServerRestartingData is sent before the server shuts down. Rooms are restored when it comes back.
+type UpdateServerRestartingData struct {
+	// estimated time the server will be back
+	ReturnAt time.Time `json:"returnAt"`
+}
+
+// UpdateRoomClosingData warns the players that the room is about to close.
+type UpdateRoomClosingData struct {
+	Reason   CloseReason `json:"reason"`
+	ClosesAt time.Time   `json:"closesAt"`
+}
+
+// UpdateRoomClosedData is the last update sent by a room.
+type UpdateRoomClosedData struct {
+	Reason CloseReason `json:"reason"`
+	// explanation given by the admin that closed the room
+	Message string `json:"message,omitempty"`
+	// players with their points so far, best first
+	Standings []MarshalledPlayer `json:"standings"`
+	// rounds played in the game that was interrupted, or in the last game
+	Rounds []game.Round `json:"rounds"`
+}
+
+// UpdateSystemMessageData is a message from the operators of the server.
+type UpdateSystemMessageData struct {
+	Message string `json:"message"`
+	SentAt  int64  `json:"sentAt"`
 }
@@ -0,0 +1,64 @@
+package tincho
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/manuelpepe/tincho/pkg/game"
+)
+
+var ErrRoomClosed = errors.New("room closed")
+
+// roomView is an immutable snapshot of the room published by the room goroutine after every change.
+// Other goroutines read it to answer queries without touching the room state.
+type roomView struct {
+	info         RoomInfo
+	passwordHash string
+	closed       bool
+
+	winner      *game.Player
+	winnerErr   error
+	totalTurns  int
+	totalRounds int
+
+	connections map[game.PlayerID]*Connection
+}
+
+// publish replaces the view of the room with the current state.
+// Must only be called from the room goroutine, or before the room starts.
+func (r *Room) publish() {
+	view := &roomView{
+		info:         r.info(),
+		passwordHash: r.passwordHash,
+		closed:       r.closed,
+		totalTurns:   r.state.TotalTurns(),
+		totalRounds:  r.state.TotalRounds(),
+		connections:  maps.Clone(r.connections),
+	}
+	if winner, err := r.state.Winner(); err != nil {
+		view.winnerErr = err
+	} else {
+		w := *winner
+		w.Hand = slices.Clone(winner.Hand)
+		view.winner = &w
+	}
+	r.view.Store(view)
+}
+
+func (r *Room) published() *roomView {
+	return r.view.Load()
+}
+
+// query runs fn in the room goroutine and waits for it to finish.
+// Returns ErrRoomClosed without running fn if the room stops first.
+func (r *Room) query(fn func()) error {
+	done := make(chan struct{})
+	select {
+	case r.queriesChan <- func() { defer close(done); fn() }:
+	case <-r.Context.Done():
+		return ErrRoomClosed
+	}
+	<-done
+	return nil
+}
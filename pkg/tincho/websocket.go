@@ -0,0 +1,114 @@
+package tincho
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/manuelpepe/tincho/pkg/metrics"
+)
+
+// max size in bytes of a message sent by a client, bigger messages close the socket
+const MAX_MESSAGE_SIZE = 4096
+
+// max nesting of objects and arrays in a message sent by a client
+const MAX_JSON_DEPTH = 8
+
+// time without a pong before the socket is considered dead, must be longer than PING_INTERVAL
+const PONG_WAIT = 60 * time.Second
+const PING_INTERVAL = 10 * time.Second
+
+// time given to write the close message before closing the socket
+const CLOSE_WAIT = time.Second
+
+var ErrMessageTooLarge = fmt.Errorf("message larger than %d bytes", MAX_MESSAGE_SIZE)
+var ErrJSONTooDeep = fmt.Errorf("message nested deeper than %d levels", MAX_JSON_DEPTH)
+
+// allowedOrigin reports if a websocket can be opened from the origin of the request.
+// Requests without an origin, i.e. not from a browser, are always allowed. With no allowed origins
+// configured only the origin of the server itself is allowed, and "*" allows any origin.
+func (g *Service) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if len(g.cfg.AllowedOrigins) == 0 {
+		return strings.EqualFold(u.Host, r.Host)
+	}
+	return slices.ContainsFunc(g.cfg.AllowedOrigins, func(allowed string) bool {
+		return allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+	})
+}
+
+// checkOrigin is used by the websocket upgrader, counting the rejected origins.
+func (h *Handlers) checkOrigin(r *http.Request) bool {
+	if !h.service.allowedOrigin(r) {
+		h.logger.Warn("Rejected websocket from origin", "origin", r.Header.Get("Origin"))
+		metrics.IncWebsocketViolation("origin")
+		return false
+	}
+	return true
+}
+
+// closeWithCode sends a close message to the client with the reason it's being disconnected.
+// Can be called while the write loop is running.
+func closeWithCode(ws *websocket.Conn, code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(CLOSE_WAIT))
+}
+
+// violation returns the close code and metric label of an error reading a message that
+// should disconnect the client, or false if it's not a violation.
+func violation(err error) (int, string, bool) {
+	var netErr interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit), errors.Is(err, ErrMessageTooLarge):
+		return websocket.CloseMessageTooBig, "too_big", true
+	case errors.Is(err, ErrJSONTooDeep):
+		return websocket.ClosePolicyViolation, "too_deep", true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return websocket.ClosePolicyViolation, "pong_timeout", true
+	}
+	return 0, "", false
+}
+
+// checkJSONDepth returns ErrJSONTooDeep if objects and arrays in message are nested deeper than
+// MAX_JSON_DEPTH, without decoding it. Brackets inside strings are ignored.
+func checkJSONDepth(message []byte) error {
+	depth := 0
+	inString := false
+	escaped := false
+	for _, c := range message {
+		if inString {
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > MAX_JSON_DEPTH {
+				return ErrJSONTooDeep
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return nil
+}